@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/Gravitalia/gravitalia/helpers"
+	"github.com/Gravitalia/gravitalia/problem"
+)
+
+// Policy tells who can reach a route
+type Policy int
+
+const (
+	// Anonymous allows every caller, connected or not
+	Anonymous Policy = iota
+	// User requires a user token
+	User
+	// Service requires a signed request of an internal service
+	Service
+	// UserOrService requires a user token or an internal service,
+	// which then acts on behalf of a user
+	UserOrService
+	// ServiceOrStaff requires an internal service, or a user
+	// whose staff roles grant every scope of the route
+	ServiceOrStaff
+)
+
+// Authenticator reads the Authorization header of every request
+type Authenticator struct {
+	// services are the keys and scopes of internal services
+	services config.Services
+	nonces   NonceStore
+	// roles of users, nil if users have no staff roles
+	roles RoleLoader
+}
+
+// NewAuthenticator creates an authenticator accepting user tokens,
+// and requests signed by internal services. nonces must be shared
+// by every instance to reject replayed requests
+func NewAuthenticator(services config.Services, nonces NonceStore, roles RoleLoader) *Authenticator {
+	return &Authenticator{services: services, nonces: nonces, roles: roles}
+}
+
+// authenticate returns the caller, nil if anonymous
+func (a *Authenticator) authenticate(req *http.Request) (*Principal, error) {
+	token := req.Header.Get("Authorization")
+	if token == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(token, SignatureScheme+" ") {
+		return a.verifySignature(req, token)
+	}
+
+	claims, err := helpers.ParseToken(req.Context(), token)
+	if err != nil {
+		return nil, problem.Wrap(problem.InvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return nil, problem.New(problem.InvalidToken).WithDetail("token has no subject")
+	}
+
+	p := &Principal{
+		Vanity: claims.Subject,
+		Scopes: claims.Scope,
+	}
+	if claims.ExpiresAt != nil {
+		p.ExpiresAt = claims.ExpiresAt.Time
+	}
+	if a.roles != nil {
+		p.loadRoles = func(ctx context.Context) ([]string, error) {
+			return a.roles(ctx, p.Vanity)
+		}
+	}
+
+	return p, nil
+}
+
+// Middleware authenticates the request and saves the caller in its
+// context. Invalid tokens are always rejected, even on anonymous routes
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p, err := a.authenticate(req)
+		if err != nil {
+			problem.Write(w, req, err)
+			return
+		}
+
+		if p != nil {
+			req = req.WithContext(WithPrincipal(req.Context(), p))
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Require only lets callers allowed by the policy reach the route.
+// Internal services, and staff for ServiceOrStaff, must also
+// be granted every scope
+func Require(policy Policy, scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if err := check(req.Context(), policy, scopes, FromContext(req.Context())); err != nil {
+				problem.Write(w, req, err)
+				return
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// check returns the problem sent to callers not allowed by the policy
+func check(ctx context.Context, policy Policy, scopes []string, p *Principal) error {
+	switch policy {
+	case User:
+		if p == nil {
+			return problem.New(problem.MissingToken)
+		}
+		if p.IsService() {
+			return problem.New(problem.Forbidden).WithDetail("route requires a user token")
+		}
+	case Service:
+		if p == nil {
+			return problem.New(problem.MissingToken)
+		}
+		if !p.IsService() {
+			return problem.New(problem.Forbidden).WithDetail("route is reserved to internal services")
+		}
+	case UserOrService, ServiceOrStaff:
+		if p == nil {
+			return problem.New(problem.MissingToken)
+		}
+	}
+
+	// Users only need roles on staff routes, other
+	// routes act on their own account
+	if !p.IsService() && policy != ServiceOrStaff {
+		return nil
+	}
+
+	for _, scope := range scopes {
+		granted, err := p.Can(ctx, scope)
+		if err != nil {
+			return problem.Wrap(problem.DatabaseError, err)
+		}
+		if !granted {
+			return problem.New(problem.Forbidden).WithDetail("%v is not granted %v", p, scope)
+		}
+	}
+
+	return nil
+}
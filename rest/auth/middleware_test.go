@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Gravitalia/gravitalia/config"
+)
+
+func TestRequire(t *testing.T) {
+	user := &Principal{Vanity: "user"}
+	service := &Principal{Service: "moderation", Scopes: []string{ScopeSuspendUsers}}
+	moderator := &Principal{Vanity: "moderator", loadRoles: func(context.Context) ([]string, error) {
+		return []string{string(Moderator)}, nil
+	}}
+
+	for _, test := range []struct {
+		policy    Policy
+		scopes    []string
+		principal *Principal
+		code      int
+	}{
+		{Anonymous, nil, nil, http.StatusOK},
+		{Anonymous, nil, user, http.StatusOK},
+		{User, nil, nil, http.StatusUnauthorized},
+		{User, nil, user, http.StatusOK},
+		{User, nil, service, http.StatusForbidden},
+		{Service, nil, user, http.StatusForbidden},
+		{Service, nil, service, http.StatusOK},
+		{Service, []string{ScopeSuspendUsers}, service, http.StatusOK},
+		{Service, []string{ScopeDeleteUsers}, service, http.StatusForbidden},
+		{UserOrService, nil, nil, http.StatusUnauthorized},
+		{UserOrService, []string{ScopeExportUsers}, user, http.StatusOK},
+		{UserOrService, []string{ScopeExportUsers}, service, http.StatusForbidden},
+		{UserOrService, nil, service, http.StatusOK},
+		{ServiceOrStaff, nil, nil, http.StatusUnauthorized},
+		{ServiceOrStaff, []string{ScopeSuspendUsers}, user, http.StatusForbidden},
+		{ServiceOrStaff, []string{ScopeSuspendUsers}, moderator, http.StatusOK},
+		{ServiceOrStaff, []string{ScopeDeleteUsers}, moderator, http.StatusForbidden},
+		{ServiceOrStaff, []string{ScopeSuspendUsers}, service, http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.principal != nil {
+			req = req.WithContext(WithPrincipal(req.Context(), test.principal))
+		}
+
+		rec := httptest.NewRecorder()
+		Require(test.policy, test.scopes...)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(rec, req)
+
+		if rec.Code != test.code {
+			t.Errorf("policy %v %v with %+v: got %v, want %v", test.policy, test.scopes, test.principal, rec.Code, test.code)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	a := NewAuthenticator(config.Services{}, NewMemoryNonces(), nil)
+
+	var got *Principal
+	handler := a.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		got = FromContext(req.Context())
+	}))
+
+	for _, test := range []struct {
+		header  string
+		code    int
+		service bool
+	}{
+		{"", http.StatusOK, false},
+		{"not-a-token", http.StatusUnauthorized, false},
+		{SignatureScheme + " service=unknown", http.StatusUnauthorized, false},
+	} {
+		got = nil
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.code {
+			t.Errorf("%q: got %v, want %v", test.header, rec.Code, test.code)
+		}
+		if test.service != (got.IsService()) {
+			t.Errorf("%q: got principal %+v", test.header, got)
+		}
+	}
+}
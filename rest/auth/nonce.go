@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// NonceStore remembers the nonces of signed requests,
+// to reject requests sent twice
+type NonceStore interface {
+	// Remember saves the nonce for ttl, and returns false
+	// if it was already saved
+	Remember(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// NonceFunc allows to use a function as a NonceStore
+type NonceFunc func(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+
+// Remember calls f(ctx, nonce, ttl)
+func (f NonceFunc) Remember(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	return f(ctx, nonce, ttl)
+}
+
+// MemoryNonces keeps nonces in memory. It only protects a single
+// instance, replicas must share a store such as Memcached
+type MemoryNonces struct {
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	swept time.Time
+}
+
+// NewMemoryNonces creates an empty in-memory nonce store
+func NewMemoryNonces() *MemoryNonces {
+	return &MemoryNonces{seen: make(map[string]time.Time)}
+}
+
+// Remember saves the nonce for ttl, and returns false
+// if it was already saved
+func (m *MemoryNonces) Remember(_ context.Context, nonce string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	// Forget expired nonces from time to time
+	if now.Sub(m.swept) > time.Minute {
+		for n, expiresAt := range m.seen {
+			if now.After(expiresAt) {
+				delete(m.seen, n)
+			}
+		}
+		m.swept = now
+	}
+
+	if expiresAt, ok := m.seen[nonce]; ok && now.Before(expiresAt) {
+		return false, nil
+	}
+
+	m.seen[nonce] = now.Add(ttl)
+	return true, nil
+}
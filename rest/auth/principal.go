@@ -0,0 +1,120 @@
+// Package auth identifies who sends a request, once, and
+// checks that routes are only reached by allowed callers.
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// Vanity of the user, empty for services
+	Vanity string
+	// Scopes granted by the token, such as "identity"
+	Scopes []string
+	// ExpiresAt is the end of validity of the token
+	ExpiresAt time.Time
+	// Service is the name of the internal service which signed
+	// the request, empty for users
+	Service string
+
+	// loadRoles returns the staff roles of the user, only
+	// read by the requests needing them
+	loadRoles func(ctx context.Context) ([]string, error)
+	rolesOnce sync.Once
+	roles     []string
+	rolesErr  error
+}
+
+// IsService checks if the caller is an internal service
+func (p *Principal) IsService() bool {
+	return p != nil && p.Service != ""
+}
+
+// HasScope checks if the token grants the scope
+func (p *Principal) HasScope(scope string) bool {
+	if p == nil {
+		return false
+	}
+
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Roles returns the staff roles of the user, none for services
+func (p *Principal) Roles(ctx context.Context) ([]string, error) {
+	if p == nil || p.loadRoles == nil {
+		return nil, nil
+	}
+
+	p.rolesOnce.Do(func() {
+		p.roles, p.rolesErr = p.loadRoles(ctx)
+	})
+
+	return p.roles, p.rolesErr
+}
+
+// Can checks if the caller is granted the scope: internal services
+// by their configuration, users by their staff roles
+func (p *Principal) Can(ctx context.Context, scope string) (bool, error) {
+	if p.IsService() {
+		return p.HasScope(scope), nil
+	}
+
+	roles, err := p.Roles(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, role := range roles {
+		if Role(role).Grants(scope) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// String identifies the caller in logs, such
+// as "user:realhinome" or "service:moderation"
+func (p *Principal) String() string {
+	switch {
+	case p == nil:
+		return "anonymous"
+	case p.IsService():
+		return "service:" + p.Service
+	}
+
+	return "user:" + p.Vanity
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the caller of the request,
+// or nil if the request is anonymous
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
+
+// Vanity returns the vanity of the connected user,
+// or an empty string for anonymous and service requests
+func Vanity(ctx context.Context) string {
+	if p := FromContext(ctx); p != nil {
+		return p.Vanity
+	}
+
+	return ""
+}
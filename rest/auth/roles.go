@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"sort"
+)
+
+// Scopes granted to internal services and staff roles
+const (
+	ScopeSuspendUsers  = "users:suspend"
+	ScopeDeleteUsers   = "users:delete"
+	ScopeExportUsers   = "users:export"
+	ScopeReviewReports = "reports:review"
+)
+
+// Role of a staff member, derived from the flags
+// of the account at the identity provider
+type Role string
+
+// Every staff role
+const (
+	Moderator Role = "moderator"
+	Support   Role = "support"
+	Admin     Role = "admin"
+)
+
+// permissions lists the scopes granted to each role
+var permissions = map[Role][]string{
+	Moderator: {ScopeSuspendUsers, ScopeReviewReports},
+	Support:   {ScopeExportUsers},
+	Admin:     {ScopeSuspendUsers, ScopeDeleteUsers, ScopeExportUsers, ScopeReviewReports},
+}
+
+// Grants checks if the role is granted the scope
+func (r Role) Grants(scope string) bool {
+	for _, s := range permissions[r] {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RoleLoader returns the roles saved for a user
+type RoleLoader func(ctx context.Context, vanity string) ([]string, error)
+
+// RolesFromFlags returns the roles of an account whose flags contain
+// the flag of the role, such as {"moderator": 4, "admin": 8}.
+// Unknown roles are ignored
+func RolesFromFlags(flags int, roleFlags map[string]int) []string {
+	roles := make([]string, 0)
+
+	for role, flag := range roleFlags {
+		if _, ok := permissions[Role(role)]; !ok || flag == 0 {
+			continue
+		}
+
+		if flags&flag == flag {
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+
+	return roles
+}
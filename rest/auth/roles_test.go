@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRolesFromFlags(t *testing.T) {
+	roleFlags := map[string]int{"moderator": 4, "admin": 8, "support": 16, "unknown": 32}
+
+	for flags, expected := range map[int][]string{
+		0:      {},
+		1:      {},
+		4 | 1:  {"moderator"},
+		8 | 16: {"admin", "support"},
+		32:     {},
+	} {
+		if roles := RolesFromFlags(flags, roleFlags); !reflect.DeepEqual(roles, expected) {
+			t.Errorf("flags %b: got %v, want %v", flags, roles, expected)
+		}
+	}
+}
+
+func TestCan(t *testing.T) {
+	ctx := context.Background()
+
+	loads := 0
+	staff := &Principal{Vanity: "staff", loadRoles: func(context.Context) ([]string, error) {
+		loads++
+		return []string{string(Support)}, nil
+	}}
+
+	if ok, _ := staff.Can(ctx, ScopeExportUsers); !ok {
+		t.Error("support should export data")
+	}
+	if ok, _ := staff.Can(ctx, ScopeSuspendUsers); ok {
+		t.Error("support should not suspend users")
+	}
+	if loads != 1 {
+		t.Errorf("roles loaded %d times, want 1", loads)
+	}
+
+	broken := &Principal{Vanity: "user", loadRoles: func(context.Context) ([]string, error) {
+		return nil, errors.New("database is down")
+	}}
+	if _, err := broken.Can(ctx, ScopeExportUsers); err == nil {
+		t.Error("expected the error of the database")
+	}
+
+	if ok, _ := (&Principal{Vanity: "user"}).Can(ctx, ScopeExportUsers); ok {
+		t.Error("users without roles should not export data")
+	}
+}
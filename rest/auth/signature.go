@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/problem"
+)
+
+// SignatureScheme prefixes the Authorization header of signed requests:
+//
+//	GRAVITALIA-HMAC-SHA256 service=moderation,timestamp=1700000000,nonce=...,signature=...
+//
+// The signature is the base64url HMAC-SHA256, with the key of the
+// service, of the method, the URI, the timestamp, the nonce and the
+// hex SHA-256 of the body, separated by new lines
+const SignatureScheme = "GRAVITALIA-HMAC-SHA256"
+
+// maxSignedBody is the maximum size of the body of signed requests
+const maxSignedBody = 1 << 20
+
+// signature is the parsed Authorization header of a signed request
+type signature struct {
+	service   string
+	timestamp string
+	nonce     string
+	value     string
+}
+
+// Sign signs the request as the service, with its key.
+// The body is read, then replaced to be sent
+func Sign(req *http.Request, service string, key string) error {
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	s := signature{
+		service:   service,
+		timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		nonce:     hex.EncodeToString(nonce),
+	}
+	s.value = s.compute(req, body, key)
+
+	req.Header.Set("Authorization", SignatureScheme+" service="+s.service+",timestamp="+s.timestamp+",nonce="+s.nonce+",signature="+s.value)
+	return nil
+}
+
+// parseSignature reads the parameters of the Authorization header
+func parseSignature(header string) (*signature, error) {
+	params, found := strings.CutPrefix(header, SignatureScheme+" ")
+	if !found {
+		return nil, errors.New("not a signature")
+	}
+
+	s := &signature{}
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "service":
+			s.service = value
+		case "timestamp":
+			s.timestamp = value
+		case "nonce":
+			s.nonce = value
+		case "signature":
+			s.value = value
+		}
+	}
+
+	if s.service == "" || s.timestamp == "" || s.value == "" {
+		return nil, errors.New("service, timestamp and signature are required")
+	}
+	if len(s.nonce) < 16 || len(s.nonce) > 64 || strings.Trim(s.nonce, "0123456789abcdefABCDEF") != "" {
+		return nil, errors.New("nonce must have between 16 and 64 hexadecimal characters")
+	}
+
+	return s, nil
+}
+
+// compute returns the signature of the request
+func (s *signature) compute(req *http.Request, body []byte, key string) string {
+	hash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.Join([]string{
+		req.Method,
+		req.URL.RequestURI(),
+		s.timestamp,
+		s.nonce,
+		hex.EncodeToString(hash[:]),
+	}, "\n")))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature authenticates an internal service from the
+// signature of its request. Every nonce is only accepted once
+func (a *Authenticator) verifySignature(req *http.Request, header string) (*Principal, error) {
+	s, err := parseSignature(header)
+	if err != nil {
+		return nil, problem.Wrap(problem.InvalidSignature, err).WithDetail("%v", err)
+	}
+
+	key, ok := a.services.Keys[s.service]
+	if !ok {
+		return nil, problem.New(problem.InvalidSignature).WithDetail("unknown service %q", s.service)
+	}
+
+	timestamp, err := strconv.ParseInt(s.timestamp, 10, 64)
+	if err != nil {
+		return nil, problem.New(problem.InvalidSignature).WithDetail("invalid timestamp")
+	}
+	if skew := time.Since(time.Unix(timestamp, 0)); skew > a.services.MaxSkew || skew < -a.services.MaxSkew {
+		return nil, problem.New(problem.InvalidSignature).WithDetail("signature expired, check the clock of the service")
+	}
+
+	body, err := readBody(req)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, problem.Wrap(problem.BodyTooLarge, err).WithDetail("body must not exceed %d bytes", tooLarge.Limit)
+		}
+		return nil, problem.Wrap(problem.InvalidBody, err)
+	}
+
+	if !hmac.Equal([]byte(s.compute(req, body, key)), []byte(s.value)) {
+		return nil, problem.New(problem.InvalidSignature)
+	}
+
+	// Only signed requests are remembered, so nonces can't be
+	// filled by anyone. A nonce outlives the validity of its signature
+	fresh, err := a.nonces.Remember(req.Context(), s.service+":"+s.nonce, 2*a.services.MaxSkew)
+	if err != nil {
+		return nil, problem.Wrap(problem.Unavailable, err)
+	}
+	if !fresh {
+		return nil, problem.New(problem.ReplayedRequest)
+	}
+
+	return &Principal{
+		Service: s.service,
+		Scopes:  strings.Fields(a.services.Scopes[s.service]),
+	}, nil
+}
+
+// readBody reads the body of the request, then replaces it
+// so it can be read again
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxSignedBody+1))
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSignedBody {
+		return nil, &http.MaxBytesError{Limit: maxSignedBody}
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/Gravitalia/gravitalia/problem"
+)
+
+const key = "0123456789abcdef0123456789abcdef"
+
+// signed creates a request signed by the moderation service
+func signed(t *testing.T, body string) *http.Request {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/account/suspend?vanity=user", strings.NewReader(body))
+	if err := Sign(req, "moderation", key); err != nil {
+		t.Fatal(err)
+	}
+
+	return req
+}
+
+func TestSignature(t *testing.T) {
+	a := NewAuthenticator(config.Services{
+		Keys:    map[string]string{"moderation": key},
+		Scopes:  map[string]string{"moderation": "users:suspend users:export"},
+		MaxSkew: time.Minute,
+	}, NewMemoryNonces(), nil)
+
+	var got *Principal
+	var body string
+	handler := a.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		got = FromContext(req.Context())
+		b, _ := io.ReadAll(req.Body)
+		body = string(b)
+	}))
+
+	send := func(req *http.Request) (int, problem.Code) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var p problem.Problem
+		json.Unmarshal(rec.Body.Bytes(), &p)
+		return rec.Code, p.Code
+	}
+
+	req := signed(t, `{"reason":"spam"}`)
+	replayed := req.Clone(req.Context())
+	replayed.Body = io.NopCloser(strings.NewReader(`{"reason":"spam"}`))
+
+	if code, _ := send(req); code != http.StatusOK {
+		t.Fatalf("signed request got %v", code)
+	}
+	if got.Service != "moderation" || !got.HasScope(ScopeExportUsers) || got.HasScope(ScopeDeleteUsers) {
+		t.Errorf("invalid principal %+v", got)
+	}
+	if body != `{"reason":"spam"}` {
+		t.Errorf("body not readable by the handler: %q", body)
+	}
+
+	if code, c := send(replayed); code != http.StatusUnauthorized || c != problem.ReplayedRequest {
+		t.Errorf("replayed request got %v %q", code, c)
+	}
+
+	tampered := signed(t, `{"reason":"spam"}`)
+	tampered.Body = io.NopCloser(strings.NewReader(`{"reason":"other"}`))
+	if code, c := send(tampered); code != http.StatusUnauthorized || c != problem.InvalidSignature {
+		t.Errorf("tampered body got %v %q", code, c)
+	}
+
+	old := signed(t, "")
+	old.Header.Set("Authorization", strings.Replace(old.Header.Get("Authorization"), "timestamp=", "timestamp=1", 1))
+	if code, c := send(old); code != http.StatusUnauthorized || c != problem.InvalidSignature {
+		t.Errorf("expired signature got %v %q", code, c)
+	}
+
+	large := signed(t, "")
+	large.Body = io.NopCloser(strings.NewReader(strings.Repeat("a", maxSignedBody+1)))
+	if code, c := send(large); code != http.StatusRequestEntityTooLarge || c != problem.BodyTooLarge {
+		t.Errorf("large body got %v %q", code, c)
+	}
+}
@@ -0,0 +1,168 @@
+// Package certs builds the TLS configurations of the connections to
+// other services. Certificate files are read again once modified,
+// so rotated certificates are used without restarting
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/config"
+)
+
+// checkInterval is the minimum time between two checks of the files
+var checkInterval = 10 * time.Second
+
+// Config returns the TLS configuration of a connection,
+// or nil if TLS is not enabled
+func Config(cfg config.TLS) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	f := &files{cfg: cfg}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+		RootCAs:    f.roots,
+	}
+
+	if cfg.Cert != "" {
+		tlsCfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			_, cert := f.load()
+			return cert, nil
+		}
+	}
+
+	if cfg.CA != "" {
+		// Go only verifies servers with the authorities known when
+		// the configuration is created, they are verified here instead
+		// to follow rotations. Clients forcing InsecureSkipVerify back
+		// to false verify with both
+		tlsCfg.InsecureSkipVerify = true
+		tlsCfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			roots, _ := f.load()
+			return verify(cs, roots, cfg.ServerName)
+		}
+	}
+
+	return tlsCfg, nil
+}
+
+// files holds the certificates read from the configured files
+type files struct {
+	cfg config.TLS
+
+	mu      sync.Mutex
+	checked time.Time
+	// modified holds the modification time of each file
+	modified [3]time.Time
+	roots    *x509.CertPool
+	cert     *tls.Certificate
+}
+
+// load returns the certificates, read again if a file was
+// modified. Invalid files, such as while they are being
+// replaced, are ignored until they are valid
+func (f *files) load() (*x509.CertPool, *tls.Certificate) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if time.Since(f.checked) > checkInterval {
+		if err := f.reloadLocked(); err != nil {
+			log.Printf("(certs) Keep previous certificates: %v", err)
+		}
+	}
+
+	return f.roots, f.cert
+}
+
+// reload reads every file
+func (f *files) reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.reloadLocked()
+}
+
+// reloadLocked reads the files if one was modified since the last
+// read, and keeps the previous certificates if they are invalid
+func (f *files) reloadLocked() error {
+	f.checked = time.Now()
+
+	var modified [3]time.Time
+	for i, path := range []string{f.cfg.CA, f.cfg.Cert, f.cfg.Key} {
+		if path == "" {
+			continue
+		}
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		modified[i] = stat.ModTime()
+	}
+	loaded := f.roots != nil || f.cert != nil
+	if loaded && modified == f.modified {
+		return nil
+	}
+
+	var roots *x509.CertPool
+	if f.cfg.CA != "" {
+		content, err := os.ReadFile(f.cfg.CA)
+		if err != nil {
+			return err
+		}
+
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(content) {
+			return fmt.Errorf("no certificate found in %v", f.cfg.CA)
+		}
+	}
+
+	var cert *tls.Certificate
+	if f.cfg.Cert != "" {
+		pair, err := tls.LoadX509KeyPair(f.cfg.Cert, f.cfg.Key)
+		if err != nil {
+			return err
+		}
+		cert = &pair
+	}
+
+	f.roots, f.cert, f.modified = roots, cert, modified
+	return nil
+}
+
+// verify checks that the certificate of the server is issued by
+// one of the roots for its name, or for serverName if it is set
+func verify(cs tls.ConnectionState, roots *x509.CertPool, serverName string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("certs: server sent no certificate")
+	}
+
+	if serverName == "" {
+		serverName = cs.ServerName
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
@@ -0,0 +1,193 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/config"
+)
+
+// authority issues certificates
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newAuthority(t *testing.T) *authority {
+	t.Helper()
+
+	a := &authority{}
+	a.cert, a.key, a.pem = a.issue(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Gravitalia CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	})
+
+	return a
+}
+
+// issue signs the template, self-signed if a is empty
+func (a *authority) issue(t *testing.T, template *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parent, signer := template, key
+	if a.cert != nil {
+		parent, signer = a.cert, a.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// pair returns a TLS certificate of name, and its PEM files
+func (a *authority) pair(t *testing.T, name string, usage x509.ExtKeyUsage) (tls.Certificate, []byte, []byte) {
+	t.Helper()
+
+	_, key, certPEM := a.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		DNSNames:    []string{name},
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	})
+	der, _ := x509.MarshalECPrivateKey(key)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pair, certPEM, keyPEM
+}
+
+// write replaces the file, modified later than the previous one
+func write(t *testing.T, path string, content []byte) {
+	t.Helper()
+
+	modified := time.Now()
+	if stat, err := os.Stat(path); err == nil {
+		modified = stat.ModTime().Add(time.Second)
+	}
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, modified, modified)
+}
+
+func TestConfig(t *testing.T) {
+	checkInterval = 0
+	dir := t.TempDir()
+	ca, cert, key := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	// The server requires clients of the authority
+	serverCA, clientCA := newAuthority(t), newAuthority(t)
+	serverCert, _, _ := serverCA.pair(t, "spinoza.internal", x509.ExtKeyUsageServerAuth)
+	clients := x509.NewCertPool()
+	clients.AddCert(clientCA.cert)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &serverCert, nil },
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		ClientCAs:      clients,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	names := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if err := conn.(*tls.Conn).Handshake(); err == nil {
+				names <- conn.(*tls.Conn).ConnectionState().PeerCertificates[0].Subject.CommonName
+			}
+			conn.Close()
+		}
+	}()
+
+	// dial returns the name of the client seen by the server
+	dial := func(cfg config.TLS) (string, error) {
+		tlsCfg, err := Config(cfg)
+		if err != nil {
+			return "", err
+		}
+
+		conn, err := tls.Dial("tcp", listener.Addr().String(), tlsCfg)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+
+		return <-names, nil
+	}
+
+	write(t, ca, serverCA.pem)
+	_, certPEM, keyPEM := clientCA.pair(t, "gravitalia", x509.ExtKeyUsageClientAuth)
+	write(t, cert, certPEM)
+	write(t, key, keyPEM)
+	cfg := config.TLS{Enabled: true, CA: ca, Cert: cert, Key: key, ServerName: "spinoza.internal"}
+
+	if _, err := dial(config.TLS{Enabled: true, CA: filepath.Join(dir, "missing.pem")}); err == nil {
+		t.Fatal("missing authority is accepted")
+	}
+	if _, err := dial(config.TLS{Enabled: true, CA: ca, Cert: cert, Key: key, ServerName: "torresix.internal"}); err == nil {
+		t.Fatal("certificate of another server is accepted")
+	}
+	if name, err := dial(cfg); err != nil || name != "gravitalia" {
+		t.Fatalf("got client %q and %v", name, err)
+	}
+
+	tlsCfg, _ := Config(cfg)
+	connect := func() (string, error) {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), tlsCfg)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		return <-names, nil
+	}
+
+	// Rotated client certificates are sent by the same configuration
+	_, certPEM, keyPEM = clientCA.pair(t, "gravitalia-rotated", x509.ExtKeyUsageClientAuth)
+	write(t, cert, certPEM)
+	write(t, key, keyPEM)
+	if name, err := connect(); err != nil || name != "gravitalia-rotated" {
+		t.Fatalf("got client %q and %v after rotation", name, err)
+	}
+
+	// and servers are verified with rotated authorities
+	rotatedCA := newAuthority(t)
+	serverCert, _, _ = rotatedCA.pair(t, "spinoza.internal", x509.ExtKeyUsageServerAuth)
+	if _, err := connect(); err == nil {
+		t.Fatal("server of an unknown authority is accepted")
+	}
+	write(t, ca, rotatedCA.pem)
+	if _, err := connect(); err != nil {
+		t.Fatalf("server of the rotated authority got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package config
+
+import (
+	"time"
+)
+
+// Config holds every setting of the service. It is loaded once at
+// startup, then given to the packages needing it
+type Config struct {
+	// Port on which the web server listens
+	Port string `yaml:"port" toml:"port" env:"PORT" default:"8888"`
+	// RouteTimeouts is the maximum duration of requests per route
+	RouteTimeouts map[string]time.Duration `yaml:"route_timeouts" toml:"route_timeouts" env:"ROUTE_TIMEOUTS"`
+	// BodyLimits is the maximum size of request bodies per route name
+	// or group of routes, such as "default=64KiB,posts.create=40MiB"
+	BodyLimits map[string]Size `yaml:"body_limits" toml:"body_limits" env:"BODY_LIMITS"`
+	// ShutdownTimeout is the grace period given to running requests
+	// and dependencies once SIGTERM or SIGINT is received
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" default:"30s"`
+
+	Graph     Graph     `yaml:"graph" toml:"graph"`
+	Memcached Memcached `yaml:"memcached" toml:"memcached"`
+	Nats      Nats      `yaml:"nats" toml:"nats"`
+	Grpc      Grpc      `yaml:"grpc" toml:"grpc"`
+	OAuth     OAuth     `yaml:"oauth" toml:"oauth"`
+	Health    Health    `yaml:"health" toml:"health"`
+	JWT       JWT       `yaml:"jwt" toml:"jwt"`
+	Services  Services  `yaml:"services" toml:"services"`
+	RateLimit RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	Shedding  Shedding  `yaml:"shedding" toml:"shedding"`
+	CORS      CORS      `yaml:"cors" toml:"cors"`
+	Uploads   Uploads   `yaml:"uploads" toml:"uploads"`
+	Jobs      Jobs      `yaml:"jobs" toml:"jobs"`
+
+	// Idempotency replays responses to retried requests
+	Idempotency Idempotency `yaml:"idempotency" toml:"idempotency"`
+
+	// Moderation decides what happens to posts while Torresix is down
+	Moderation Moderation `yaml:"moderation" toml:"moderation"`
+
+	// SearchAPI is the URL of the search service
+	SearchAPI string `yaml:"search_api" toml:"search_api" env:"SEARCH_API"`
+	// RoleFlags is the flag of each staff role (moderator, support,
+	// admin) in the account flags of the identity provider
+	RoleFlags map[string]int `yaml:"role_flags" toml:"role_flags" env:"ROLE_FLAGS"`
+	// SearchService is the name of this service for the search
+	// service, which knows SearchKey, the key signing its requests
+	SearchService string `yaml:"search_service" toml:"search_service" env:"SEARCH_SERVICE" default:"gravitalia"`
+	SearchKey     string `yaml:"search_key" toml:"search_key" env:"SEARCH_KEY"`
+}
+
+// Graph configures the Memgraph connection
+type Graph struct {
+	// URL of the database, or "memory://" for an in-memory graph
+	URL      string `yaml:"url" toml:"url" env:"GRAPH_URL" required:"true"`
+	Username string `yaml:"username" toml:"username" env:"GRAPH_USERNAME"`
+	Password string `yaml:"password" toml:"password" env:"GRAPH_PASSWORD"`
+	// PoolSize is the maximum number of connections of the driver
+	PoolSize int `yaml:"pool_size" toml:"pool_size" env:"GRAPH_POOL_SIZE" default:"100"`
+	// TLS of bolt+s:// and neo4j+s:// URLs, such as GRAPH_TLS_CA
+	TLS TLS `yaml:"tls" toml:"tls" env_prefix:"GRAPH_"`
+}
+
+// Memcached configures the cache
+type Memcached struct {
+	URL string `yaml:"url" toml:"url" env:"MEM_URL" default:"127.0.0.1:11211"`
+}
+
+// Nats configures the notification broker
+type Nats struct {
+	URL string `yaml:"url" toml:"url" env:"NATS_URL" default:"localhost:4222"`
+	// TLS of the connection, such as NATS_TLS_CA
+	TLS TLS `yaml:"tls" toml:"tls" env_prefix:"NATS_"`
+}
+
+// Grpc configures the connections to the internal services
+type Grpc struct {
+	// SpinozaAddress is the image uploader
+	SpinozaAddress string `yaml:"spinoza_address" toml:"spinoza_address" env:"SPINOZA_ADDRESS" required:"true"`
+	// TorresixAddress is the image classifier
+	TorresixAddress string `yaml:"torresix_address" toml:"torresix_address" env:"TORRESIX_ADDRESS" required:"true"`
+	// TLS of each service, such as SPINOZA_TLS_CA
+	SpinozaTLS  TLS `yaml:"spinoza_tls" toml:"spinoza_tls" env_prefix:"SPINOZA_"`
+	TorresixTLS TLS `yaml:"torresix_tls" toml:"torresix_tls" env_prefix:"TORRESIX_"`
+	// PoolSize is the number of connections kept to each service
+	PoolSize int `yaml:"pool_size" toml:"pool_size" env:"GRPC_POOL_SIZE" default:"2"`
+	// Keepalive is the interval between two pings checking
+	// that connections are alive
+	Keepalive time.Duration `yaml:"keepalive" toml:"keepalive" env:"GRPC_KEEPALIVE" default:"1m"`
+	// Timeouts of each attempt of a call, such as
+	// "spinoza.upload=20s,spinoza.delete=2s,torresix.predict=5s"
+	Timeouts map[string]time.Duration `yaml:"timeouts" toml:"timeouts" env:"GRPC_TIMEOUTS"`
+	// Retries is the maximum number of retries of idempotent
+	// calls, such as deletions or predictions
+	Retries int `yaml:"retries" toml:"retries" env:"GRPC_RETRIES" default:"2"`
+	// BreakerFailures is the number of consecutive failures after
+	// which a service isn't called anymore, 0 to always call it
+	BreakerFailures int `yaml:"breaker_failures" toml:"breaker_failures" env:"GRPC_BREAKER_FAILURES" default:"5"`
+	// BreakerCooldown is the time before calling a failing service again
+	BreakerCooldown time.Duration `yaml:"breaker_cooldown" toml:"breaker_cooldown" env:"GRPC_BREAKER_COOLDOWN" default:"30s"`
+}
+
+// TLS configures the encryption of a connection to another service.
+// Its variables are prefixed by the service, such as GRAPH_TLS_CA
+type TLS struct {
+	// Enabled encrypts the connection. The server is verified with
+	// the authorities of the system, or the ones of CA
+	Enabled bool `yaml:"enabled" toml:"enabled" env:"TLS"`
+	// CA is the path of the PEM bundle of authorities of the server
+	CA string `yaml:"ca" toml:"ca" env:"TLS_CA"`
+	// Cert and Key are the paths of the PEM client certificate
+	// and of its key, sent to servers requiring mutual TLS
+	Cert string `yaml:"cert" toml:"cert" env:"TLS_CERT"`
+	Key  string `yaml:"key" toml:"key" env:"TLS_KEY"`
+	// ServerName is the name expected in the server certificate,
+	// instead of the host of the address
+	ServerName string `yaml:"server_name" toml:"server_name" env:"TLS_SERVER_NAME"`
+}
+
+// OAuth configures the connection with the identity provider
+type OAuth struct {
+	// Host serves the authorization page
+	Host string `yaml:"host" toml:"host" env:"OAUTH_HOST" required:"true"`
+	// API exchanges codes against tokens
+	API         string `yaml:"api" toml:"api" env:"OAUTH_API" required:"true"`
+	Secret      string `yaml:"secret" toml:"secret" env:"SECRET" required:"true"`
+	RedirectURL string `yaml:"redirect_url" toml:"redirect_url" env:"REDIRECT_URL" required:"true"`
+}
+
+// Health configures the readiness probe
+type Health struct {
+	// NonCritical dependencies only degrade the service when
+	// they are down, instead of marking it as not ready
+	NonCritical []string `yaml:"non_critical" toml:"non_critical" env:"HEALTH_NON_CRITICAL" default:"torresix"`
+	// Timeout of every dependency check
+	Timeout time.Duration `yaml:"timeout" toml:"timeout" env:"HEALTH_TIMEOUT" default:"2s"`
+}
+
+// JWT configures the verification of user tokens. Keys are read
+// from PublicKeys, JWKS or both, at least one must be set
+type JWT struct {
+	// PublicKeys holds one or more PEM encoded keys (RSA, ECDSA P-256
+	// or Ed25519), used for tokens whatever their key ID
+	PublicKeys string `yaml:"public_keys" toml:"public_keys" env:"RSA_PUBLIC_KEY"`
+	// JWKS is the URL or the path of the JWK Set of the identity provider
+	JWKS string `yaml:"jwks" toml:"jwks" env:"JWKS_URL"`
+	// JWKSRefresh is the interval between two downloads of the JWK Set
+	JWKSRefresh time.Duration `yaml:"jwks_refresh" toml:"jwks_refresh" env:"JWKS_REFRESH" default:"10m"`
+	// Issuer expected in the "iss" claim, not checked if empty
+	Issuer string `yaml:"issuer" toml:"issuer" env:"JWT_ISSUER"`
+	// Audience expected in the "aud" claim, not checked if empty
+	Audience string `yaml:"audience" toml:"audience" env:"JWT_AUDIENCE"`
+	// Scopes every token must grant
+	Scopes []string `yaml:"scopes" toml:"scopes" env:"JWT_SCOPES"`
+}
+
+// Services configures the internal services allowed to call this
+// service. Each one signs its requests with its own key
+type Services struct {
+	// Keys are the secrets signing requests, per service name,
+	// such as "moderation=secret,account=other-secret"
+	Keys map[string]string `yaml:"keys" toml:"keys" env:"SERVICE_KEYS"`
+	// Scopes granted to each service, separated by spaces,
+	// such as "moderation=users:suspend,account=users:delete users:export"
+	Scopes map[string]string `yaml:"scopes" toml:"scopes" env:"SERVICE_SCOPES"`
+	// MaxSkew is the maximum difference between the time
+	// of a signature and the time of this service
+	MaxSkew time.Duration `yaml:"max_skew" toml:"max_skew" env:"SIGNATURE_MAX_SKEW" default:"5m"`
+}
+
+// RateLimit configures the number of requests allowed to each
+// caller: users, services or, for anonymous requests, IP addresses
+type RateLimit struct {
+	// Limits per route name or group of routes, such as
+	// "default=300/1m,posts.create=10/1h". Callers have
+	// one counter per configured route or group
+	Limits map[string]Rate `yaml:"limits" toml:"limits" env:"RATE_LIMITS"`
+	// TrustProxy identifies anonymous callers by the last address
+	// of X-Forwarded-For, set by the load balancer
+	TrustProxy bool `yaml:"trust_proxy" toml:"trust_proxy" env:"TRUST_PROXY"`
+}
+
+// Shedding configures the adaptive limit of concurrent requests
+// of expensive routes. The limit decreases while requests are
+// slower than the target latency, and grows back otherwise
+type Shedding struct {
+	// MaxConcurrency is the highest limit per route name or group
+	// of routes, such as "posts.create=50,users.get=200"
+	MaxConcurrency map[string]int `yaml:"max_concurrency" toml:"max_concurrency" env:"MAX_CONCURRENCY"`
+	// TargetLatency per route name or group of routes
+	TargetLatency map[string]time.Duration `yaml:"target_latency" toml:"target_latency" env:"TARGET_LATENCY"`
+}
+
+// CORS configures the web origins allowed to call the API
+type CORS struct {
+	// AllowedOrigins such as "https://www.gravitalia.com",
+	// "https://*.gravitalia.com" for subdomains, or "*" for any origin
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS" default:"https://www.gravitalia.com"`
+	AllowedMethods []string `yaml:"allowed_methods" toml:"allowed_methods" env:"CORS_ALLOWED_METHODS" default:"GET,HEAD,POST,PATCH,PUT,DELETE"`
+	AllowedHeaders []string `yaml:"allowed_headers" toml:"allowed_headers" env:"CORS_ALLOWED_HEADERS" default:"Authorization,Content-Type,Prefer,Idempotency-Key,Tus-Resumable,Upload-Length,Upload-Offset,Upload-Metadata"`
+	// ExposedHeaders can be read by scripts of allowed origins
+	ExposedHeaders []string `yaml:"exposed_headers" toml:"exposed_headers" env:"CORS_EXPOSED_HEADERS" default:"RateLimit-Limit,RateLimit-Remaining,RateLimit-Reset,RateLimit-Policy,Retry-After,Location,Preference-Applied,Idempotent-Replayed,Tus-Resumable,Tus-Version,Tus-Extension,Tus-Max-Size,Upload-Offset,Upload-Length,Upload-Expires,Upload-Metadata"`
+	// AllowCredentials lets browsers send cookies and credentials
+	AllowCredentials bool `yaml:"allow_credentials" toml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+	// MaxAge is how long browsers can cache preflight responses
+	MaxAge time.Duration `yaml:"max_age" toml:"max_age" env:"CORS_MAX_AGE" default:"10m"`
+}
+
+// Uploads configures resumable uploads of images, kept until
+// they are used by a post or expire
+type Uploads struct {
+	// Dir keeps running uploads, in the temporary directory if empty.
+	// Replicas must share it, such as with a network volume
+	Dir string `yaml:"dir" toml:"dir" env:"UPLOADS_DIR"`
+	// Expiration of uploads not used by a post, after their last chunk
+	Expiration time.Duration `yaml:"expiration" toml:"expiration" env:"UPLOADS_EXPIRATION" default:"24h"`
+}
+
+// Idempotency configures requests sent with an Idempotency-Key
+type Idempotency struct {
+	// TTL is how long the response is replayed to retries with the same key
+	TTL time.Duration `yaml:"ttl" toml:"ttl" env:"IDEMPOTENCY_TTL" default:"24h"`
+}
+
+// Jobs configures posts published in the background
+type Jobs struct {
+	// Dir keeps jobs until they are done. It must
+	// survive restarts, and be shared by replicas
+	Dir string `yaml:"dir" toml:"dir" env:"JOBS_DIR" required:"true"`
+	// Workers is the number of jobs run at once by each replica
+	Workers int `yaml:"workers" toml:"workers" env:"JOBS_WORKERS" default:"4"`
+	// Retention of finished jobs, whose status can still be polled
+	Retention time.Duration `yaml:"retention" toml:"retention" env:"JOBS_RETENTION" default:"24h"`
+}
+
+// Moderation configures posts whose images can't be moderated
+type Moderation struct {
+	// Unavailable is "reject" to refuse posts while Torresix is
+	// unavailable, or "queue" to publish them once it is back
+	Unavailable string `yaml:"unavailable" toml:"unavailable" env:"MODERATION_UNAVAILABLE" default:"reject"`
+	// QueueFor is how long queued posts wait for Torresix
+	// and Spinoza, they are dropped after it
+	QueueFor time.Duration `yaml:"queue_for" toml:"queue_for" env:"MODERATION_QUEUE_FOR" default:"1h"`
+}
@@ -0,0 +1,471 @@
+package config
+
+import (
+	"crypto/x509"
+	"encoding"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Error lists every invalid setting found while loading
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Load reads the configuration from, by increasing priority,
+// default values, the YAML or TOML file set by CONFIG_FILE,
+// the .env file and the environment. Every variable can also
+// be read from a file with the _FILE suffix, such as Docker secrets
+func Load() (*Config, error) {
+	// Get key-value in .env file, without overriding the environment
+	godotenv.Load()
+
+	cfg := &Config{}
+	problems := make([]string, 0)
+
+	walk(reflect.ValueOf(cfg).Elem(), "", "", func(field reflect.Value, tag reflect.StructTag, path string, _ string) {
+		if value, ok := tag.Lookup("default"); ok {
+			if err := set(field, value); err != nil {
+				problems = append(problems, fmt.Sprintf("%v: invalid default value: %v", path, err))
+			}
+		}
+	})
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := readFile(path, cfg); err != nil {
+			problems = append(problems, fmt.Sprintf("CONFIG_FILE: %v", err))
+		}
+	}
+
+	// TORRESIX_ADDRESSS was the name used by older deployments
+	if os.Getenv("TORRESIX_ADDRESS") == "" && os.Getenv("TORRESIX_ADDRESSS") != "" {
+		log.Println("TORRESIX_ADDRESSS is deprecated, use TORRESIX_ADDRESS instead")
+		os.Setenv("TORRESIX_ADDRESS", os.Getenv("TORRESIX_ADDRESSS"))
+	}
+
+	walk(reflect.ValueOf(cfg).Elem(), "", "", func(field reflect.Value, _ reflect.StructTag, _ string, name string) {
+		if name == "" {
+			return
+		}
+
+		value, found, err := lookupEnv(name)
+		if err != nil {
+			problems = append(problems, err.Error())
+			return
+		}
+
+		// Empty variables, such as in .env.example, keep the previous value
+		if found && value != "" {
+			if err := set(field, value); err != nil {
+				problems = append(problems, fmt.Sprintf("%v: %v", name, err))
+			}
+		}
+	})
+
+	walk(reflect.ValueOf(cfg).Elem(), "", "", func(field reflect.Value, tag reflect.StructTag, path string, name string) {
+		if tag.Get("required") == "true" && field.IsZero() {
+			problems = append(problems, fmt.Sprintf("%v is required (%v)", name, path))
+		}
+	})
+
+	problems = append(problems, cfg.validate()...)
+	if len(problems) != 0 {
+		return nil, &Error{Problems: problems}
+	}
+
+	return cfg, nil
+}
+
+// validate checks the values which are set
+func (cfg *Config) validate() []string {
+	problems := make([]string, 0)
+
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+		problems = append(problems, fmt.Sprintf("PORT: %q is not a valid port", cfg.Port))
+	}
+
+	if cfg.Graph.URL != "" {
+		if u, err := url.Parse(cfg.Graph.URL); err != nil {
+			problems = append(problems, fmt.Sprintf("GRAPH_URL: %v", err))
+		} else {
+			switch u.Scheme {
+			case "bolt", "bolt+s", "bolt+ssc", "neo4j", "neo4j+s", "neo4j+ssc", "memory":
+			default:
+				problems = append(problems, fmt.Sprintf("GRAPH_URL: unsupported scheme %q", u.Scheme))
+			}
+		}
+	}
+
+	problems = append(problems, cfg.JWT.validate()...)
+	problems = append(problems, cfg.Services.validate()...)
+	if cfg.SearchAPI != "" && len(cfg.SearchKey) < minServiceKeyLength {
+		problems = append(problems, fmt.Sprintf("SEARCH_KEY: must have at least %d characters to sign requests to SEARCH_API", minServiceKeyLength))
+	}
+	problems = append(problems, cfg.CORS.validate()...)
+	problems = append(problems, cfg.Graph.TLS.validate("GRAPH_")...)
+	problems = append(problems, cfg.Nats.TLS.validate("NATS_")...)
+	problems = append(problems, cfg.Grpc.SpinozaTLS.validate("SPINOZA_")...)
+	problems = append(problems, cfg.Grpc.TorresixTLS.validate("TORRESIX_")...)
+
+	if cfg.Graph.TLS.Enabled {
+		if u, err := url.Parse(cfg.Graph.URL); err == nil && !strings.HasSuffix(u.Scheme, "+s") && !strings.HasSuffix(u.Scheme, "+ssc") {
+			problems = append(problems, "GRAPH_TLS: GRAPH_URL must use bolt+s:// or neo4j+s://")
+		}
+		// The driver always expects the host of the URL
+		if cfg.Graph.TLS.ServerName != "" {
+			problems = append(problems, "GRAPH_TLS_SERVER_NAME: not supported, use the name of the certificate in GRAPH_URL")
+		}
+	}
+
+	if cfg.ShutdownTimeout <= 0 {
+		problems = append(problems, "SHUTDOWN_TIMEOUT: must be positive")
+	}
+
+	for role, flag := range cfg.RoleFlags {
+		if flag <= 0 {
+			problems = append(problems, fmt.Sprintf("ROLE_FLAGS: flag of %v must be positive", role))
+		}
+	}
+
+	for route, limit := range cfg.Shedding.MaxConcurrency {
+		if limit < 0 {
+			problems = append(problems, fmt.Sprintf("MAX_CONCURRENCY: negative limit for %v", route))
+		}
+	}
+
+	for route, latency := range cfg.Shedding.TargetLatency {
+		if latency <= 0 {
+			problems = append(problems, fmt.Sprintf("TARGET_LATENCY: latency of %v must be positive", route))
+		}
+	}
+
+	if cfg.Grpc.PoolSize <= 0 {
+		problems = append(problems, "GRPC_POOL_SIZE: must be positive")
+	}
+	// gRPC never pings more often than every 10 seconds
+	if cfg.Grpc.Keepalive < 10*time.Second {
+		problems = append(problems, "GRPC_KEEPALIVE: must be at least 10s")
+	}
+
+	if cfg.Grpc.Retries < 0 {
+		problems = append(problems, "GRPC_RETRIES: must not be negative")
+	}
+	if cfg.Grpc.BreakerFailures < 0 {
+		problems = append(problems, "GRPC_BREAKER_FAILURES: must not be negative")
+	}
+	for name, timeout := range cfg.Grpc.Timeouts {
+		if timeout <= 0 {
+			problems = append(problems, fmt.Sprintf("GRPC_TIMEOUTS: timeout of %v must be positive", name))
+		}
+	}
+
+	switch cfg.Moderation.Unavailable {
+	case "reject", "queue":
+	default:
+		problems = append(problems, fmt.Sprintf("MODERATION_UNAVAILABLE: %q is neither reject nor queue", cfg.Moderation.Unavailable))
+	}
+	if cfg.Moderation.QueueFor <= 0 {
+		problems = append(problems, "MODERATION_QUEUE_FOR: must be positive")
+	}
+
+	if cfg.Uploads.Expiration <= 0 {
+		problems = append(problems, "UPLOADS_EXPIRATION: must be positive")
+	}
+	// Memcached reads longer expirations as timestamps
+	if cfg.Idempotency.TTL <= 0 || cfg.Idempotency.TTL > 30*24*time.Hour {
+		problems = append(problems, "IDEMPOTENCY_TTL: must be positive, and at most 30 days")
+	}
+
+	if cfg.Jobs.Workers <= 0 {
+		problems = append(problems, "JOBS_WORKERS: must be positive")
+	}
+	if cfg.Jobs.Retention <= 0 {
+		problems = append(problems, "JOBS_RETENTION: must be positive")
+	}
+
+	for route, limit := range cfg.BodyLimits {
+		if limit < 0 {
+			problems = append(problems, fmt.Sprintf("BODY_LIMITS: negative limit for %v", route))
+		}
+	}
+
+	for route, timeout := range cfg.RouteTimeouts {
+		if timeout < 0 {
+			problems = append(problems, fmt.Sprintf("ROUTE_TIMEOUTS: negative timeout for %v", route))
+		}
+	}
+
+	return problems
+}
+
+// validate checks that tokens can be verified
+func (jwt *JWT) validate() []string {
+	problems := make([]string, 0)
+
+	if jwt.PublicKeys == "" && jwt.JWKS == "" {
+		problems = append(problems, "RSA_PUBLIC_KEY or JWKS_URL is required (jwt)")
+	}
+
+	rest := []byte(jwt.PublicKeys)
+	for len(strings.TrimSpace(string(rest))) != 0 {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			problems = append(problems, "RSA_PUBLIC_KEY: not a PEM encoded key")
+			break
+		}
+
+		if _, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			problems = append(problems, fmt.Sprintf("RSA_PUBLIC_KEY: %v", err))
+		}
+	}
+
+	if u, err := url.Parse(jwt.JWKS); err == nil && u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file" {
+		problems = append(problems, fmt.Sprintf("JWKS_URL: unsupported scheme %q", u.Scheme))
+	}
+
+	if jwt.JWKS != "" && jwt.JWKSRefresh <= 0 {
+		problems = append(problems, "JWKS_REFRESH: must be positive")
+	}
+
+	return problems
+}
+
+// validate checks that allowed origins are complete
+func (cors *CORS) validate() []string {
+	problems := make([]string, 0)
+
+	for _, origin := range cors.AllowedOrigins {
+		if origin == "*" {
+			if cors.AllowCredentials {
+				problems = append(problems, "CORS_ALLOWED_ORIGINS: \"*\" can't be used with CORS_ALLOW_CREDENTIALS")
+			}
+			continue
+		}
+
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			problems = append(problems, fmt.Sprintf("CORS_ALLOWED_ORIGINS: %q is not an origin such as https://www.gravitalia.com", origin))
+		}
+	}
+
+	if cors.MaxAge < 0 {
+		problems = append(problems, "CORS_MAX_AGE: must not be negative")
+	}
+
+	return problems
+}
+
+// validate checks that certificates are set by pairs and readable.
+// Files are read again once TLS is used, whenever they are rotated
+func (t *TLS) validate(prefix string) []string {
+	problems := make([]string, 0)
+
+	if !t.Enabled {
+		if t.CA != "" || t.Cert != "" || t.Key != "" || t.ServerName != "" {
+			problems = append(problems, fmt.Sprintf("%vTLS: must be true to use the other %vTLS_ variables", prefix, prefix))
+		}
+		return problems
+	}
+
+	if (t.Cert == "") != (t.Key == "") {
+		problems = append(problems, fmt.Sprintf("%vTLS_CERT and %vTLS_KEY must be set together", prefix, prefix))
+	}
+
+	for _, file := range []struct{ name, path string }{{"TLS_CA", t.CA}, {"TLS_CERT", t.Cert}, {"TLS_KEY", t.Key}} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			problems = append(problems, fmt.Sprintf("%v%v: %v", prefix, file.name, err))
+		}
+	}
+
+	return problems
+}
+
+// minServiceKeyLength is the minimum length of service keys
+const minServiceKeyLength = 32
+
+// validate checks that every service has a long enough key
+func (services *Services) validate() []string {
+	problems := make([]string, 0)
+
+	for name, key := range services.Keys {
+		if len(key) < minServiceKeyLength {
+			problems = append(problems, fmt.Sprintf("SERVICE_KEYS: key of %v must have at least %d characters", name, minServiceKeyLength))
+		}
+	}
+
+	for name := range services.Scopes {
+		if _, ok := services.Keys[name]; !ok {
+			problems = append(problems, fmt.Sprintf("SERVICE_SCOPES: %v has no key in SERVICE_KEYS", name))
+		}
+	}
+
+	if services.MaxSkew <= 0 {
+		problems = append(problems, "SIGNATURE_MAX_SKEW: must be positive")
+	}
+
+	return problems
+}
+
+// readFile decodes a YAML or TOML file into the configuration
+func readFile(path string, cfg *Config) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(content, cfg)
+	case ".toml":
+		return toml.Unmarshal(content, cfg)
+	}
+
+	return fmt.Errorf("unsupported file extension %q, expected .yaml, .yml or .toml", filepath.Ext(path))
+}
+
+// lookupEnv returns the value of the variable, or the
+// content of the file set by its _FILE variant
+func lookupEnv(name string) (string, bool, error) {
+	value, found := os.LookupEnv(name)
+	file, fileFound := os.LookupEnv(name + "_FILE")
+
+	if found && fileFound {
+		return "", false, fmt.Errorf("%v and %v_FILE are both set", name, name)
+	}
+
+	if fileFound {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", false, fmt.Errorf("%v_FILE: %v", name, err)
+		}
+
+		return strings.TrimSpace(string(content)), true, nil
+	}
+
+	return value, found, nil
+}
+
+// walk calls fn on every configurable field, nested structures included,
+// with the name of its variable. Structures shared by several settings,
+// such as TLS, set the prefix of their variables with the env_prefix tag
+func walk(value reflect.Value, prefix string, envPrefix string, fn func(field reflect.Value, tag reflect.StructTag, path string, env string)) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		info := value.Type().Field(i)
+
+		path := info.Tag.Get("yaml")
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+
+		if field.Kind() == reflect.Struct && info.Tag.Get("env") == "" {
+			walk(field, path, envPrefix+info.Tag.Get("env_prefix"), fn)
+			continue
+		}
+
+		var env string
+		if name := info.Tag.Get("env"); name != "" {
+			env = envPrefix + name
+		}
+		fn(field, info.Tag, path, env)
+	}
+}
+
+// set parses the value according to the type of the field
+func set(field reflect.Value, value string) error {
+	if field.CanAddr() {
+		if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(value))
+		}
+	}
+
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(i)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		list := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, item := range strings.Split(value, ",") {
+			if strings.TrimSpace(item) == "" {
+				continue
+			}
+
+			element := reflect.New(field.Type().Elem()).Elem()
+			if err := set(element, strings.TrimSpace(item)); err != nil {
+				return err
+			}
+			list = reflect.Append(list, element)
+		}
+		field.Set(list)
+	case reflect.Map:
+		// Maps are written as "key=value,other=value"
+		entries := reflect.MakeMap(field.Type())
+		for _, entry := range strings.Split(value, ",") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+
+			key, item, found := strings.Cut(entry, "=")
+			if !found {
+				return fmt.Errorf("invalid entry %q, expected key=value", entry)
+			}
+
+			element := reflect.New(field.Type().Elem()).Elem()
+			if err := set(element, strings.TrimSpace(item)); err != nil {
+				return fmt.Errorf("%v: %v", strings.TrimSpace(key), err)
+			}
+			entries.SetMapIndex(reflect.ValueOf(strings.TrimSpace(key)), element)
+		}
+		field.Set(entries)
+	default:
+		return fmt.Errorf("unsupported type %v", field.Type())
+	}
+
+	return nil
+}
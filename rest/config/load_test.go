@@ -0,0 +1,128 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// required sets every required variable
+func required(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	public, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	for name, value := range map[string]string{
+		"GRAPH_URL":        "memory://",
+		"SPINOZA_ADDRESS":  "localhost:28717",
+		"TORRESIX_ADDRESS": "localhost:50051",
+		"OAUTH_HOST":       "https://account.gravitalia.com",
+		"OAUTH_API":        "https://id.gravitalia.com",
+		"SECRET":           "secret",
+		"REDIRECT_URL":     "https://www.gravitalia.com/callback",
+		"JOBS_DIR":         "/var/lib/gravitalia/jobs",
+		"SEARCH_API":       "http://localhost:8890",
+		"SEARCH_KEY":       "0123456789abcdef0123456789abcdef",
+		"RSA_PUBLIC_KEY":   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public})),
+	} {
+		t.Setenv(name, value)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	required(t)
+	t.Setenv("ROUTE_TIMEOUTS", "default=5s,posts=2m")
+	t.Setenv("RATE_LIMITS", "default=300/1m,posts.create=10/1h")
+	t.Setenv("BODY_LIMITS", "default=64KiB,posts.create=40MiB")
+	t.Setenv("SPINOZA_TLS", "true")
+	t.Setenv("SPINOZA_TLS_SERVER_NAME", "spinoza.internal")
+
+	secret := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(secret, []byte("fedcba9876543210fedcba9876543210\n"), 0600)
+	os.Unsetenv("SEARCH_KEY")
+	t.Setenv("SEARCH_KEY_FILE", secret)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Port != "8888" || cfg.Graph.PoolSize != 100 {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+	if cfg.SearchKey != "fedcba9876543210fedcba9876543210" || cfg.SearchService != "gravitalia" {
+		t.Errorf("SEARCH_KEY_FILE not read: got %q", cfg.SearchKey)
+	}
+	if cfg.RouteTimeouts["posts"] != 2*time.Minute || cfg.RouteTimeouts["default"] != 5*time.Second {
+		t.Errorf("invalid route timeouts: %v", cfg.RouteTimeouts)
+	}
+	if rate := cfg.RateLimit.Limits["posts.create"]; rate.Requests != 10 || rate.Window != time.Hour {
+		t.Errorf("invalid rate limits: %v", cfg.RateLimit.Limits)
+	}
+	if cfg.BodyLimits["default"] != 64<<10 || cfg.BodyLimits["posts.create"] != 40<<20 {
+		t.Errorf("invalid body limits: %v", cfg.BodyLimits)
+	}
+	// Variables of shared structures are prefixed
+	if tls := cfg.Grpc.SpinozaTLS; !tls.Enabled || tls.ServerName != "spinoza.internal" || cfg.Grpc.TorresixTLS.Enabled {
+		t.Errorf("invalid TLS of gRPC services: %+v", cfg.Grpc)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	required(t)
+	t.Setenv("GRAPH_POOL_SIZE", "20")
+
+	file := filepath.Join(t.TempDir(), "gravitalia.yaml")
+	os.WriteFile(file, []byte("port: \"9000\"\ngraph:\n  pool_size: 50\n  username: memgraph\n"), 0600)
+	t.Setenv("CONFIG_FILE", file)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Port != "9000" || cfg.Graph.Username != "memgraph" {
+		t.Errorf("file not read: %+v", cfg)
+	}
+	// Environment has priority over the file
+	if cfg.Graph.PoolSize != 20 {
+		t.Errorf("expected pool size 20, got %v", cfg.Graph.PoolSize)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	required(t)
+	os.Unsetenv("SPINOZA_ADDRESS")
+	t.Setenv("PORT", "http")
+	t.Setenv("SEARCH_KEY_FILE", "/nonexistent")
+	t.Setenv("JWKS_URL", "ftp://id.gravitalia.com/jwks.json")
+	t.Setenv("SERVICE_KEYS", "moderation=short")
+	t.Setenv("SERVICE_SCOPES", "account=users:delete")
+	t.Setenv("RATE_LIMITS", "default=300")
+	t.Setenv("BODY_LIMITS", "default=64KB")
+	t.Setenv("GRPC_KEEPALIVE", "1s")
+	t.Setenv("MODERATION_UNAVAILABLE", "publish")
+	t.Setenv("NATS_TLS_CA", "/etc/ssl/nats.pem")
+	t.Setenv("TORRESIX_TLS", "true")
+	t.Setenv("TORRESIX_TLS_CERT", "/nonexistent/client.pem")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://*.gravitalia.com,www.gravitalia.com")
+
+	_, err := Load()
+
+	var cfgErr *Error
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("expected a configuration error, got %v", err)
+	}
+
+	for _, expected := range []string{"SEARCH_KEY and SEARCH_KEY_FILE", "SEARCH_KEY: must have", "SPINOZA_ADDRESS is required", "PORT", "JWKS_URL", "key of moderation", "account has no key", "RATE_LIMITS", "BODY_LIMITS", "GRPC_KEEPALIVE", "MODERATION_UNAVAILABLE", "NATS_TLS: must be true", "TORRESIX_TLS_CERT and TORRESIX_TLS_KEY", "TORRESIX_TLS_CERT: stat", `"www.gravitalia.com" is not an origin`} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q is not reported in:\n%v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate is a number of requests allowed per window,
+// written "10/1m". A rate without requests is unlimited
+type Rate struct {
+	Requests uint64
+	Window   time.Duration
+}
+
+// UnmarshalText reads a rate such as "10/1m"
+func (r *Rate) UnmarshalText(text []byte) error {
+	requests, window, found := strings.Cut(string(text), "/")
+	if !found {
+		return fmt.Errorf("invalid rate %q, expected requests/window such as 10/1m", text)
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSpace(requests), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number of requests %q", requests)
+	}
+
+	d, err := time.ParseDuration(strings.TrimSpace(window))
+	if err != nil {
+		return err
+	}
+	if d < time.Second {
+		return fmt.Errorf("window %v is shorter than a second", d)
+	}
+
+	r.Requests = n
+	r.Window = d
+	return nil
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%v", r.Requests, r.Window)
+}
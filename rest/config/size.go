@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Size is a number of bytes, written "512", "64KiB" or "40MiB".
+// A size of zero is unlimited
+type Size int64
+
+// units of sizes, by decreasing length of their suffix
+var units = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"B", 1},
+}
+
+// UnmarshalText reads a size such as "64KiB"
+func (s *Size) UnmarshalText(text []byte) error {
+	value := strings.TrimSpace(string(text))
+	multiplier := int64(1)
+	for _, unit := range units {
+		if number, found := strings.CutSuffix(value, unit.suffix); found {
+			value = strings.TrimSpace(number)
+			multiplier = unit.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/multiplier {
+		return fmt.Errorf("invalid size %q, expected bytes such as 512, 64KiB or 40MiB", text)
+	}
+
+	*s = Size(n * multiplier)
+	return nil
+}
+
+func (s Size) String() string {
+	for _, unit := range units {
+		if unit.bytes > 1 && s != 0 && int64(s)%unit.bytes == 0 && int64(s)/unit.bytes < 1<<10 {
+			return fmt.Sprintf("%d%v", int64(s)/unit.bytes, unit.suffix)
+		}
+	}
+
+	return strconv.FormatInt(int64(s), 10)
+}
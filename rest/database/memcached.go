@@ -1,6 +1,12 @@
 package database
 
 import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/bradfitz/gomemcache/memcache"
 )
 
@@ -15,3 +21,182 @@ func Set(key string, value string, time int32) {
 		Expiration: time,
 	})
 }
+
+// RememberNonce saves the nonce for ttl, and returns
+// false if it was already saved, by any instance
+func RememberNonce(_ context.Context, nonce string, ttl time.Duration) (bool, error) {
+	if Mem == nil {
+		return false, errors.New("memcached is not initialized")
+	}
+
+	err := Mem.Add(&memcache.Item{
+		Key:        "nonce-" + nonce,
+		Value:      []byte{1},
+		Expiration: int32(ttl.Seconds()),
+	})
+	if errors.Is(err, memcache.ErrNotStored) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Counters count requests in Memcached, shared by every instance
+type Counters struct{}
+
+// Increment adds a request to the counter of key, created
+// for ttl if needed, and returns the number of requests
+func (Counters) Increment(_ context.Context, key string, ttl time.Duration) (uint64, error) {
+	if Mem == nil {
+		return 0, errors.New("memcached is not initialized")
+	}
+
+	count, err := Mem.Increment(key, 1)
+	if !errors.Is(err, memcache.ErrCacheMiss) {
+		return count, err
+	}
+
+	err = Mem.Add(&memcache.Item{
+		Key:        key,
+		Value:      []byte("1"),
+		Expiration: int32(ttl.Seconds()) + 1,
+	})
+	// Another request, maybe of another instance, created it first
+	if errors.Is(err, memcache.ErrNotStored) {
+		return Mem.Increment(key, 1)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return 1, nil
+}
+
+// Locker holds locks in Memcached, shared by every instance
+type Locker struct{}
+
+// Lock takes the lock of key for ttl at most,
+// and returns false if it is already taken
+func (Locker) Lock(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	if Mem == nil {
+		return false, errors.New("memcached is not initialized")
+	}
+
+	err := Mem.Add(&memcache.Item{
+		Key:        "lock-" + key,
+		Value:      []byte{1},
+		Expiration: int32(ttl.Seconds()),
+	})
+	if errors.Is(err, memcache.ErrNotStored) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Unlock releases the lock of key
+func (Locker) Unlock(_ context.Context, key string) error {
+	if Mem == nil {
+		return errors.New("memcached is not initialized")
+	}
+
+	err := Mem.Delete("lock-" + key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil
+	}
+
+	return err
+}
+
+// Count returns the number of requests of key, 0 if it doesn't exist
+func (Counters) Count(_ context.Context, key string) (uint64, error) {
+	if Mem == nil {
+		return 0, errors.New("memcached is not initialized")
+	}
+
+	item, err := Mem.Get(key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Incremented values are decimal, and may be padded with spaces
+	return strconv.ParseUint(strings.TrimSpace(string(item.Value)), 10, 64)
+}
+
+// Responses keeps the responses of idempotent requests
+// in Memcached, shared by every instance
+type Responses struct{}
+
+// Add saves value for ttl, and returns false if key already exists
+func (Responses) Add(_ context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	if Mem == nil {
+		return false, errors.New("memcached is not initialized")
+	}
+
+	err := Mem.Add(&memcache.Item{
+		Key:        "idem-" + key,
+		Value:      value,
+		Expiration: int32(ttl.Seconds()),
+	})
+	if errors.Is(err, memcache.ErrNotStored) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Get returns the value of key, or nil if it doesn't exist
+func (Responses) Get(_ context.Context, key string) ([]byte, error) {
+	if Mem == nil {
+		return nil, errors.New("memcached is not initialized")
+	}
+
+	item, err := Mem.Get("idem-" + key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return item.Value, nil
+}
+
+// Set replaces the value of key for ttl
+func (Responses) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	if Mem == nil {
+		return errors.New("memcached is not initialized")
+	}
+
+	return Mem.Set(&memcache.Item{
+		Key:        "idem-" + key,
+		Value:      value,
+		Expiration: int32(ttl.Seconds()),
+	})
+}
+
+// Delete removes key
+func (Responses) Delete(_ context.Context, key string) error {
+	if Mem == nil {
+		return errors.New("memcached is not initialized")
+	}
+
+	err := Mem.Delete("idem-" + key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil
+	}
+
+	return err
+}
+
+// PingMemcached checks that every Memcached server answers
+func PingMemcached() error {
+	if Mem == nil {
+		return errors.New("memcached is not initialized")
+	}
+
+	return Mem.Ping()
+}
@@ -1,59 +1,137 @@
 package database
 
 import (
+	"bytes"
 	"context"
-	"errors"
+	"encoding/csv"
+	"encoding/json"
 	"log"
-	"os"
 	"strconv"
 	"time"
 
+	"github.com/Gravitalia/gravitalia/certs"
+	"github.com/Gravitalia/gravitalia/config"
 	"github.com/Gravitalia/gravitalia/helpers"
 	"github.com/Gravitalia/gravitalia/model"
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	neo4jconfig "github.com/neo4j/neo4j-go-driver/v5/neo4j/config"
 )
 
-var (
-	ctx     = context.Background()
-	Session neo4j.SessionWithContext
-)
+// MemoryURL is the GRAPH_URL value starting the service
+// without Memgraph, on an in-memory graph
+const MemoryURL = "memory://"
+
+// Memgraph is the Store backed by a Memgraph (or Neo4j) database.
+// The driver is shared, and every call opens its own session
+// from the connection pool
+type Memgraph struct {
+	driver neo4j.DriverWithContext
+	// bookmarks are shared by every session, so that reads
+	// always see previous writes, even on a replica
+	bookmarks neo4j.BookmarkManager
+}
+
+// Init creates the store and the Memcached client
+func Init(cfg *config.Config) Store {
+	ctx := context.Background()
+	Mem = memcache.New(cfg.Memcached.URL)
 
-// Init create the main variable for neo4j connection
-func Init() {
-	driver, _ := neo4j.NewDriverWithContext(os.Getenv("GRAPH_URL"), neo4j.BasicAuth(os.Getenv("GRAPH_USERNAME"), os.Getenv("GRAPH_PASSWORD"), ""))
-	Session = driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
-	Mem = memcache.New(os.Getenv("MEM_URL"))
+	if cfg.Graph.URL == MemoryURL {
+		log.Println("Using in-memory graph, data will be lost on restart")
+		return NewMemory()
+	}
+
+	// Plaintext is never used instead of invalid certificates
+	tlsCfg, err := certs.Config(cfg.Graph.TLS)
+	if err != nil {
+		log.Fatalf("Cannot load graph certificates: %v", err)
+	}
 
-	_, err := Session.Run(ctx, "CREATE CONSTRAINT ON (u:User) ASSERT u.name IS UNIQUE;", nil)
+	driver, err := neo4j.NewDriverWithContext(cfg.Graph.URL, neo4j.BasicAuth(cfg.Graph.Username, cfg.Graph.Password, ""), func(c *neo4jconfig.Config) {
+		if cfg.Graph.PoolSize > 0 {
+			c.MaxConnectionPoolSize = cfg.Graph.PoolSize
+		}
+		c.TlsConfig = tlsCfg
+	})
+	if err != nil {
+		log.Printf("Cannot create graph driver: %v", err)
+	}
+
+	m := &Memgraph{
+		driver:    driver,
+		bookmarks: neo4j.NewBookmarkManager(neo4j.BookmarkManagerConfig{}),
+	}
+
+	session := m.session(ctx, neo4j.AccessModeWrite)
+	defer session.Close(ctx)
+
+	_, err = session.Run(ctx, "CREATE CONSTRAINT ON (u:User) ASSERT u.name IS UNIQUE;", nil)
 	if err != nil {
 		log.Printf("Cannot create constraints on User: %v", err)
 	}
 
-	_, err = Session.Run(ctx, "CREATE CONSTRAINT ON (p:Post) ASSERT p.id IS UNIQUE;", nil)
+	_, err = session.Run(ctx, "CREATE CONSTRAINT ON (p:Post) ASSERT p.id IS UNIQUE;", nil)
 	if err != nil {
 		log.Printf("Cannot create constraints on Post: %v", err)
 	}
 
-	_, err = Session.Run(ctx, "CREATE CONSTRAINT ON (c:Comment) ASSERT c.id IS UNIQUE;", nil)
+	_, err = session.Run(ctx, "CREATE CONSTRAINT ON (c:Comment) ASSERT c.id IS UNIQUE;", nil)
 	if err != nil {
 		log.Printf("Cannot create constraints on Comment: %v", err)
 	}
 
-	_, err = Session.Run(ctx, "CREATE INDEX ON :User(name);", nil)
+	_, err = session.Run(ctx, "CREATE INDEX ON :User(name);", nil)
 	if err != nil {
 		log.Printf("Cannot create index on User: %v", err)
 	}
 
-	_, err = Session.Run(ctx, "CREATE INDEX ON :Post(id);", nil)
+	_, err = session.Run(ctx, "CREATE INDEX ON :Post(id);", nil)
 	if err != nil {
 		log.Printf("Cannot create index on Post: %v", err)
 	}
+
+	return m
+}
+
+// Ping checks that the database answers
+func (m *Memgraph) Ping(ctx context.Context) error {
+	return m.driver.VerifyConnectivity(ctx)
+}
+
+// Close closes every connection of the driver pool
+func (m *Memgraph) Close(ctx context.Context) error {
+	return m.driver.Close(ctx)
+}
+
+// session opens a new session with the wanted access mode
+func (m *Memgraph) session(ctx context.Context, mode neo4j.AccessMode) neo4j.SessionWithContext {
+	return m.driver.NewSession(ctx, neo4j.SessionConfig{
+		AccessMode:      mode,
+		BookmarkManager: m.bookmarks,
+	})
 }
 
-// MakeRequest is a simple way to send a query
-func MakeRequest(query string, params map[string]any) (any, error) {
-	data, err := Session.ExecuteWrite(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
+// read executes a read-only transaction in its own session
+func (m *Memgraph) read(ctx context.Context, work neo4j.ManagedTransactionWork) (any, error) {
+	session := m.session(ctx, neo4j.AccessModeRead)
+	defer session.Close(ctx)
+
+	return session.ExecuteRead(ctx, work)
+}
+
+// write executes a write transaction in its own session
+func (m *Memgraph) write(ctx context.Context, work neo4j.ManagedTransactionWork) (any, error) {
+	session := m.session(ctx, neo4j.AccessModeWrite)
+	defer session.Close(ctx)
+
+	return session.ExecuteWrite(ctx, work)
+}
+
+// single returns a transaction work sending the query,
+// and returning the first value of the first record
+func single(ctx context.Context, query string, params map[string]any) neo4j.ManagedTransactionWork {
+	return func(transaction neo4j.ManagedTransaction) (any, error) {
 		result, err := transaction.Run(ctx,
 			query,
 			params)
@@ -65,18 +143,54 @@ func MakeRequest(query string, params map[string]any) (any, error) {
 			return result.Record().Values[0], nil
 		}
 
+		return nil, result.Err()
+	}
+}
+
+// MakeRequest is a simple way to send a write query
+func (m *Memgraph) MakeRequest(ctx context.Context, query string, params map[string]any) (any, error) {
+	return m.write(ctx, single(ctx, query, params))
+}
+
+// ReadRequest is a simple way to send a read-only query
+func (m *Memgraph) ReadRequest(ctx context.Context, query string, params map[string]any) (any, error) {
+	return m.read(ctx, single(ctx, query, params))
+}
+
+// collectStrings sends a query and returns the
+// first column of every record as a string
+func (m *Memgraph) collectStrings(ctx context.Context, mode neo4j.AccessMode, query string, params map[string]any) ([]string, error) {
+	list := make([]string, 0)
+
+	execute := m.write
+	if mode == neo4j.AccessModeRead {
+		execute = m.read
+	}
+
+	_, err := execute(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
+		result, err := transaction.Run(ctx, query, params)
+		if err != nil {
+			return nil, err
+		}
+
+		for result.Next(ctx) {
+			if value, ok := result.Record().Values[0].(string); ok {
+				list = append(list, value)
+			}
+		}
+
 		return nil, result.Err()
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return data, nil
+	return list, nil
 }
 
 // CreateUser allows to create a new user into the graph database
-func CreateUser(id string) (bool, error) {
-	_, err := MakeRequest("MERGE (:User {name: $id, public: true, suspended: false});",
+func (m *Memgraph) CreateUser(ctx context.Context, id string) (bool, error) {
+	_, err := m.MakeRequest(ctx, "MERGE (:User {name: $id, public: true, suspended: false});",
 		map[string]any{"id": id})
 	if err != nil {
 		return false, err
@@ -86,10 +200,11 @@ func CreateUser(id string) (bool, error) {
 }
 
 // GetProfile returns followers, following and other account data of the desired user
-func GetProfile(id string) (model.Profile, error) {
+func (m *Memgraph) GetProfile(ctx context.Context, id string) (model.Profile, error) {
 	var profile model.Profile
+	var found bool
 
-	_, err := Session.ExecuteWrite(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
+	_, err := m.read(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
 		result, err := transaction.Run(ctx,
 			"MATCH (n:User {name: $id}) OPTIONAL MATCH (n)-[:SUBSCRIBER]->(d:User) OPTIONAL MATCH (n)<-[:SUBSCRIBER]-(u:User) OPTIONAL MATCH (n)-[:CREATE]->(p:Post) RETURN count(DISTINCT u) AS followers, count(DISTINCT d) AS following, n.public, n.suspended, count(DISTINCT p) as postNumber;",
 			map[string]any{"id": id})
@@ -99,8 +214,9 @@ func GetProfile(id string) (model.Profile, error) {
 
 		for result.Next(ctx) {
 			if result.Record().Values[2] == nil {
-				return nil, errors.New("invalid user")
+				return nil, notFound("User")
 			}
+			found = true
 
 			profile.Followers = uint32(result.Record().Values[0].(int64))
 			profile.Following = uint32(result.Record().Values[1].(int64))
@@ -109,7 +225,11 @@ func GetProfile(id string) (model.Profile, error) {
 			profile.PostCount = uint16(result.Record().Values[4].(int64))
 		}
 
-		return profile, nil
+		if !found {
+			return nil, notFound("User")
+		}
+
+		return profile, result.Err()
 	})
 	if err != nil {
 		return model.Profile{Followers: 0, Following: 0}, err
@@ -119,10 +239,10 @@ func GetProfile(id string) (model.Profile, error) {
 }
 
 // GetBasicProfile returns public and suspended
-func GetBasicProfile(id string) (model.Profile, error) {
+func (m *Memgraph) GetBasicProfile(ctx context.Context, id string) (model.Profile, error) {
 	var profile model.Profile
 
-	_, err := Session.ExecuteWrite(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
+	_, err := m.read(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
 		result, err := transaction.Run(ctx,
 			"MATCH (u:User {name: $id}) RETURN u.public, u.suspended;",
 			map[string]any{"id": id})
@@ -130,11 +250,16 @@ func GetBasicProfile(id string) (model.Profile, error) {
 			return nil, err
 		}
 
-		for result.Next(ctx) {
-			profile.Public = result.Record().Values[0].(bool)
-			profile.Suspended = result.Record().Values[1].(bool)
+		if !result.Next(ctx) {
+			if err := result.Err(); err != nil {
+				return nil, err
+			}
+			return nil, notFound("User")
 		}
 
+		profile.Public, _ = result.Record().Values[0].(bool)
+		profile.Suspended, _ = result.Record().Values[1].(bool)
+
 		return profile, nil
 	})
 	if err != nil {
@@ -144,15 +269,119 @@ func GetBasicProfile(id string) (model.Profile, error) {
 	return profile, nil
 }
 
+// SetPublic changes the visibility of an account
+func (m *Memgraph) SetPublic(ctx context.Context, id string, public bool) error {
+	_, err := m.MakeRequest(ctx, "MATCH (u:User {name: $id}) SET u.public = $public;",
+		map[string]any{"id": id, "public": public})
+	return err
+}
+
+// SetSuspended suspends or unsuspends an account
+func (m *Memgraph) SetSuspended(ctx context.Context, id string, suspended bool) error {
+	users, err := m.collectStrings(ctx, neo4j.AccessModeWrite, "MATCH (u:User {name: $id}) SET u.suspended = $suspended RETURN u.name;",
+		map[string]any{"id": id, "suspended": suspended})
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return notFound("User")
+	}
+
+	return nil
+}
+
+// SetRoles replaces the staff roles of an account
+func (m *Memgraph) SetRoles(ctx context.Context, id string, roles []string) error {
+	_, err := m.MakeRequest(ctx, "MATCH (u:User {name: $id}) SET u.roles = $roles;",
+		map[string]any{"id": id, "roles": roles})
+	return err
+}
+
+// GetRoles returns the staff roles of an account
+func (m *Memgraph) GetRoles(ctx context.Context, id string) ([]string, error) {
+	return m.collectStrings(ctx, neo4j.AccessModeRead, "MATCH (u:User {name: $id}) UNWIND coalesce(u.roles, []) AS role RETURN role;",
+		map[string]any{"id": id})
+}
+
+// DeleteUser removes an account, its posts, its comments and its
+// relations, and returns the hashes of medias no longer used
+func (m *Memgraph) DeleteUser(ctx context.Context, id string) ([]string, error) {
+	return m.collectStrings(ctx, neo4j.AccessModeWrite, "MATCH (u:User {name: $id}) OPTIONAL MATCH (u)-[:CREATE]->(p:Post) OPTIONAL MATCH (p)-[:CONTAINS]-(m:Media) OPTIONAL MATCH (pc:Comment)-[:COMMENT]-(p) OPTIONAL MATCH (u)-[:WROTE]->(c:Comment) DETACH DELETE p, pc, c, u WITH DISTINCT m WHERE m IS NOT NULL OPTIONAL MATCH (m)-[r:CONTAINS]-(:Post) WITH m, COUNT(r) as count WHERE count = 0 WITH m, m.hash as hash DETACH DELETE m RETURN hash;",
+		map[string]any{"id": id})
+}
+
+// ExportData returns CSV files with user and posts data
+func (m *Memgraph) ExportData(ctx context.Context, id string) ([]byte, []byte, error) {
+	user, err := m.exportCSV(ctx, "MATCH (u:User {name: $id}) RETURN u.name as vanity, u.community as community_id, u.rank as rank, u.public as is_public, u.suspended as is_suspended;", id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	posts, err := m.exportCSV(ctx, "MATCH (u:User {name: $id})-[r]-(p:Post)-[:CONTAINS]->(m:Media) MATCH (p)-[:SHOW]->(t:Tag) WHERE type(r) = 'CREATE' OR type(r) = 'LIKE' OR type(r) = 'VIEW' OPTIONAL MATCH (p)-[:COMMENT]-(c:Comment)-[:WROTE]-(u) OPTIONAL MATCH (u)-[l:LIKE]->(p) WITH DISTINCT p, m, r, t, count(DISTINCT l) as likes, collect({id: c.id, text: c.text, timestamp: c.timestamp }) as my_comment RETURN p.id as id, p.text as description, [] as images, p.description as automatic_legend, t.name as automatic_tag, likes, type(r) as relation, my_comment;", id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, posts, nil
+}
+
+// exportCSV runs the query for the user id, and returns its records
+// as CSV, after a header of the returned columns. Lists and maps
+// are written as JSON
+func (m *Memgraph) exportCSV(ctx context.Context, query string, id string) ([]byte, error) {
+	content, err := m.read(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
+		result, err := transaction.Run(ctx, query, map[string]any{"id": id})
+		if err != nil {
+			return nil, err
+		}
+
+		var buffer bytes.Buffer
+		writer := csv.NewWriter(&buffer)
+
+		keys, err := result.Keys()
+		if err != nil {
+			return nil, err
+		}
+		writer.Write(keys)
+
+		for result.Next(ctx) {
+			values := result.Record().Values
+			row := make([]string, len(values))
+			for i, value := range values {
+				switch v := value.(type) {
+				case nil:
+				case string:
+					row[i] = v
+				default:
+					encoded, _ := json.Marshal(v)
+					row[i] = string(encoded)
+				}
+			}
+			writer.Write(row)
+		}
+		if err := result.Err(); err != nil {
+			return nil, err
+		}
+
+		writer.Flush()
+		return buffer.Bytes(), writer.Error()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return content.([]byte), nil
+}
+
 // GetUserPost is a function for getting every posts of a user
 // and see their likes
-func GetUserPost(id string, skip uint8) ([]model.Post, error) {
+func (m *Memgraph) GetUserPost(ctx context.Context, id string, skip uint8) ([]model.Post, error) {
 	list := make([]model.Post, 0)
 
-	_, err := Session.ExecuteWrite(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
+	_, err := m.read(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
 		result, err := transaction.Run(ctx,
-			"MATCH (u:User {name: $id})-[:CREATE]->(p:Post)-[:CONTAINS]->(m:Media) OPTIONAL MATCH (p)<-[l:LIKE]-(liker:User) RETURN p.id as id, collect(m.hash), p.description, p.text, count(DISTINCT l) ORDER BY id DESC SKIP 0 LIMIT 12;",
-			map[string]any{"id": id, "skip": skip * 12})
+			"MATCH (u:User {name: $id})-[:CREATE]->(p:Post)-[:CONTAINS]->(m:Media) OPTIONAL MATCH (p)<-[l:LIKE]-(liker:User) RETURN p.id as id, collect(m.hash), p.description, p.text, count(DISTINCT l) ORDER BY id DESC SKIP $skip LIMIT 12;",
+			map[string]any{"id": id, "skip": int(skip) * 12})
 		if err != nil {
 			return nil, err
 		}
@@ -184,69 +413,114 @@ func GetUserPost(id string, skip uint8) ([]model.Post, error) {
 	return list, nil
 }
 
-// UserRelation create a new relation (edge) between two nodes
-func UserRelation(id string, to string, relationType string) (bool, error) {
-	var content string
-	switch relationType {
-	case "SUBSCRIBER", "BLOCK", "REQUEST":
-		content = "User"
-	case "LIKE", "VIEW":
-		content = "Post"
-	case "LOVE":
-		content = "Comment"
+// SetRelation creates the relation from id to to unless it exists.
+// Returns true if the relation has been created
+func (m *Memgraph) SetRelation(ctx context.Context, id string, to string, relationType string) (bool, error) {
+	content, identifier := relationTarget(relationType)
+
+	res, err := m.MakeRequest(ctx, "MATCH (a:User {name: $id}) MATCH (b:"+content+" {"+identifier+": $to}) OPTIONAL MATCH (a)-[r:"+relationType+"]->(b) WITH a, b, count(r) AS existing MERGE (a)-[:"+relationType+"]->(b) RETURN existing = 0;",
+		map[string]any{"id": id, "to": to})
+	if err != nil {
+		return false, err
+	} else if res == nil {
+		return false, notFound(content)
 	}
 
-	var identifier string
-	if content == "User" {
-		identifier = "name"
-	} else {
-		identifier = "id"
+	return res.(bool), nil
+}
+
+// DeleteRelation deletes the relation from id to to.
+// Returns true if the relation existed
+func (m *Memgraph) DeleteRelation(ctx context.Context, id string, to string, relationType string) (bool, error) {
+	content, identifier := relationTarget(relationType)
+
+	res, err := m.MakeRequest(ctx, "MATCH (a:User {name: $id})-[r:"+relationType+"]->(b:"+content+" {"+identifier+": $to}) DELETE r RETURN count(*) > 0;",
+		map[string]any{"id": id, "to": to})
+	if err != nil {
+		return false, err
 	}
 
-	res, err := MakeRequest("MATCH (a:User {name: $id}) MATCH (b:"+content+"{"+identifier+": $to) OPTIONAL MATCH (a)-[r:"+relationType+"]->(b) DELETE r WITH a, b, count(r) AS deleted_count WHERE deleted_count = 0 CREATE (a)-[:BLOCK]->(b)",
+	deleted, _ := res.(bool)
+	return deleted, nil
+}
+
+// ToggleRelation deletes the relation if it exists, otherwise creates it.
+// Returns true if the relation has been deleted
+func (m *Memgraph) ToggleRelation(ctx context.Context, id string, to string, relationType string) (bool, error) {
+	content, identifier := relationTarget(relationType)
+
+	res, err := m.MakeRequest(ctx, "MATCH (a:User {name: $id}) MATCH (b:"+content+"{"+identifier+": $to}) OPTIONAL MATCH (a)-[r:"+relationType+"]->(b) DELETE r FOREACH (x IN CASE WHEN r IS NULL THEN [1] ELSE [] END |	CREATE (a)-[:"+relationType+"]->(b)	) RETURN NOT(r IS NULL);",
 		map[string]any{"id": id, "to": to})
 	if err != nil {
 		return false, err
 	} else if res == nil {
-		return false, errors.New("invalid " + content)
+		return false, notFound(content)
 	}
 
-	return true, nil
+	return res.(bool), nil
 }
 
-// UserUnRelation delete a relation (edge) between two nodes
-func UserUnRelation(id string, to string, relationType string) (bool, error) {
-	var content string
-	switch relationType {
-	case "SUBSCRIBER", "BLOCK", "REQUEST":
-		content = "User"
-	case "LIKE", "VIEW":
-		content = "Post"
-	case "LOVE":
-		content = "Comment"
+// RelationExists checks if a relation goes from id to to
+func (m *Memgraph) RelationExists(ctx context.Context, id string, to string, relationType string) (bool, error) {
+	content, identifier := relationTarget(relationType)
+
+	res, err := m.ReadRequest(ctx, "MATCH (a:User {name: $id})-[:"+relationType+"]->(b:"+content+"{"+identifier+": $to}) RETURN a;",
+		map[string]any{"id": id, "to": to})
+	if err != nil {
+		return false, err
 	}
 
-	var identifier string
-	if content == "User" {
-		identifier = "name"
-	} else {
-		identifier = "id"
+	return res != nil, nil
+}
+
+// RemoveSubscriptions deletes subscriptions in both directions
+func (m *Memgraph) RemoveSubscriptions(ctx context.Context, id string, to string) error {
+	_, err := m.MakeRequest(ctx, "MATCH (:User {name: $id})-[r:SUBSCRIBER]-(:User {name: $to}) DELETE r;",
+		map[string]any{"id": id, "to": to})
+	return err
+}
+
+// AcceptRequest replaces the subscription request from id to to by a subscription
+func (m *Memgraph) AcceptRequest(ctx context.Context, id string, to string) error {
+	_, err := m.MakeRequest(ctx, "MATCH (a:User {name: $id})-[r:REQUEST]->(b:User {name: $to}) DELETE r CREATE (a)-[:SUBSCRIBER]->(b);",
+		map[string]any{"id": id, "to": to})
+	return err
+}
+
+// IsBlocked returns a boolean. If one of the both
+// account blocked the other, returns true.
+func (m *Memgraph) IsBlocked(ctx context.Context, id string, to string) (bool, error) {
+	if id == "" || to == "" {
+		return false, nil
 	}
 
-	_, err := MakeRequest("MATCH (a:User {name: $id})-[r:"+relationType+"]->(b:"+content+" {"+identifier+": $to}) DELETE r QUERY MEMORY LIMIT 1 KB;",
+	res, err := m.ReadRequest(ctx, "MATCH (a:User {name: $id}) MATCH (b:User {name: $to}) OPTIONAL MATCH (a)-[r:BLOCK]-(b) RETURN NOT(r IS NULL);",
 		map[string]any{"id": id, "to": to})
 	if err != nil {
 		return false, err
+	} else if res == nil {
+		return false, nil
 	}
 
-	return true, nil
+	return res.(bool), nil
+}
+
+// GetList returns the users related to id by the list
+func (m *Memgraph) GetList(ctx context.Context, id string, list string) ([]string, error) {
+	if list == "SUBSCRIPTION" {
+		return m.collectStrings(ctx, neo4j.AccessModeRead, "MATCH (:User {name: $id})-[:SUBSCRIBER]->(u:User) RETURN DISTINCT(u.name);",
+			map[string]any{"id": id})
+	}
+
+	return m.collectStrings(ctx, neo4j.AccessModeRead, "MATCH (u:User)-[:"+list+"]->(:User {name: $id}) RETURN DISTINCT(u.name);",
+		map[string]any{"id": id})
 }
 
 // GetPost allows to get data of a post
-func GetPost(id string, user string) (model.Post, error) {
+func (m *Memgraph) GetPost(ctx context.Context, id string, user string) (model.Post, error) {
 	var post model.Post
 
-	_, err := Session.ExecuteWrite(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
+	_, err := m.read(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
 		result, err := transaction.Run(ctx,
 			"MATCH (author:User)-[:CREATE]->(p:Post {id: $id}) OPTIONAL MATCH (p)-[:CONTAINS]-(m:Media) OPTIONAL MATCH (p)<-[:LIKE]-(likeUser:User) OPTIONAL MATCH (p)<-[:COMMENT]-(c:Comment)<-[:WROTE]-(u:User) OPTIONAL MATCH (c)-[love:LOVE]-(lover:User {name: $user}) WITH author, p, lover, COLLECT(m.hash) AS hash, COUNT(DISTINCT likeUser) AS numLikes, c, u, COUNT(DISTINCT love) AS loveComment WITH author, p, hash, numLikes, COLLECT({id: c.id, text: c.text, timestamp: c.timestamp, user: u.name, love: loveComment, me_loved: lover.name IS NOT NULL})[..20] AS comments RETURN p.id, hash, p.description, p.text, numLikes, author.name, comments;",
 			map[string]any{"id": id, "user": user})
@@ -256,7 +530,7 @@ func GetPost(id string, user string) (model.Post, error) {
 
 		if result.Next(ctx) {
 			if result.Record().Values[0] == nil {
-				return nil, errors.New("invalid post")
+				return nil, notFound("Post")
 			}
 			record := result.Record()
 
@@ -271,7 +545,10 @@ func GetPost(id string, user string) (model.Post, error) {
 			return post, nil
 		}
 
-		return nil, result.Err()
+		if err := result.Err(); err != nil {
+			return nil, err
+		}
+		return nil, notFound("Post")
 	})
 	if err != nil {
 		return model.Post{}, err
@@ -280,28 +557,50 @@ func GetPost(id string, user string) (model.Post, error) {
 	return post, nil
 }
 
-// IsUserSubscrirerTo check if a user (id) is subscrired to another one (user)
-// and respond with true if a relation (edge) exists
-// or with false if no relation exists
-func IsUserSubscrirerTo(id string, user string) (bool, error) {
-	res, err := MakeRequest("MATCH (a:User {name: $id})-[:SUBSCRIBER]->(b:User {name: $to}) RETURN a;",
-		map[string]any{"id": id, "to": user})
+// GetPostAuthor returns the vanity of the post creator
+func (m *Memgraph) GetPostAuthor(ctx context.Context, id string) (string, error) {
+	res, err := m.ReadRequest(ctx, "MATCH (u:User)-[:CREATE]-(:Post {id: $id}) RETURN u.name;",
+		map[string]any{"id": id})
 	if err != nil {
-		return false, err
+		return "", err
 	}
 
-	if res != nil {
-		return true, nil
-	} else {
-		return false, nil
-	}
+	author, _ := res.(string)
+	return author, nil
+}
+
+// ViewPost marks a post as viewed by a user
+func (m *Memgraph) ViewPost(ctx context.Context, user string, id string) error {
+	_, err := m.MakeRequest(ctx, "MATCH (a:User {name: $id}) MATCH (b:Post {id: $to}) MERGE (a)-[:VIEW]->(b);",
+		map[string]any{"id": user, "to": id})
+	return err
+}
+
+// DeletePost deletes a post created by user and returns
+// the hashes of medias no longer used by any post
+func (m *Memgraph) DeletePost(ctx context.Context, user string, id string) ([]string, error) {
+	return m.collectStrings(ctx, neo4j.AccessModeWrite, "MATCH (p:Post {id: $to})<-[:CREATE]-(:User {name: $id}) MATCH (p)-[:CONTAINS]-(m:Media) OPTIONAL MATCH (c:Comment)-[:COMMENT]-(p) DETACH DELETE p, c WITH m OPTIONAL MATCH (m:Media)-[r:CONTAINS]-(:Post) WITH m, COUNT(r) as count WHERE count = 0 WITH m, m.hash as hash DETACH DELETE m RETURN hash;",
+		map[string]any{"id": user, "to": id})
+}
+
+// UnusedMedia returns the hashes of medias not used by any post
+func (m *Memgraph) UnusedMedia(ctx context.Context, hashes []string) ([]string, error) {
+	return m.collectStrings(ctx, neo4j.AccessModeRead, "UNWIND $hashes AS hash OPTIONAL MATCH (:Media {hash: hash})-[r:CONTAINS]-(:Post) WITH hash, COUNT(r) as count WHERE count = 0 RETURN hash;",
+		map[string]any{"hashes": hashes})
+}
+
+// IsUserSubscrirerTo check if a user (id) is subscrired to another one (user)
+// and respond with true if a relation (edge) exists
+// or with false if no relation exists
+func (m *Memgraph) IsUserSubscrirerTo(ctx context.Context, id string, user string) (bool, error) {
+	return m.RelationExists(ctx, id, user, "SUBSCRIBER")
 }
 
 // CommentPost allows to post a comment on a post
-func CommentPost(id string, user string, content string) (string, error) {
+func (m *Memgraph) CommentPost(ctx context.Context, id string, user string, content string) (string, error) {
 	comment_id := helpers.Generate()
 
-	_, err := MakeRequest("CREATE (c:Comment {id: $comment_id, text: $content, timestamp: "+strconv.FormatInt(time.Now().Unix(), 10)+"}) WITH c MATCH (p:Post {id: $to}) MATCH (u:User {name: $id}) CREATE (c)-[:COMMENT]->(p) CREATE (u)-[:WROTE]->(c);", map[string]any{"id": user, "to": id, "comment_id": comment_id, "content": content})
+	_, err := m.MakeRequest(ctx, "CREATE (c:Comment {id: $comment_id, text: $content, timestamp: "+strconv.FormatInt(time.Now().Unix(), 10)+"}) WITH c MATCH (p:Post {id: $to}) MATCH (u:User {name: $id}) CREATE (c)-[:COMMENT]->(p) CREATE (u)-[:WROTE]->(c);", map[string]any{"id": user, "to": id, "comment_id": comment_id, "content": content})
 	if err != nil {
 		return "", err
 	}
@@ -310,10 +609,10 @@ func CommentPost(id string, user string, content string) (string, error) {
 }
 
 // CommentReply allows to post a comment on another comment
-func CommentReply(id string, user string, content string, original_comment string) (string, error) {
+func (m *Memgraph) CommentReply(ctx context.Context, id string, user string, content string, original_comment string) (string, error) {
 	comment_id := helpers.Generate()
 
-	_, err := MakeRequest("CREATE (new_comment:Comment {id: $comment_id, text: $content, timestamp: "+strconv.FormatInt(time.Now().Unix(), 10)+"}) WITH new_comment MATCH (:Comment {id: $to})<-[:WROTE]-(u:User) SET new_comment.replied_to = u.name WITH new_comment MATCH (u:User {name: $id}) WITH new_comment, u MATCH (o_comment:Comment {id: $original_comment}) CREATE (new_comment)-[:REPLY]->(o_comment) CREATE (u)-[:WROTE]->(new_comment);", map[string]any{"id": user, "to": id, "comment_id": comment_id, "content": content, "original_comment": original_comment})
+	_, err := m.MakeRequest(ctx, "CREATE (new_comment:Comment {id: $comment_id, text: $content, timestamp: "+strconv.FormatInt(time.Now().Unix(), 10)+"}) WITH new_comment MATCH (:Comment {id: $to})<-[:WROTE]-(u:User) SET new_comment.replied_to = u.name WITH new_comment MATCH (u:User {name: $id}) WITH new_comment, u MATCH (o_comment:Comment {id: $original_comment}) CREATE (new_comment)-[:REPLY]->(o_comment) CREATE (u)-[:WROTE]->(new_comment);", map[string]any{"id": user, "to": id, "comment_id": comment_id, "content": content, "original_comment": original_comment})
 	if err != nil {
 		return "", err
 	}
@@ -322,8 +621,8 @@ func CommentReply(id string, user string, content string, original_comment strin
 }
 
 // GetComments sends 20 comments of a post
-func GetComments(id string, skip int, user string) ([]any, error) {
-	res, err := MakeRequest("MATCH (:Post {id: $id})<-[:COMMENT]-(c:Comment)<-[:WROTE]-(u:User) OPTIONAL MATCH (c:Comment)-[love:LOVE]-(lover:User) WITH  u, lover, c, count(DISTINCT love) as loveComment WITH collect({id: c.id, text: c.text, timestamp: c.timestamp, user: u.name, love: loveComment, me_loved: lover.name = $user }) as comments SKIP $skip LIMIT 20 RETURN comments;",
+func (m *Memgraph) GetComments(ctx context.Context, id string, skip int, user string) ([]any, error) {
+	res, err := m.ReadRequest(ctx, "MATCH (:Post {id: $id})<-[:COMMENT]-(c:Comment)<-[:WROTE]-(u:User) OPTIONAL MATCH (c:Comment)-[love:LOVE]-(lover:User) WITH u, c, count(DISTINCT love) as loveComment, collect(lover.name) as lovers ORDER BY c.timestamp, c.id SKIP $skip LIMIT 20 WITH collect({id: c.id, text: c.text, timestamp: c.timestamp, user: u.name, love: loveComment, me_loved: $user IN lovers }) as comments RETURN comments;",
 		map[string]any{"id": id, "skip": skip, "user": user})
 	if err != nil {
 		return nil, err
@@ -337,8 +636,8 @@ func GetComments(id string, skip int, user string) ([]any, error) {
 }
 
 // GetReply sends 20 replies of a comment
-func GetReply(post_id string, id string, skip int, user string) ([]any, error) {
-	res, err := MakeRequest("MATCH (:Post {id: $post_id})<-[:COMMENT]-(:Comment {id: $id})<-[:REPLY]-(c:Comment)<-[:WROTE]-(u:User) OPTIONAL MATCH (c:Comment)-[love:LOVE]-(lover:User) WITH  u, lover, c, count(DISTINCT love) as loveComment WITH collect({id: c.id, text: c.text, timestamp: c.timestamp, user: u.name, love: loveComment, me_loved: lover.name = $user }) as comments SKIP $skip LIMIT 20 RETURN comments;",
+func (m *Memgraph) GetReply(ctx context.Context, post_id string, id string, skip int, user string) ([]any, error) {
+	res, err := m.ReadRequest(ctx, "MATCH (:Post {id: $post_id})<-[:COMMENT]-(:Comment {id: $id})<-[:REPLY]-(c:Comment)<-[:WROTE]-(u:User) OPTIONAL MATCH (c:Comment)-[love:LOVE]-(lover:User) WITH u, c, count(DISTINCT love) as loveComment, collect(lover.name) as lovers ORDER BY c.timestamp, c.id SKIP $skip LIMIT 20 WITH collect({id: c.id, text: c.text, timestamp: c.timestamp, user: u.name, love: loveComment, me_loved: $user IN lovers }) as comments RETURN comments;",
 		map[string]any{"post_id": post_id, "id": id, "skip": skip, "user": user})
 	if err != nil {
 		return nil, err
@@ -351,11 +650,40 @@ func GetReply(post_id string, id string, skip int, user string) ([]any, error) {
 	}
 }
 
+// CommentExists checks if a comment really exists
+func (m *Memgraph) CommentExists(ctx context.Context, id string) (bool, error) {
+	res, err := m.ReadRequest(ctx, "MATCH (c:Comment {id: $id}) RETURN c;", map[string]any{"id": id})
+	if err != nil {
+		return false, err
+	}
+
+	return res != nil, nil
+}
+
+// GetOriginalComment checks if the comment ID is a reply
+// if yes, return the original comment
+func (m *Memgraph) GetOriginalComment(ctx context.Context, id string) (string, error) {
+	res, err := m.ReadRequest(ctx, "MATCH (:Comment {id: $id})-[:REPLY]->(c:Comment) RETURN c.id;", map[string]any{"id": id})
+	if err != nil {
+		return "", err
+	}
+
+	original, _ := res.(string)
+	return original, nil
+}
+
+// DeleteComment deletes a comment written by user and its replies
+func (m *Memgraph) DeleteComment(ctx context.Context, user string, id string) error {
+	_, err := m.MakeRequest(ctx, "MATCH (c:Comment {id: $to})<-[:WROTE]-(u:User {name: $id}) OPTIONAL MATCH (r:Comment)-[:REPLY]->(c) WITH c, r DETACH DELETE r, c;",
+		map[string]any{"id": user, "to": id})
+	return err
+}
+
 // CreatePost allows to create a new post into database
-func CreatePost(user string, tag string, legend string, hash []string) (string, error) {
+func (m *Memgraph) CreatePost(ctx context.Context, user string, tag string, legend string, hash []string) (string, error) {
 	id := helpers.Generate()
 
-	_, err := MakeRequest("CREATE (p:Post {id: $id, text: $text, description: ''}) FOREACH (	hash IN $hashArray | MERGE (m:Media {type: 'image', hash: hash}) CREATE (p)-[:CONTAINS]->(m)	) WITH p MERGE (t:Tag {name: $tag}) CREATE (p)-[r:SHOW]->(t) WITH p MATCH (u:User {name: $user}) CREATE (u)-[r:CREATE]->(p);",
+	_, err := m.MakeRequest(ctx, "CREATE (p:Post {id: $id, text: $text, description: ''}) FOREACH (	hash IN $hashArray | MERGE (m:Media {type: 'image', hash: hash}) CREATE (p)-[:CONTAINS]->(m)	) WITH p MERGE (t:Tag {name: $tag}) CREATE (p)-[r:SHOW]->(t) WITH p MATCH (u:User {name: $user}) CREATE (u)-[r:CREATE]->(p);",
 		map[string]any{"id": id, "user": user, "tag": tag, "text": legend, "hashArray": hash})
 	if err != nil {
 		return "", err
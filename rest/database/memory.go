@@ -0,0 +1,786 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/helpers"
+	"github.com/Gravitalia/gravitalia/model"
+)
+
+type memoryUser struct {
+	public    bool
+	suspended bool
+	roles     []string
+}
+
+type memoryPost struct {
+	id     string
+	text   string
+	tag    string
+	hash   []string
+	author string
+}
+
+type memoryComment struct {
+	id        string
+	text      string
+	timestamp int64
+	// author is the vanity of the user who wrote the comment
+	author string
+	// post is only set on comments made directly on a post
+	post string
+	// replyTo is the original comment of a reply
+	replyTo   string
+	repliedTo string
+}
+
+// edge is a relation going from a user to a node
+type edge struct {
+	from     string
+	relation string
+	to       string
+}
+
+// Memory is an in-memory graph implementing Store.
+// It follows the semantics of the Memgraph queries
+// and is safe for concurrent use
+type Memory struct {
+	mu        sync.RWMutex
+	users     map[string]*memoryUser
+	posts     map[string]*memoryPost
+	comments  map[string]*memoryComment
+	relations map[edge]struct{}
+}
+
+// NewMemory creates an empty in-memory graph
+func NewMemory() *Memory {
+	return &Memory{
+		users:     make(map[string]*memoryUser),
+		posts:     make(map[string]*memoryPost),
+		comments:  make(map[string]*memoryComment),
+		relations: make(map[edge]struct{}),
+	}
+}
+
+// nodeExists checks if the node targeted by the relation exists
+func (m *Memory) nodeExists(relationType string, id string) bool {
+	switch content, _ := relationTarget(relationType); content {
+	case "User":
+		return m.users[id] != nil
+	case "Post":
+		return m.posts[id] != nil
+	case "Comment":
+		return m.comments[id] != nil
+	}
+
+	return false
+}
+
+// removeEdgesTo deletes every relation targeting the node
+func (m *Memory) removeEdgesTo(content string, id string) {
+	for e := range m.relations {
+		if target, _ := relationTarget(e.relation); target == content && e.to == id {
+			delete(m.relations, e)
+		}
+	}
+}
+
+// countEdgesTo counts the relations of a type targeting the node
+func (m *Memory) countEdgesTo(relationType string, id string) int64 {
+	var count int64
+	for e := range m.relations {
+		if e.relation == relationType && e.to == id {
+			count++
+		}
+	}
+
+	return count
+}
+
+// formatComment returns a comment as sent by Memgraph
+func (m *Memory) formatComment(c *memoryComment, user string) map[string]any {
+	_, loved := m.relations[edge{user, "LOVE", c.id}]
+
+	return map[string]any{
+		"id":        c.id,
+		"text":      c.text,
+		"timestamp": c.timestamp,
+		"user":      c.author,
+		"love":      m.countEdgesTo("LOVE", c.id),
+		"me_loved":  loved,
+	}
+}
+
+// sortedComments returns the comments matching the filter,
+// from the oldest to the newest
+func (m *Memory) sortedComments(filter func(c *memoryComment) bool) []*memoryComment {
+	list := make([]*memoryComment, 0)
+	for _, c := range m.comments {
+		if c.author != "" && filter(c) {
+			list = append(list, c)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].timestamp == list[j].timestamp {
+			return list[i].id < list[j].id
+		}
+		return list[i].timestamp < list[j].timestamp
+	})
+
+	return list
+}
+
+// paginateComments formats 20 comments after skip
+func (m *Memory) paginateComments(list []*memoryComment, skip int, user string) []any {
+	comments := make([]any, 0)
+	for i := skip; i < len(list) && i < skip+20; i++ {
+		comments = append(comments, m.formatComment(list[i], user))
+	}
+
+	return comments
+}
+
+// CreateUser allows to create a new user into the graph
+func (m *Memory) CreateUser(_ context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.users[id] == nil {
+		m.users[id] = &memoryUser{public: true}
+	}
+
+	return true, nil
+}
+
+// GetProfile returns followers, following and other account data of the desired user
+func (m *Memory) GetProfile(_ context.Context, id string) (model.Profile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user := m.users[id]
+	if user == nil {
+		return model.Profile{Followers: 0, Following: 0}, notFound("User")
+	}
+
+	profile := model.Profile{
+		Public:    user.public,
+		Suspended: user.suspended,
+	}
+	for e := range m.relations {
+		if e.relation != "SUBSCRIBER" {
+			continue
+		}
+		if e.to == id {
+			profile.Followers++
+		}
+		if e.from == id {
+			profile.Following++
+		}
+	}
+	for _, p := range m.posts {
+		if p.author == id {
+			profile.PostCount++
+		}
+	}
+
+	return profile, nil
+}
+
+// GetBasicProfile returns public and suspended
+func (m *Memory) GetBasicProfile(_ context.Context, id string) (model.Profile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user := m.users[id]
+	if user == nil {
+		return model.Profile{}, notFound("User")
+	}
+
+	return model.Profile{Public: user.public, Suspended: user.suspended}, nil
+}
+
+// SetPublic changes the visibility of an account
+func (m *Memory) SetPublic(_ context.Context, id string, public bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user := m.users[id]; user != nil {
+		user.public = public
+	}
+
+	return nil
+}
+
+// SetSuspended suspends or unsuspends an account
+func (m *Memory) SetSuspended(_ context.Context, id string, suspended bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user := m.users[id]
+	if user == nil {
+		return notFound("User")
+	}
+	user.suspended = suspended
+
+	return nil
+}
+
+// SetRoles replaces the staff roles of an account
+func (m *Memory) SetRoles(_ context.Context, id string, roles []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user := m.users[id]; user != nil {
+		user.roles = append([]string(nil), roles...)
+	}
+
+	return nil
+}
+
+// GetRoles returns the staff roles of an account
+func (m *Memory) GetRoles(_ context.Context, id string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if user := m.users[id]; user != nil {
+		return append([]string(nil), user.roles...), nil
+	}
+
+	return nil, nil
+}
+
+// DeleteUser removes an account, its posts, its comments and its
+// relations, and returns the hashes of medias no longer used
+func (m *Memory) DeleteUser(_ context.Context, id string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.users[id] == nil {
+		return make([]string, 0), nil
+	}
+
+	var hashes []string
+	seen := make(map[string]bool)
+	for postID, p := range m.posts {
+		if p.author != id {
+			continue
+		}
+
+		for _, hash := range p.hash {
+			if !seen[hash] {
+				seen[hash] = true
+				hashes = append(hashes, hash)
+			}
+		}
+		for commentID, c := range m.comments {
+			if c.post == postID {
+				delete(m.comments, commentID)
+				m.removeEdgesTo("Comment", commentID)
+			}
+		}
+		m.removeEdgesTo("Post", postID)
+		delete(m.posts, postID)
+	}
+
+	for commentID, c := range m.comments {
+		if c.author == id {
+			delete(m.comments, commentID)
+			m.removeEdgesTo("Comment", commentID)
+		}
+	}
+	for e := range m.relations {
+		if e.from == id {
+			delete(m.relations, e)
+		}
+	}
+	m.removeEdgesTo("User", id)
+	delete(m.users, id)
+
+	return m.unusedMedia(hashes), nil
+}
+
+// ExportData returns user and posts data as CSV files
+func (m *Memory) ExportData(_ context.Context, id string) ([]byte, []byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	userBuffer := new(bytes.Buffer)
+	userWriter := csv.NewWriter(userBuffer)
+	userWriter.Write([]string{"vanity", "community_id", "rank", "is_public", "is_suspended"})
+	if user := m.users[id]; user != nil {
+		userWriter.Write([]string{id, "", "", strconv.FormatBool(user.public), strconv.FormatBool(user.suspended)})
+	}
+	userWriter.Flush()
+
+	postBuffer := new(bytes.Buffer)
+	postWriter := csv.NewWriter(postBuffer)
+	postWriter.Write([]string{"id", "description", "images", "automatic_legend", "automatic_tag", "likes", "relation", "my_comment"})
+	for _, p := range m.sortedPosts() {
+		relations := make([]string, 0, 3)
+		if p.author == id {
+			relations = append(relations, "CREATE")
+		}
+		for _, relation := range []string{"LIKE", "VIEW"} {
+			if _, ok := m.relations[edge{id, relation, p.id}]; ok {
+				relations = append(relations, relation)
+			}
+		}
+
+		myComments := make([]map[string]any, 0)
+		for _, c := range m.sortedComments(func(c *memoryComment) bool { return c.post == p.id && c.author == id }) {
+			myComments = append(myComments, map[string]any{"id": c.id, "text": c.text, "timestamp": c.timestamp})
+		}
+		comments, _ := json.Marshal(myComments)
+
+		var likes int64
+		if _, ok := m.relations[edge{id, "LIKE", p.id}]; ok {
+			likes = 1
+		}
+
+		for _, relation := range relations {
+			postWriter.Write([]string{p.id, p.text, "[]", "", p.tag, strconv.FormatInt(likes, 10), relation, string(comments)})
+		}
+	}
+	postWriter.Flush()
+
+	return userBuffer.Bytes(), postBuffer.Bytes(), nil
+}
+
+// sortedPosts returns every post, newest first
+func (m *Memory) sortedPosts() []*memoryPost {
+	list := make([]*memoryPost, 0, len(m.posts))
+	for _, p := range m.posts {
+		list = append(list, p)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].id > list[j].id
+	})
+
+	return list
+}
+
+// CreatePost allows to create a new post
+func (m *Memory) CreatePost(_ context.Context, user string, tag string, legend string, hash []string) (string, error) {
+	id := helpers.Generate()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post := &memoryPost{
+		id:   id,
+		text: legend,
+		tag:  tag,
+		hash: append([]string(nil), hash...),
+	}
+	if m.users[user] != nil {
+		post.author = user
+	}
+	m.posts[id] = post
+
+	return id, nil
+}
+
+// GetPost allows to get data of a post
+func (m *Memory) GetPost(_ context.Context, id string, user string) (model.Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p := m.posts[id]
+	if p == nil {
+		return model.Post{}, notFound("Post")
+	}
+
+	hash := make([]any, len(p.hash))
+	for i, h := range p.hash {
+		hash[i] = h
+	}
+
+	comments := m.sortedComments(func(c *memoryComment) bool { return c.post == id })
+
+	return model.Post{
+		Id:          p.id,
+		Hash:        hash,
+		Description: "",
+		Text:        p.text,
+		Like:        m.countEdgesTo("LIKE", id),
+		Author:      p.author,
+		Comments:    m.paginateComments(comments, 0, user),
+	}, nil
+}
+
+// GetUserPost is a function for getting every posts of a user
+// and see their likes
+func (m *Memory) GetUserPost(_ context.Context, id string, skip uint8) ([]model.Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := make([]model.Post, 0)
+	posts := m.sortedPosts()
+	start := int(skip) * 12
+	for _, p := range posts {
+		if p.author != id || len(p.hash) == 0 {
+			continue
+		}
+		if start > 0 {
+			start--
+			continue
+		}
+		if len(list) == 12 {
+			break
+		}
+
+		hash := make([]any, len(p.hash))
+		for i, h := range p.hash {
+			hash[i] = h
+		}
+
+		list = append(list, model.Post{
+			Id:   p.id,
+			Hash: hash,
+			Text: p.text,
+			Like: m.countEdgesTo("LIKE", p.id),
+		})
+	}
+
+	return list, nil
+}
+
+// GetPostAuthor returns the vanity of the post creator
+func (m *Memory) GetPostAuthor(_ context.Context, id string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if p := m.posts[id]; p != nil {
+		return p.author, nil
+	}
+
+	return "", nil
+}
+
+// ViewPost marks a post as viewed by a user
+func (m *Memory) ViewPost(_ context.Context, user string, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.users[user] != nil && m.posts[id] != nil {
+		m.relations[edge{user, "VIEW", id}] = struct{}{}
+	}
+
+	return nil
+}
+
+// DeletePost deletes a post created by user and returns
+// the hashes of medias no longer used by any post
+func (m *Memory) DeletePost(_ context.Context, user string, id string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hashes := make([]string, 0)
+
+	p := m.posts[id]
+	if p == nil || p.author != user || len(p.hash) == 0 {
+		return hashes, nil
+	}
+
+	for commentID, c := range m.comments {
+		if c.post == id {
+			delete(m.comments, commentID)
+			m.removeEdgesTo("Comment", commentID)
+		}
+	}
+	m.removeEdgesTo("Post", id)
+	delete(m.posts, id)
+
+	return m.unusedMedia(p.hash), nil
+}
+
+// UnusedMedia returns the hashes of medias not used by any post
+func (m *Memory) UnusedMedia(_ context.Context, hashes []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.unusedMedia(hashes), nil
+}
+
+// unusedMedia returns the hashes not used by any post
+func (m *Memory) unusedMedia(hashes []string) []string {
+	unused := make([]string, 0)
+
+	for _, hash := range hashes {
+		used := false
+		for _, other := range m.posts {
+			for _, h := range other.hash {
+				if h == hash {
+					used = true
+				}
+			}
+		}
+
+		if !used {
+			unused = append(unused, hash)
+		}
+	}
+
+	return unused
+}
+
+// CommentPost allows to post a comment on a post
+func (m *Memory) CommentPost(_ context.Context, id string, user string, content string) (string, error) {
+	comment_id := helpers.Generate()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment := &memoryComment{
+		id:        comment_id,
+		text:      content,
+		timestamp: time.Now().Unix(),
+	}
+	if m.posts[id] != nil && m.users[user] != nil {
+		comment.post = id
+		comment.author = user
+	}
+	m.comments[comment_id] = comment
+
+	return comment_id, nil
+}
+
+// CommentReply allows to post a comment on another comment
+func (m *Memory) CommentReply(_ context.Context, id string, user string, content string, original_comment string) (string, error) {
+	comment_id := helpers.Generate()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment := &memoryComment{
+		id:        comment_id,
+		text:      content,
+		timestamp: time.Now().Unix(),
+	}
+	if to := m.comments[id]; to != nil && to.author != "" {
+		comment.repliedTo = to.author
+
+		if m.users[user] != nil && m.comments[original_comment] != nil {
+			comment.replyTo = original_comment
+			comment.author = user
+		}
+	}
+	m.comments[comment_id] = comment
+
+	return comment_id, nil
+}
+
+// GetComments sends 20 comments of a post
+func (m *Memory) GetComments(_ context.Context, id string, skip int, user string) ([]any, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	comments := m.sortedComments(func(c *memoryComment) bool { return c.post == id })
+
+	return m.paginateComments(comments, skip, user), nil
+}
+
+// GetReply sends 20 replies of a comment
+func (m *Memory) GetReply(_ context.Context, post_id string, id string, skip int, user string) ([]any, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if original := m.comments[id]; original == nil || original.post != post_id {
+		return make([]any, 0), nil
+	}
+
+	replies := m.sortedComments(func(c *memoryComment) bool { return c.replyTo == id })
+
+	return m.paginateComments(replies, skip, user), nil
+}
+
+// CommentExists checks if a comment really exists
+func (m *Memory) CommentExists(_ context.Context, id string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.comments[id] != nil, nil
+}
+
+// GetOriginalComment checks if the comment ID is a reply
+// if yes, return the original comment
+func (m *Memory) GetOriginalComment(_ context.Context, id string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if c := m.comments[id]; c != nil {
+		return c.replyTo, nil
+	}
+
+	return "", nil
+}
+
+// DeleteComment deletes a comment written by user and its replies
+func (m *Memory) DeleteComment(_ context.Context, user string, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.comments[id]
+	if c == nil || c.author != user {
+		return nil
+	}
+
+	for replyID, reply := range m.comments {
+		if reply.replyTo == id {
+			delete(m.comments, replyID)
+			m.removeEdgesTo("Comment", replyID)
+		}
+	}
+	delete(m.comments, id)
+	m.removeEdgesTo("Comment", id)
+
+	return nil
+}
+
+// IsUserSubscrirerTo check if a user (id) is subscrired to another one (user)
+func (m *Memory) IsUserSubscrirerTo(ctx context.Context, id string, user string) (bool, error) {
+	return m.RelationExists(ctx, id, user, "SUBSCRIBER")
+}
+
+// IsBlocked returns a boolean. If one of the both
+// account blocked the other, returns true.
+func (m *Memory) IsBlocked(_ context.Context, id string, to string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.users[id] == nil || m.users[to] == nil {
+		return false, nil
+	}
+
+	_, blocked := m.relations[edge{id, "BLOCK", to}]
+	_, blockedBy := m.relations[edge{to, "BLOCK", id}]
+
+	return blocked || blockedBy, nil
+}
+
+// RelationExists checks if a relation goes from id to to
+func (m *Memory) RelationExists(_ context.Context, id string, to string, relationType string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.relations[edge{id, relationType, to}]
+	return ok, nil
+}
+
+// ToggleRelation deletes the relation if it exists, otherwise creates it.
+// Returns true if the relation has been deleted
+func (m *Memory) ToggleRelation(_ context.Context, id string, to string, relationType string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.users[id] == nil || !m.nodeExists(relationType, to) {
+		content, _ := relationTarget(relationType)
+		return false, notFound(content)
+	}
+
+	e := edge{id, relationType, to}
+	if _, ok := m.relations[e]; ok {
+		delete(m.relations, e)
+		return true, nil
+	}
+
+	m.relations[e] = struct{}{}
+	return false, nil
+}
+
+// SetRelation creates the relation from id to to unless it exists.
+// Returns true if the relation has been created
+func (m *Memory) SetRelation(_ context.Context, id string, to string, relationType string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.users[id] == nil || !m.nodeExists(relationType, to) {
+		content, _ := relationTarget(relationType)
+		return false, notFound(content)
+	}
+
+	e := edge{id, relationType, to}
+	if _, ok := m.relations[e]; ok {
+		return false, nil
+	}
+
+	m.relations[e] = struct{}{}
+	return true, nil
+}
+
+// DeleteRelation deletes the relation from id to to.
+// Returns true if the relation existed
+func (m *Memory) DeleteRelation(_ context.Context, id string, to string, relationType string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := edge{id, relationType, to}
+	if _, ok := m.relations[e]; ok {
+		delete(m.relations, e)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// RemoveSubscriptions deletes subscriptions in both directions
+func (m *Memory) RemoveSubscriptions(_ context.Context, id string, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.relations, edge{id, "SUBSCRIBER", to})
+	delete(m.relations, edge{to, "SUBSCRIBER", id})
+
+	return nil
+}
+
+// AcceptRequest replaces the subscription request from id to to by a subscription
+func (m *Memory) AcceptRequest(_ context.Context, id string, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	request := edge{id, "REQUEST", to}
+	if _, ok := m.relations[request]; ok {
+		delete(m.relations, request)
+		m.relations[edge{id, "SUBSCRIBER", to}] = struct{}{}
+	}
+
+	return nil
+}
+
+// GetList returns the users related to id by the list
+func (m *Memory) GetList(_ context.Context, id string, list string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := make([]string, 0)
+	for e := range m.relations {
+		if list == "SUBSCRIPTION" && e.relation == "SUBSCRIBER" && e.from == id {
+			users = append(users, e.to)
+		} else if e.relation == list && e.to == id {
+			users = append(users, e.from)
+		}
+	}
+	sort.Strings(users)
+
+	return users, nil
+}
+
+// Close does nothing, the graph only lives in memory
+func (m *Memory) Close(_ context.Context) error {
+	return nil
+}
+
+// Ping always succeeds, the graph only lives in memory
+func (m *Memory) Ping(_ context.Context) error {
+	return nil
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Gravitalia/gravitalia/model"
+)
+
+// ErrNotFound is returned when the requested node doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// notFound wraps ErrNotFound with the label of the missing node
+func notFound(label string) error {
+	return fmt.Errorf("%v %w", strings.ToLower(label), ErrNotFound)
+}
+
+// Store is the persistence layer used by every handler.
+// It is implemented by Memgraph for production and
+// by Memory for tests and dependency-free development
+type Store interface {
+	// CreateUser creates the user if it doesn't exist yet
+	CreateUser(ctx context.Context, id string) (bool, error)
+	// GetProfile returns followers, following and other account data.
+	// Every getter returns ErrNotFound if the node doesn't exist
+	GetProfile(ctx context.Context, id string) (model.Profile, error)
+	// GetBasicProfile only returns public and suspended
+	GetBasicProfile(ctx context.Context, id string) (model.Profile, error)
+	// SetPublic changes the visibility of an account
+	SetPublic(ctx context.Context, id string, public bool) error
+	// SetSuspended suspends or unsuspends an account,
+	// and returns ErrNotFound for unknown users
+	SetSuspended(ctx context.Context, id string, suspended bool) error
+	// SetRoles replaces the staff roles of an account
+	SetRoles(ctx context.Context, id string, roles []string) error
+	// GetRoles returns the staff roles of an account,
+	// none for unknown users
+	GetRoles(ctx context.Context, id string) ([]string, error)
+	// DeleteUser removes an account, its posts, its comments and its
+	// relations, and returns the hashes of medias no longer used
+	DeleteUser(ctx context.Context, id string) ([]string, error)
+	// ExportData returns user and posts data as CSV files
+	ExportData(ctx context.Context, id string) (user []byte, posts []byte, err error)
+
+	// CreatePost creates a new post and returns its ID
+	CreatePost(ctx context.Context, user string, tag string, legend string, hash []string) (string, error)
+	// GetPost returns a post with its first comments
+	GetPost(ctx context.Context, id string, user string) (model.Post, error)
+	// GetUserPost returns the posts created by a user
+	GetUserPost(ctx context.Context, id string, skip uint8) ([]model.Post, error)
+	// GetPostAuthor returns the vanity of the post creator
+	GetPostAuthor(ctx context.Context, id string) (string, error)
+	// ViewPost marks a post as viewed by a user
+	ViewPost(ctx context.Context, user string, id string) error
+	// DeletePost deletes a post created by user and returns
+	// the hashes of medias no longer used by any post
+	DeletePost(ctx context.Context, user string, id string) ([]string, error)
+	// UnusedMedia returns the hashes of medias not used by any post
+	UnusedMedia(ctx context.Context, hashes []string) ([]string, error)
+
+	// CommentPost creates a comment on a post
+	CommentPost(ctx context.Context, id string, user string, content string) (string, error)
+	// CommentReply creates a reply to a comment
+	CommentReply(ctx context.Context, id string, user string, content string, original_comment string) (string, error)
+	// GetComments returns 20 comments of a post
+	GetComments(ctx context.Context, id string, skip int, user string) ([]any, error)
+	// GetReply returns 20 replies of a comment
+	GetReply(ctx context.Context, post_id string, id string, skip int, user string) ([]any, error)
+	// CommentExists checks if a comment really exists
+	CommentExists(ctx context.Context, id string) (bool, error)
+	// GetOriginalComment returns the comment replied to
+	// by id, or an empty string if id is not a reply
+	GetOriginalComment(ctx context.Context, id string) (string, error)
+	// DeleteComment deletes a comment written by user and its replies
+	DeleteComment(ctx context.Context, user string, id string) error
+
+	// IsUserSubscrirerTo checks if id is subscribed to user
+	IsUserSubscrirerTo(ctx context.Context, id string, user string) (bool, error)
+	// IsBlocked checks if one of the both accounts blocked the other
+	IsBlocked(ctx context.Context, id string, to string) (bool, error)
+	// RelationExists checks if a relation goes from id to to
+	RelationExists(ctx context.Context, id string, to string, relationType string) (bool, error)
+	// SetRelation creates the relation from id to to unless it
+	// exists, and returns true if it has been created
+	SetRelation(ctx context.Context, id string, to string, relationType string) (bool, error)
+	// DeleteRelation deletes the relation from id to to,
+	// and returns true if it existed
+	DeleteRelation(ctx context.Context, id string, to string, relationType string) (bool, error)
+	// ToggleRelation deletes the relation if it exists, otherwise
+	// creates it. Returns true if the relation has been deleted
+	ToggleRelation(ctx context.Context, id string, to string, relationType string) (bool, error)
+	// RemoveSubscriptions deletes subscriptions in both directions
+	RemoveSubscriptions(ctx context.Context, id string, to string) error
+	// AcceptRequest replaces the subscription request
+	// from id to to by a subscription
+	AcceptRequest(ctx context.Context, id string, to string) error
+
+	// GetList returns the users related to id by the list
+	// (SUBSCRIBER, SUBSCRIPTION, BLOCK or REQUEST)
+	GetList(ctx context.Context, id string, list string) ([]string, error)
+
+	// Ping checks that the database can be reached
+	Ping(ctx context.Context) error
+	// Close releases the connections, once every request is done
+	Close(ctx context.Context) error
+}
+
+// relationTarget returns the label of the node targeted by the
+// relation, and the property identifying it
+func relationTarget(relationType string) (string, string) {
+	switch relationType {
+	case "SUBSCRIBER", "BLOCK", "REQUEST":
+		return "User", "name"
+	case "LIKE", "VIEW":
+		return "Post", "id"
+	case "LOVE":
+		return "Comment", "id"
+	}
+
+	return "", ""
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/Gravitalia/gravitalia/config"
+)
+
+// stores returns the in-memory graph, and Memgraph if TEST_GRAPH_URL
+// is set, such as "bolt://localhost:7687", so both run the same tests
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	stores := map[string]Store{"memory": NewMemory()}
+	if url := os.Getenv("TEST_GRAPH_URL"); url != "" {
+		store := Init(&config.Config{Graph: config.Graph{URL: url}})
+		if err := store.Ping(context.Background()); err != nil {
+			t.Fatalf("cannot reach %v: %v", url, err)
+		}
+		t.Cleanup(func() { store.Close(context.Background()) })
+		stores["memgraph"] = store
+	}
+
+	return stores
+}
+
+func TestDeleteUser(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, user := range []string{"deleted", "reader"} {
+				store.DeleteUser(ctx, user)
+				store.CreateUser(ctx, user)
+			}
+
+			post, _ := store.CreatePost(ctx, "deleted", "cat", "legend", []string{"deleted-only", "deleted-shared"})
+			other, _ := store.CreatePost(ctx, "reader", "cat", "legend", []string{"deleted-shared"})
+			kept, _ := store.CommentPost(ctx, post, "reader", "nice")
+			store.CommentPost(ctx, other, "deleted", "nice")
+			store.SetRelation(ctx, "reader", post, "LIKE")
+			store.SetRelation(ctx, "reader", "deleted", "SUBSCRIBER")
+
+			hashes, err := store.DeleteUser(ctx, "deleted")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hashes) != 1 || hashes[0] != "deleted-only" {
+				t.Fatalf("unused medias got %v, want [deleted-only]", hashes)
+			}
+
+			// Posts are deleted with their comments
+			if _, err := store.GetPost(ctx, post, "reader"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("post of deleted user got %v, want %v", err, ErrNotFound)
+			}
+			if exists, _ := store.CommentExists(ctx, kept); exists {
+				t.Fatalf("comment on post of deleted user is kept")
+			}
+			if posts, _ := store.GetUserPost(ctx, "deleted", 0); len(posts) != 0 {
+				t.Fatalf("deleted user has %d posts", len(posts))
+			}
+
+			// Other posts only lose the comments of the user
+			if comments, err := store.GetComments(ctx, other, 0, "reader"); err != nil || len(comments) != 0 {
+				t.Fatalf("comments of deleted user got %v %v", comments, err)
+			}
+			if list, _ := store.GetList(ctx, "reader", "SUBSCRIPTION"); len(list) != 0 {
+				t.Fatalf("subscriptions of reader got %v", list)
+			}
+			if _, err := store.GetProfile(ctx, "deleted"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("deleted user got %v, want %v", err, ErrNotFound)
+			}
+
+			store.DeleteUser(ctx, "reader")
+		})
+	}
+}
+
+func TestSetSuspended(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.CreateUser(ctx, "suspended")
+			defer store.DeleteUser(ctx, "suspended")
+
+			if err := store.SetSuspended(ctx, "suspended", true); err != nil {
+				t.Fatal(err)
+			}
+			if profile, _ := store.GetBasicProfile(ctx, "suspended"); !profile.Suspended {
+				t.Fatal("user should be suspended")
+			}
+
+			if err := store.SetSuspended(ctx, "unknown", true); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("suspending unknown user got %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+// Package filestore keeps entries, such as uploads or jobs, in a
+// directory. Each entry is a JSON info file and a data file sharing
+// a random ID, so replicas sharing the directory see every entry
+package filestore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Dir is the path of a directory of entries
+type Dir string
+
+// Open creates the directory if needed
+func Open(path string) (Dir, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return "", err
+	}
+
+	return Dir(path), nil
+}
+
+// NewID returns a random ID of 32 hexadecimal characters
+func NewID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// ValidID checks that the ID could be returned by NewID,
+// so IDs sent by clients never reach other files
+func ValidID(id string) bool {
+	return len(id) == 32 && strings.Trim(id, "0123456789abcdef") == ""
+}
+
+// Path returns the path of a file of the entry, ".json"
+// for its info and ".bin" for its data
+func (d Dir) Path(id string, extension string) string {
+	return filepath.Join(string(d), id+extension)
+}
+
+// Load decodes the info of the entry into v,
+// and returns os.ErrNotExist if it doesn't exist
+func (d Dir) Load(id string, v any) error {
+	content, err := os.ReadFile(d.Path(id, ".json"))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, v)
+}
+
+// Save writes the info of the entry. It is written to a temporary
+// file then renamed, so readers never see a partial file
+func (d Dir) Save(id string, v any) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp := d.Path(id, ".json.tmp")
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, d.Path(id, ".json"))
+}
+
+// Remove deletes the files of the entry, its info last
+// so scans find entries whose removal was interrupted
+func (d Dir) Remove(id string) error {
+	if err := os.Remove(d.Path(id, ".bin")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return os.Remove(d.Path(id, ".json"))
+}
+
+// Scan calls fn with the ID of each entry. Data without info, left
+// if creating the entry failed, and temporary info, left by instances
+// stopped while saving it, are removed once older than orphanAge
+func (d Dir) Scan(orphanAge time.Duration, fn func(id string)) error {
+	entries, err := os.ReadDir(string(d))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if id, found := strings.CutSuffix(entry.Name(), ".json"); found {
+			fn(id)
+			continue
+		}
+
+		stat, err := entry.Info()
+		if err != nil || time.Since(stat.ModTime()) <= orphanAge {
+			continue
+		}
+
+		if strings.HasSuffix(entry.Name(), ".json.tmp") {
+			os.Remove(filepath.Join(string(d), entry.Name()))
+		} else if id, found := strings.CutSuffix(entry.Name(), ".bin"); found {
+			if _, err := os.Stat(d.Path(id, ".json")); errors.Is(err, os.ErrNotExist) {
+				os.Remove(d.Path(id, ".bin"))
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package filestore
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestScan(t *testing.T) {
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _ := NewID()
+	if !ValidID(id) || ValidID("../"+id[3:]) {
+		t.Fatalf("invalid ID %q", id)
+	}
+	d.Save(id, map[string]string{"id": id})
+	os.WriteFile(d.Path(id, ".bin"), nil, 0600)
+
+	// Left by a failed creation, and by an interrupted save
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{d.Path("orphan", ".bin"), d.Path("stopped", ".json.tmp")} {
+		os.WriteFile(name, nil, 0600)
+		os.Chtimes(name, old, old)
+	}
+
+	var ids []string
+	if err := d.Scan(time.Minute, func(id string) { ids = append(ids, id) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != id {
+		t.Fatalf("scan got %v, want [%v]", ids, id)
+	}
+
+	for _, name := range []string{d.Path("orphan", ".bin"), d.Path("stopped", ".json.tmp")} {
+		if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%v is kept", name)
+		}
+	}
+	if _, err := os.Stat(d.Path(id, ".bin")); err != nil {
+		t.Errorf("data of the entry is removed: %v", err)
+	}
+
+	var info map[string]string
+	if err := d.Load(id, &info); err != nil || info["id"] != id {
+		t.Fatalf("load got %v %v", info, err)
+	}
+	if err := d.Remove(id); err != nil || !errors.Is(d.Load(id, &info), os.ErrNotExist) {
+		t.Fatalf("removed entry is kept: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package filestore
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Locker prevents two requests or workers, maybe of
+// different instances, from changing the same entry at once
+type Locker interface {
+	// Lock takes the lock of key for ttl at most,
+	// and returns false if it is already taken
+	Lock(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Unlock releases the lock of key
+	Unlock(ctx context.Context, key string) error
+}
+
+// MemoryLocks keeps locks in memory. It only protects a single
+// instance, replicas must share locks such as in Memcached
+type MemoryLocks struct {
+	mu    sync.Mutex
+	locks map[string]time.Time
+}
+
+// NewMemoryLocks creates an in-memory locker
+func NewMemoryLocks() *MemoryLocks {
+	return &MemoryLocks{locks: make(map[string]time.Time)}
+}
+
+// Lock takes the lock of key for ttl at most,
+// and returns false if it is already taken
+func (m *MemoryLocks) Lock(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if expiresAt, ok := m.locks[key]; ok && now.Before(expiresAt) {
+		return false, nil
+	}
+
+	m.locks[key] = now.Add(ttl)
+	return true, nil
+}
+
+// Unlock releases the lock of key
+func (m *MemoryLocks) Unlock(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.locks, key)
+	return nil
+}
+
+// Lock takes the lock of key, and returns the function releasing
+// it. It returns taken if the lock is already held
+func Lock(ctx context.Context, locker Locker, key string, ttl time.Duration, taken error) (func(), error) {
+	locked, err := locker.Lock(ctx, key, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, taken
+	}
+
+	return func() {
+		if err := locker.Unlock(context.Background(), key); err != nil {
+			log.Printf("(Lock) Cannot unlock %v: %v", key, err)
+		}
+	}, nil
+}
@@ -3,6 +3,7 @@ module github.com/Gravitalia/gravitalia
 go 1.20
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/bradfitz/gomemcache v0.0.0-20221031212613-62deef7fc822
 	github.com/cristalhq/jwt/v5 v5.1.0
 	github.com/joho/godotenv v1.5.1
@@ -10,8 +11,11 @@ require (
 	github.com/neo4j/neo4j-go-driver/v5 v5.12.0
 	github.com/openzipkin/zipkin-go v0.4.2
 	github.com/prometheus/client_golang v1.16.0
+	github.com/rivo/uniseg v0.4.4
+	golang.org/x/sync v0.3.0
 	google.golang.org/grpc v1.57.0
 	google.golang.org/protobuf v1.31.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
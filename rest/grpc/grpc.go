@@ -0,0 +1,62 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/certs"
+	"github.com/Gravitalia/gravitalia/config"
+)
+
+// ErrNotConnected is returned by calls made before Init
+var ErrNotConnected = errors.New("grpc: connections are not initialized")
+
+var (
+	spinoza  *pool
+	torresix *pool
+)
+
+var (
+	// timeouts of each attempt, per call
+	timeouts = map[string]time.Duration{
+		"spinoza.upload":   20 * time.Second,
+		"spinoza.delete":   2 * time.Second,
+		"torresix.predict": 5 * time.Second,
+	}
+	// retries is the maximum number of retries of idempotent calls
+	retries int
+)
+
+// Init opens the pools of connections to Spinoza and Torresix,
+// encrypted if TLS is enabled. Connections are established in
+// the background, and kept until Close
+func Init(cfg *config.Config) error {
+	for name, timeout := range cfg.Grpc.Timeouts {
+		timeouts[name] = timeout
+	}
+	retries = cfg.Grpc.Retries
+
+	spinozaTLS, err := certs.Config(cfg.Grpc.SpinozaTLS)
+	if err != nil {
+		return fmt.Errorf("spinoza: %w", err)
+	}
+	torresixTLS, err := certs.Config(cfg.Grpc.TorresixTLS)
+	if err != nil {
+		return fmt.Errorf("torresix: %w", err)
+	}
+
+	if spinoza, err = newPool(cfg.Grpc.SpinozaAddress, cfg.Grpc, spinozaTLS); err != nil {
+		return err
+	}
+	if torresix, err = newPool(cfg.Grpc.TorresixAddress, cfg.Grpc, torresixTLS); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Close closes every connection, waiting calls fail
+func Close() error {
+	return errors.Join(spinoza.close(), torresix.close())
+}
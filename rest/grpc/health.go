@@ -0,0 +1,37 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// SpinozaHealth asks Spinoza if it is serving
+func SpinozaHealth(ctx context.Context) error {
+	return health(ctx, spinoza)
+}
+
+// TorresixHealth asks Torresix if it is serving
+func TorresixHealth(ctx context.Context) error {
+	return health(ctx, torresix)
+}
+
+// health calls the standard gRPC health service of the server
+func health(ctx context.Context, p *pool) error {
+	conn, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	r, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+
+	if r.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("status is %v", r.GetStatus())
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"errors"
+	"sync/atomic"
+
+	"github.com/Gravitalia/gravitalia/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+)
+
+// pool holds long-lived connections to a service, used in turn.
+// A single connection multiplexes calls, several ones spread
+// them over more TCP connections and server threads
+type pool struct {
+	conns []*grpc.ClientConn
+	next  atomic.Uint32
+
+	breaker *breaker
+	budget  *budget
+}
+
+// newPool dials the connections to address, encrypted with tlsCfg
+// unless it is nil. Extra options are added to the ones of every
+// connection
+func newPool(address string, cfg config.Grpc, tlsCfg *tls.Config, opts ...grpc.DialOption) (*pool, error) {
+	creds := insecure.NewCredentials()
+	if tlsCfg != nil {
+		creds = credentials.NewTLS(tlsCfg)
+	}
+
+	opts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		// Detect dead connections, even while idle, instead of
+		// waiting for the timeout of the next call
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                cfg.Keepalive,
+			Timeout:             cfg.Keepalive / 3,
+			PermitWithoutStream: true,
+		}),
+	}, opts...)
+
+	p := &pool{
+		conns:   make([]*grpc.ClientConn, 0, cfg.PoolSize),
+		breaker: &breaker{threshold: cfg.BreakerFailures, cooldown: cfg.BreakerCooldown},
+		budget:  &budget{tokens: maxTokens},
+	}
+	for i := 0; i < cfg.PoolSize; i++ {
+		conn, err := grpc.Dial(address, opts...)
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		p.conns = append(p.conns, conn)
+	}
+
+	return p, nil
+}
+
+// conn returns the next connection of the pool
+func (p *pool) conn() (*grpc.ClientConn, error) {
+	if p == nil || len(p.conns) == 0 {
+		return nil, ErrNotConnected
+	}
+
+	return p.conns[(p.next.Add(1)-1)%uint32(len(p.conns))], nil
+}
+
+// close closes every connection of the pool
+func (p *pool) close() error {
+	if p == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, conn := range p.conns {
+		errs = append(errs, conn.Close())
+	}
+
+	return errors.Join(errs...)
+}
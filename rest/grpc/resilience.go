@@ -0,0 +1,196 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrCircuitOpen is returned without calling a service
+// which failed too many times in a row
+var ErrCircuitOpen = errors.New("grpc: circuit breaker is open")
+
+// Retry budget of each service, as gRPC retry throttling: failures
+// cost a token, successes give back tokenRatio, and retries are
+// allowed while more than half of the tokens are left
+const (
+	maxTokens  = 10
+	tokenRatio = 0.1
+)
+
+// Delays between two attempts, with jitter
+const (
+	baseBackoff = 100 * time.Millisecond
+	maxBackoff  = 2 * time.Second
+)
+
+// breaker stops calling a service after consecutive failures.
+// Once the cooldown is over, one call tries the service again
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	trial     bool
+}
+
+// allow checks if the service can be called
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+	if b.trial || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+
+	b.trial = true
+	return true
+}
+
+// record counts the result of a call, a failure of
+// the trial call opens the breaker again
+func (b *breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if !failed {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// release ends the trial call without a result, such as when
+// its caller gave up, so the next call tries the service again
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+}
+
+// budget limits retries once most calls fail, so they
+// don't multiply the load of a struggling service
+type budget struct {
+	mu     sync.Mutex
+	tokens float64
+}
+
+// record counts the result of a call
+func (b *budget) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if failed {
+		b.tokens = math.Max(0, b.tokens-1)
+	} else {
+		b.tokens = math.Min(maxTokens, b.tokens+tokenRatio)
+	}
+}
+
+// allow checks if a failed call can be retried
+func (b *budget) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.tokens > maxTokens/2
+}
+
+// do calls fn with the deadline of the named call, through the
+// breaker of the service. Idempotent calls are retried after
+// transient failures, while the budget allows it
+func (p *pool) do(ctx context.Context, name string, idempotent bool, fn func(ctx context.Context, conn *grpc.ClientConn) error) error {
+	for attempt := 0; ; attempt++ {
+		conn, err := p.conn()
+		if err != nil {
+			return err
+		}
+		if !p.breaker.allow() {
+			return ErrCircuitOpen
+		}
+
+		err = p.attempt(ctx, name, conn, fn)
+
+		// Calls canceled by their caller tell nothing about the service
+		if ctx.Err() != nil {
+			p.breaker.release()
+			return err
+		}
+
+		failed := isFailure(ctx, err)
+		p.breaker.record(failed)
+		p.budget.record(failed)
+
+		if !failed || !idempotent || !isTransient(ctx, err) || attempt >= retries || !p.budget.allow() {
+			return err
+		}
+
+		// Full jitter spreads the retries of concurrent calls
+		delay := baseBackoff << attempt
+		if delay > maxBackoff {
+			delay = maxBackoff
+		}
+		timer := time.NewTimer(time.Duration(rand.Int63n(int64(delay))))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt calls fn once, within the timeout of the call
+func (p *pool) attempt(ctx context.Context, name string, conn *grpc.ClientConn, fn func(ctx context.Context, conn *grpc.ClientConn) error) error {
+	if timeout := timeouts[name]; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return fn(ctx, conn)
+}
+
+// isFailure checks if the error comes from the service,
+// and not from the caller or its request
+func isFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return true
+	}
+
+	return false
+}
+
+// isTransient checks if the same call may succeed later
+func isTransient(ctx context.Context, err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted:
+		return true
+	case codes.DeadlineExceeded:
+		// Only the attempt took too long
+		return ctx.Err() == nil
+	}
+
+	return false
+}
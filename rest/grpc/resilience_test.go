@@ -0,0 +1,101 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failing returns a call failing n times with code, then succeeding
+func failing(n int, code codes.Code, calls *int) func(context.Context, *grpc.ClientConn) error {
+	return func(context.Context, *grpc.ClientConn) error {
+		*calls++
+		if *calls <= n {
+			return status.Error(code, "failure")
+		}
+		return nil
+	}
+}
+
+func TestRetry(t *testing.T) {
+	p, err := newPool("127.0.0.1:1", config.Grpc{PoolSize: 1, Keepalive: time.Minute}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+	retries = 2
+	defer func() { retries = 0 }()
+
+	for _, test := range []struct {
+		name       string
+		idempotent bool
+		failures   int
+		code       codes.Code
+		calls      int
+		fails      bool
+	}{
+		{"idempotent", true, 2, codes.Unavailable, 3, false},
+		{"too many failures", true, 3, codes.Unavailable, 3, true},
+		{"not idempotent", false, 1, codes.Unavailable, 1, true},
+		{"not transient", true, 1, codes.InvalidArgument, 1, true},
+	} {
+		var calls int
+		err := p.do(context.Background(), "test", test.idempotent, failing(test.failures, test.code, &calls))
+		if calls != test.calls || (err != nil) != test.fails {
+			t.Errorf("%v: %d calls and %v, want %d calls", test.name, calls, err, test.calls)
+		}
+	}
+
+	// Retries stop once most calls fail
+	p.budget.tokens = maxTokens / 2
+	var calls int
+	if p.do(context.Background(), "test", true, failing(1, codes.Unavailable, &calls)); calls != 1 {
+		t.Errorf("%d calls without budget, want 1", calls)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	p, err := newPool("127.0.0.1:1", config.Grpc{PoolSize: 1, Keepalive: time.Minute, BreakerFailures: 2, BreakerCooldown: 20 * time.Millisecond}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+
+	var calls int
+	for i := 0; i < 3; i++ {
+		p.do(context.Background(), "test", false, failing(2, codes.Unavailable, &calls))
+	}
+	if calls != 2 {
+		t.Fatalf("%d calls while the breaker is open, want 2", calls)
+	}
+
+	// A trial call canceled by its caller proves nothing
+	time.Sleep(30 * time.Millisecond)
+	tokens := p.budget.tokens
+	canceled, cancel := context.WithCancel(context.Background())
+	p.do(canceled, "test", false, func(context.Context, *grpc.ClientConn) error {
+		cancel()
+		return status.Error(codes.Canceled, "canceled")
+	})
+	if p.breaker.failures != 2 || p.budget.tokens != tokens {
+		t.Fatalf("canceled call counted, %d failures and %v tokens", p.breaker.failures, p.budget.tokens)
+	}
+
+	if !p.breaker.allow() || p.breaker.allow() {
+		t.Fatal("one trial call is expected after the cooldown")
+	}
+	p.breaker.record(false)
+
+	if err := p.do(context.Background(), "test", false, failing(2, codes.Unavailable, &calls)); err != nil {
+		t.Fatalf("closed breaker got %v", err)
+	}
+	if err := p.do(context.Background(), "test", false, failing(5, codes.Unavailable, &calls)); errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("breaker opened after a single failure")
+	}
+}
@@ -2,69 +2,96 @@ package grpc
 
 import (
 	"context"
-	"os"
-	"time"
+	"errors"
+	"io"
+	"sync/atomic"
 
 	"github.com/Gravitalia/gravitalia/proto"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-// UploadImage allows to transfer image as bytes
-// into Spinoza server to upload it to image provider
-func UploadImage(image []byte) (string, error) {
-	// Set up a connection to the server
-	conn, err := grpc.Dial(os.Getenv("SPINOZA_ADDRESS"), grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		return "", err
-	}
+// chunkSize is the size of the chunks of streamed images
+const chunkSize = 64 << 10
 
-	defer conn.Close()
-	c := proto.NewSpinozaClient(conn)
+// unaryUpload is set once Spinoza answers it can't stream uploads,
+// images are then sent in a single message until restart
+var unaryUpload atomic.Bool
 
-	// Contact the server and print out its response
-	// If no response in 20 seconds, cancel it
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
-	defer cancel()
+// UploadImage allows to transfer image as bytes
+// into Spinoza server to upload it to image provider.
+// The image is streamed in chunks, or sent at once to
+// servers without the streaming call. It is never retried,
+// Spinoza may have uploaded it before failing
+func UploadImage(ctx context.Context, image []byte) (string, error) {
+	var message string
+	err := spinoza.do(ctx, "spinoza.upload", false, func(ctx context.Context, conn *grpc.ClientConn) error {
+		c := proto.NewSpinozaClient(conn)
 
-	// Make request
-	r, err := c.Upload(ctx, &proto.UploadRequest{
-		Data: image,
-		//Width: 3840, // 1920 for FHD
-	})
+		if !unaryUpload.Load() {
+			var err error
+			message, err = streamImage(ctx, c, image)
+			if status.Code(err) != codes.Unimplemented {
+				return err
+			}
+			unaryUpload.Store(true)
+		}
 
-	if err != nil {
-		return "", err
-	}
+		// Make request
+		r, err := c.Upload(ctx, &proto.UploadRequest{
+			Data: image,
+			//Width: 3840, // 1920 for FHD
+		})
+		message = r.GetMessage()
+		return err
+	})
 
-	return r.GetMessage(), nil
+	return message, err
 }
 
-// DeleteImage allows to delete an image with its
-// hash. Returns the error message (may be empty)
-func DeleteImage(hash string) (string, error) {
-	// Set up a connection to the server
-	conn, err := grpc.Dial(os.Getenv("SPINOZA_ADDRESS"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+// streamImage sends the image in chunks of chunkSize bytes
+func streamImage(ctx context.Context, c proto.SpinozaClient, image []byte) (string, error) {
+	stream, err := c.UploadStream(ctx)
 	if err != nil {
 		return "", err
 	}
 
-	defer conn.Close()
-	c := proto.NewSpinozaClient(conn)
-
-	// Contact the server and print out its response
-	// If no response in 2 seconds, cancel it
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
-	defer cancel()
+	for offset := 0; offset == 0 || offset < len(image); offset += chunkSize {
+		end := offset + chunkSize
+		if end > len(image) {
+			end = len(image)
+		}
 
-	// Make request
-	r, err := c.Delete(ctx, &proto.DeleteRequest{
-		Hash: hash,
-	})
+		// EOF means the server ended the call, its
+		// status is then returned by CloseAndRecv
+		if err := stream.Send(&proto.UploadRequest{Data: image[offset:end]}); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
 
+	r, err := stream.CloseAndRecv()
 	if err != nil {
 		return "", err
 	}
 
 	return r.GetMessage(), nil
 }
+
+// DeleteImage allows to delete an image with its
+// hash. Returns the error message (may be empty)
+func DeleteImage(ctx context.Context, hash string) (string, error) {
+	var message string
+	err := spinoza.do(ctx, "spinoza.delete", true, func(ctx context.Context, conn *grpc.ClientConn) error {
+		// Make request
+		r, err := proto.NewSpinozaClient(conn).Delete(ctx, &proto.DeleteRequest{
+			Hash: hash,
+		})
+		message = r.GetMessage()
+		return err
+	})
+
+	return message, err
+}
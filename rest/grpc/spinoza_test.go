@@ -0,0 +1,110 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/Gravitalia/gravitalia/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// streamingSpinoza answers the number of chunks received
+type streamingSpinoza struct {
+	proto.UnimplementedSpinozaServer
+	image []byte
+}
+
+func (s *streamingSpinoza) UploadStream(stream proto.Spinoza_UploadStreamServer) error {
+	var chunks int
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&proto.BasicReponse{Message: strconv.Itoa(chunks)})
+		}
+		if err != nil {
+			return err
+		}
+		chunks++
+		s.image = append(s.image, chunk.GetData()...)
+	}
+}
+
+// unarySpinoza only knows the unary call
+type unarySpinoza struct {
+	proto.UnimplementedSpinozaServer
+}
+
+func (unarySpinoza) Upload(_ context.Context, req *proto.UploadRequest) (*proto.BasicReponse, error) {
+	return &proto.BasicReponse{Message: "unary " + strconv.Itoa(len(req.GetData()))}, nil
+}
+
+// serve starts the server, and points Spinoza to it
+func serve(t *testing.T, server proto.SpinozaServer) {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	proto.RegisterSpinozaServer(s, server)
+	go s.Serve(listener)
+
+	dialer := grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	})
+
+	var err error
+	spinoza, err = newPool("bufnet", config.Grpc{PoolSize: 2, Keepalive: time.Minute}, nil, dialer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unaryUpload.Store(false)
+
+	t.Cleanup(func() {
+		spinoza.close()
+		s.Stop()
+		spinoza = nil
+	})
+}
+
+func TestUploadImage(t *testing.T) {
+	server := &streamingSpinoza{}
+	serve(t, server)
+
+	image := bytes.Repeat([]byte{1}, 2*chunkSize+1)
+	if message, err := UploadImage(context.Background(), image); err != nil || message != "3" {
+		t.Fatalf("got %q and %v, want 3 chunks", message, err)
+	}
+	if !bytes.Equal(server.image, image) {
+		t.Fatalf("received %d bytes, want %d", len(server.image), len(image))
+	}
+	if unaryUpload.Load() {
+		t.Fatal("streaming server is called with unary uploads")
+	}
+}
+
+func TestUploadImageUnary(t *testing.T) {
+	serve(t, unarySpinoza{})
+
+	// The fallback is remembered
+	for i := 0; i < 2; i++ {
+		if message, err := UploadImage(context.Background(), []byte("image")); err != nil || message != "unary 5" {
+			t.Fatalf("got %q and %v, want unary upload", message, err)
+		}
+		if !unaryUpload.Load() {
+			t.Fatal("unary server is still called with streams")
+		}
+	}
+}
+
+func TestNotConnected(t *testing.T) {
+	if _, err := UploadImage(context.Background(), []byte("image")); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("got %v, want %v", err, ErrNotConnected)
+	}
+}
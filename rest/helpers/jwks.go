@@ -0,0 +1,419 @@
+package helpers
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cristalhq/jwt/v5"
+)
+
+// minRefreshInterval limits downloads of the JWK Set
+// caused by tokens signed with an unknown key
+const minRefreshInterval = time.Minute
+
+// maxJWKSSize is the maximum size of a JWK Set
+const maxJWKSSize = 1 << 20
+
+// ErrUnknownKey means no key can verify the token
+var ErrUnknownKey = errors.New("no key matches the token")
+
+// publicKey verifies the tokens of an algorithm
+type publicKey struct {
+	// id is the "kid" of the key, empty for PEM keys
+	id       string
+	alg      jwt.Algorithm
+	verifier jwt.Verifier
+}
+
+// KeySet holds the keys verifying user tokens. Keys come from PEM
+// keys, and from a JWK Set downloaded again periodically, so the
+// identity provider can rotate its keys
+type KeySet struct {
+	// static keys are read once, from PEM
+	static []publicKey
+	// source is the URL or the path of the JWK Set
+	source string
+	client *http.Client
+
+	mu      sync.RWMutex
+	remote  []publicKey
+	fetched time.Time
+
+	// fetching prevents concurrent downloads
+	fetching sync.Mutex
+}
+
+// NewKeySet creates a key set from PEM encoded keys and the URL
+// or the path of a JWK Set, loaded before returning
+func NewKeySet(ctx context.Context, pemKeys string, source string) (*KeySet, error) {
+	ks := &KeySet{
+		source: strings.TrimPrefix(source, "file://"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	rest := []byte(pemKeys)
+	for len(strings.TrimSpace(string(rest))) != 0 {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return nil, errors.New("invalid PEM key")
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		k, err := newPublicKey("", "", key)
+		if err != nil {
+			return nil, err
+		}
+		ks.static = append(ks.static, k)
+	}
+
+	if ks.source != "" {
+		if err := ks.Refresh(ctx); err != nil {
+			// PEM keys are enough to start, the JWK Set will be downloaded later
+			if len(ks.static) == 0 {
+				return nil, err
+			}
+			log.Printf("Cannot load JWKS, using PEM keys only: %v", err)
+		}
+	}
+
+	if len(ks.static) == 0 && ks.source == "" {
+		return nil, errors.New("no key to verify tokens")
+	}
+
+	return ks, nil
+}
+
+// Refresh downloads the JWK Set. Previous keys are kept on error
+func (ks *KeySet) Refresh(ctx context.Context) error {
+	ks.fetching.Lock()
+	defer ks.fetching.Unlock()
+
+	return ks.refresh(ctx)
+}
+
+func (ks *KeySet) refresh(ctx context.Context) error {
+	content, err := ks.download(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot read JWKS: %w", err)
+	}
+
+	keys, err := parseJWKS(content)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	ks.remote = keys
+	ks.fetched = time.Now()
+	ks.mu.Unlock()
+
+	return nil
+}
+
+// RefreshEvery downloads the JWK Set at each interval, until ctx is done
+func (ks *KeySet) RefreshEvery(ctx context.Context, interval time.Duration) {
+	if ks.source == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ks.Refresh(ctx); err != nil {
+				log.Printf("Cannot refresh JWKS, keeping previous keys: %v", err)
+			}
+		}
+	}
+}
+
+// download reads the JWK Set from its URL or its file
+func (ks *KeySet) download(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(ks.source, "http://") && !strings.HasPrefix(ks.source, "https://") {
+		return os.ReadFile(ks.source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := ks.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v", res.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(res.Body, maxJWKSSize))
+}
+
+// Verify checks the signature of the token with the key
+// selected by its "kid" header. Unknown key IDs download
+// the JWK Set again, at most once per minRefreshInterval
+func (ks *KeySet) Verify(ctx context.Context, token *jwt.Token) error {
+	header := token.Header()
+
+	keys, known := ks.find(header.KeyID, header.Algorithm)
+	if !known && ks.source != "" {
+		ks.refreshStale(ctx)
+		keys, _ = ks.find(header.KeyID, header.Algorithm)
+	}
+
+	if len(keys) == 0 {
+		return ErrUnknownKey
+	}
+
+	var err error
+	for _, key := range keys {
+		if err = key.verifier.Verify(token); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// find returns the keys able to verify tokens of the key ID and the
+// algorithm, PEM keys included. known is false if a key ID is
+// given but the JWK Set has no such key
+func (ks *KeySet) find(kid string, alg jwt.Algorithm) ([]publicKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	keys := make([]publicKey, 0, 1)
+	known := kid == ""
+
+	for _, key := range ks.remote {
+		if kid != "" && key.id != kid {
+			continue
+		}
+		known = true
+
+		if key.alg == alg {
+			keys = append(keys, key)
+		}
+	}
+
+	for _, key := range ks.static {
+		if key.alg == alg {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, known
+}
+
+// refreshStale downloads the JWK Set if it was not
+// downloaded during the last minRefreshInterval
+func (ks *KeySet) refreshStale(ctx context.Context) {
+	ks.fetching.Lock()
+	defer ks.fetching.Unlock()
+
+	ks.mu.RLock()
+	stale := time.Since(ks.fetched) > minRefreshInterval
+	ks.mu.RUnlock()
+
+	if !stale {
+		return
+	}
+
+	if err := ks.refresh(ctx); err != nil {
+		log.Printf("Cannot refresh JWKS for an unknown key: %v", err)
+		// Don't download again for every token
+		ks.mu.Lock()
+		ks.fetched = time.Now()
+		ks.mu.Unlock()
+	}
+}
+
+// jwk is a key of a JWK Set (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS reads the signature keys of a JWK Set.
+// Unsupported keys are ignored
+func parseJWKS(content []byte) ([]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make([]publicKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		raw, err := k.publicKey()
+		if err != nil {
+			log.Printf("Ignoring JWKS key %q: %v", k.Kid, err)
+			continue
+		}
+
+		key, err := newPublicKey(k.Kid, jwt.Algorithm(k.Alg), raw)
+		if err != nil {
+			log.Printf("Ignoring JWKS key %q: %v", k.Kid, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no supported key in JWKS")
+	}
+
+	return keys, nil
+}
+
+// publicKey decodes the key according to its type
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 coordinates")
+		}
+
+		// Reject points which are not on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// decodeBigInt decodes a base64url encoded integer
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid integer")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// newPublicKey creates the verifier of the key. RSA keys use RS256,
+// ECDSA keys ES256 and Ed25519 keys EdDSA; alg, if set, must match
+func newPublicKey(id string, alg jwt.Algorithm, key any) (publicKey, error) {
+	var expected jwt.Algorithm
+	var verifier jwt.Verifier
+	var err error
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return publicKey{}, errors.New("RSA keys must have at least 2048 bits")
+		}
+		expected = jwt.RS256
+		verifier, err = jwt.NewVerifierRS(jwt.RS256, key)
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return publicKey{}, errors.New("ECDSA keys must use P-256")
+		}
+		expected = jwt.ES256
+		verifier, err = jwt.NewVerifierES(jwt.ES256, key)
+	case ed25519.PublicKey:
+		expected = jwt.EdDSA
+		verifier, err = jwt.NewVerifierEdDSA(key)
+	default:
+		return publicKey{}, fmt.Errorf("unsupported key %T", key)
+	}
+
+	if err != nil {
+		return publicKey{}, err
+	}
+	if alg != "" && alg != expected {
+		return publicKey{}, fmt.Errorf("unsupported algorithm %v", alg)
+	}
+
+	return publicKey{id: id, alg: expected, verifier: verifier}, nil
+}
@@ -1,54 +1,103 @@
 package helpers
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/json"
-	"encoding/pem"
+	"context"
 	"errors"
-	"os"
+	"fmt"
 	"time"
 
+	"github.com/Gravitalia/gravitalia/config"
 	"github.com/cristalhq/jwt/v5"
 )
 
-// CheckToken allows to check the authenticity of a token
-// and return the user vanity if it is a real token
-func CheckToken(token string) (string, error) {
-	block, _ := pem.Decode([]byte(os.Getenv("RSA_PUBLIC_KEY")))
-	key, _ := x509.ParsePKIXPublicKey(block.Bytes)
+var (
+	keys *KeySet
+	// rules are the claims expected in every token
+	rules config.JWT
+)
 
-	verifier, err := jwt.NewVerifierRS(jwt.RS256, key.(*rsa.PublicKey))
+// InitJWT loads the keys verifying user tokens. The JWK Set,
+// if any, is downloaded again periodically until ctx is done
+func InitJWT(ctx context.Context, cfg config.JWT) error {
+	ks, err := NewKeySet(ctx, cfg.PublicKeys, cfg.JWKS)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	tokenBytes := []byte(token)
-	newToken, err := jwt.Parse(tokenBytes, verifier)
-	if err != nil {
-		return "", err
+	keys = ks
+	rules = cfg
+	go ks.RefreshEvery(ctx, cfg.JWKSRefresh)
+
+	return nil
+}
+
+// Claims are the claims of user tokens
+type Claims struct {
+	jwt.RegisteredClaims
+	// Scope lists what the token allows, such as "identity"
+	Scope []string `json:"scope"`
+}
+
+// hasScope checks if the token grants the scope
+func (c *Claims) hasScope(scope string) bool {
+	for _, s := range c.Scope {
+		if s == scope {
+			return true
+		}
 	}
 
-	err = verifier.Verify(newToken)
-	if err != nil {
-		return "", err
+	return false
+}
+
+// ParseToken checks the authenticity of a token, its validity
+// period, issuer, audience and scopes, and returns its claims
+func ParseToken(ctx context.Context, token string) (*Claims, error) {
+	if keys == nil {
+		return nil, errors.New("no key to verify tokens")
 	}
 
-	// get Registered claims
-	var newClaims jwt.RegisteredClaims
-	err = json.Unmarshal(newToken.Claims(), &newClaims)
+	parsed, err := jwt.ParseNoVerify([]byte(token))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	err = jwt.ParseClaims(tokenBytes, verifier, &newClaims)
-	if err != nil {
-		return "", err
+	if err := keys.Verify(ctx, parsed); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := parsed.DecodeClaims(&claims); err != nil {
+		return nil, err
+	}
+
+	if !claims.IsValidAt(time.Now()) {
+		return nil, errors.New("invalid time")
+	}
+
+	if rules.Issuer != "" && !claims.IsIssuer(rules.Issuer) {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+
+	if rules.Audience != "" && !claims.IsForAudience(rules.Audience) {
+		return nil, errors.New("token is not intended for this service")
 	}
 
-	if !newClaims.IsValidAt(time.Now()) {
-		return "", errors.New("invalid time")
+	for _, scope := range rules.Scopes {
+		if !claims.hasScope(scope) {
+			return nil, fmt.Errorf("missing scope %q", scope)
+		}
+	}
+
+	return &claims, nil
+}
+
+// CheckToken allows to check the authenticity of a token
+// and return the user vanity if it is a real token
+func CheckToken(ctx context.Context, token string) (string, error) {
+	claims, err := ParseToken(ctx, token)
+	if err != nil {
+		return "", err
 	}
 
-	return newClaims.Subject, nil
+	return claims.Subject, nil
 }
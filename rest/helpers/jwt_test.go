@@ -0,0 +1,142 @@
+package helpers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/cristalhq/jwt/v5"
+)
+
+// identityProvider serves a JWK Set which can be changed
+type identityProvider struct {
+	keys      atomic.Value
+	downloads atomic.Int32
+}
+
+func (idp *identityProvider) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	idp.downloads.Add(1)
+	json.NewEncoder(w).Encode(map[string]any{"keys": idp.keys.Load()})
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sign creates a token for vanity with the key ID
+func sign(t *testing.T, signer jwt.Signer, kid string, claims Claims) string {
+	t.Helper()
+
+	if claims.Subject == "" {
+		claims.Subject = "vanity"
+	}
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	token, err := jwt.NewBuilder(signer, jwt.WithKeyID(kid)).Build(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token.String()
+}
+
+func TestParseTokenJWKS(t *testing.T) {
+	ctx := context.Background()
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecSigner, _ := jwt.NewSignerES(jwt.ES256, ecKey)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edSigner, _ := jwt.NewSignerEdDSA(edKey)
+
+	idp := &identityProvider{}
+	idp.keys.Store([]map[string]string{
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(ecKey.X.FillBytes(make([]byte, 32))), "y": encode(ecKey.Y.FillBytes(make([]byte, 32)))},
+	})
+	server := httptest.NewServer(idp)
+	defer server.Close()
+
+	err := InitJWT(ctx, config.JWT{
+		JWKS:        server.URL,
+		JWKSRefresh: time.Hour,
+		Issuer:      "https://oauth.gravitalia.com",
+		Audience:    "gravitalia",
+		Scopes:      []string{"identity"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Issuer: "https://oauth.gravitalia.com", Audience: []string{"gravitalia"}},
+		Scope:            []string{"identity"},
+	}
+
+	if vanity, err := CheckToken(ctx, sign(t, ecSigner, "ec", valid)); err != nil || vanity != "vanity" {
+		t.Fatalf("ES256 token rejected: %q %v", vanity, err)
+	}
+
+	for name, claims := range map[string]Claims{
+		"issuer":   {RegisteredClaims: jwt.RegisteredClaims{Issuer: "https://evil.com", Audience: []string{"gravitalia"}}, Scope: []string{"identity"}},
+		"audience": {RegisteredClaims: jwt.RegisteredClaims{Issuer: "https://oauth.gravitalia.com", Audience: []string{"other"}}, Scope: []string{"identity"}},
+		"scope":    {RegisteredClaims: jwt.RegisteredClaims{Issuer: "https://oauth.gravitalia.com", Audience: []string{"gravitalia"}}},
+	} {
+		if _, err := ParseToken(ctx, sign(t, ecSigner, "ec", claims)); err == nil {
+			t.Errorf("token with invalid %v accepted", name)
+		}
+	}
+
+	// The identity provider rotates its key
+	idp.keys.Store([]map[string]string{
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": encode(edPublic)},
+	})
+	rotated := sign(t, edSigner, "ed", valid)
+
+	// Unknown keys don't download the JWK Set more than once per minute
+	if _, err := ParseToken(ctx, rotated); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected unknown key, got %v", err)
+	}
+	if downloads := idp.downloads.Load(); downloads != 1 {
+		t.Fatalf("JWKS downloaded %d times, want 1", downloads)
+	}
+
+	keys.mu.Lock()
+	keys.fetched = time.Now().Add(-2 * minRefreshInterval)
+	keys.mu.Unlock()
+	if _, err := ParseToken(ctx, rotated); err != nil {
+		t.Fatalf("EdDSA token rejected after rotation: %v", err)
+	}
+	if _, err := ParseToken(ctx, sign(t, ecSigner, "ec", valid)); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("token signed by a removed key: expected unknown key, got %v", err)
+	}
+}
+
+func TestParseJWKSIgnoresUnsupportedKeys(t *testing.T) {
+	keys, err := parseJWKS([]byte(`{"keys":[
+		{"kty":"oct","kid":"hmac","k":"c2VjcmV0"},
+		{"kty":"EC","kid":"p384","crv":"P-384","x":"AA","y":"AA"},
+		{"kty":"OKP","kid":"enc","use":"enc","crv":"Ed25519","x":"` + encode(make([]byte, 32)) + `"},
+		{"kty":"OKP","kid":"ed","crv":"Ed25519","x":"` + encode(make([]byte, 32)) + `"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 || keys[0].id != "ed" || keys[0].alg != jwt.EdDSA {
+		t.Fatalf("expected only the Ed25519 signature key, got %+v", keys)
+	}
+
+	if _, err := parseJWKS([]byte(`{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`)); err == nil {
+		t.Fatal("expected an error for a JWK Set without supported keys")
+	}
+}
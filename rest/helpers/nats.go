@@ -1,30 +1,76 @@
 package helpers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
-	"os"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/certs"
+	"github.com/Gravitalia/gravitalia/config"
 
 	"github.com/nats-io/nats.go"
 )
 
 var Nats *nats.Conn
 
-// InitNATS starts a new NATS instance
-func InitNATS() {
-	connection, err := nats.Connect(os.Getenv("NATS_URL"))
+// InitNATS starts a new NATS instance, encrypted if TLS is enabled
+func InitNATS(cfg *config.Config) {
+	var opts []nats.Option
+	tlsCfg, err := certs.Config(cfg.Nats.TLS)
+	if err != nil {
+		// Plaintext is never used instead of invalid certificates
+		log.Fatalf("Cannot load NATS certificates: %v", err)
+	}
+	if tlsCfg != nil {
+		opts = append(opts, nats.Secure(tlsCfg))
+	}
+
+	connection, err := nats.Connect(cfg.Nats.URL, opts...)
 
 	if err != nil {
-		log.Printf("Cannot connect to %v: %v", os.Getenv("NATS_URL"), err)
+		log.Printf("Cannot connect to %v: %v", cfg.Nats.URL, err)
 	}
 
 	Nats = connection
 }
 
-// Publish allows publishing message on NATS
-func Publish(subject string, message []byte) {
+// Publish allows publishing message on NATS.
+// Nothing is sent if the context is already done
+func Publish(ctx context.Context, subject string, message []byte) {
+	if err := ctx.Err(); err != nil {
+		log.Printf("(Publish) Message to %v not sent: %v", subject, err)
+		return
+	}
+
 	err := Nats.Publish(subject, message)
 
 	if err != nil {
 		log.Printf("(Publish) Failed to send message to %v, got error: %v", subject, err)
 	}
 }
+
+// NatsStatus returns an error if the connection is not usable
+func NatsStatus() error {
+	if Nats == nil {
+		return errors.New("nats is not connected")
+	}
+
+	if !Nats.IsConnected() {
+		return fmt.Errorf("nats connection is %v", Nats.Status())
+	}
+
+	return nil
+}
+
+// CloseNATS waits for the server to receive every pending
+// message, then closes the connection
+func CloseNATS(timeout time.Duration) error {
+	if Nats == nil {
+		return nil
+	}
+	defer Nats.Close()
+
+	return Nats.FlushTimeout(timeout)
+}
@@ -1,46 +1,136 @@
-package helpers
-
-import (
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/collectors"
-	"github.com/prometheus/client_golang/prometheus/promauto"
-)
-
-var (
-	requestsTotal = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "http_requests_total",
-		Help: "Tracks the number of HTTP requests.",
-	})
-
-	requestDuration = promauto.NewHistogram(prometheus.HistogramOpts{
-		Name:    "http_request_duration_seconds",
-		Help:    "Tracks the latencies for HTTP requests.",
-		Buckets: prometheus.DefBuckets,
-	})
-)
-
-// GetRegistery is used to get prometheus
-// saved data
-func GetRegistery() *prometheus.Registry {
-	registry := prometheus.NewRegistry()
-	registry.MustRegister(
-		collectors.NewGoCollector(),
-		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
-		requestsTotal,
-		requestDuration,
-	)
-
-	return registry
-}
-
-// IncrementRequests allows to increment
-// the number of total requests
-func IncrementRequests() {
-	requestsTotal.Inc()
-}
-
-// ObserveRequestDuration allows to create a
-// new record of time duration
-func ObserveRequestDuration(time float64) {
-	requestDuration.Observe(time)
-}
+package helpers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Tracks the number of HTTP requests.",
+	}, []string{"route", "method", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Tracks the latencies for HTTP requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+
+	concurrencyLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_concurrency_limit",
+		Help: "Current adaptive limit of concurrent requests per group of routes.",
+	}, []string{"group"})
+
+	concurrencyInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_concurrency_in_flight",
+		Help: "Number of running requests per group of routes.",
+	}, []string{"group"})
+
+	shedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_shed_requests_total",
+		Help: "Tracks the number of requests rejected above the concurrency limit.",
+	}, []string{"group"})
+)
+
+// GetRegistery is used to get prometheus
+// saved data
+func GetRegistery() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestsTotal,
+		requestDuration,
+		concurrencyLimit,
+		concurrencyInFlight,
+		shedRequests,
+	)
+
+	return registry
+}
+
+// IncrementRequests allows to increment
+// the number of total requests
+func IncrementRequests(route string, method string, code int) {
+	requestsTotal.WithLabelValues(route, method, strconv.Itoa(code)).Inc()
+}
+
+// ObserveRequestDuration allows to create a
+// new record of time duration
+func ObserveRequestDuration(route string, time float64) {
+	requestDuration.WithLabelValues(route).Observe(time)
+}
+
+// SetConcurrency records the limit and the
+// running requests of a group of routes
+func SetConcurrency(group string, limit float64, inFlight int) {
+	concurrencyLimit.WithLabelValues(group).Set(limit)
+	concurrencyInFlight.WithLabelValues(group).Set(float64(inFlight))
+}
+
+// IncrementShedRequests allows to increment the number
+// of requests rejected above the concurrency limit
+func IncrementShedRequests(group string) {
+	shedRequests.WithLabelValues(group).Inc()
+}
+
+// statusWriter saves the status code sent by the handler
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the real writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// RouteLabel returns the name of the route handling the request.
+// Requests matching no route share the same label, so scanners
+// can't create a metric per path
+func RouteLabel(req *http.Request) string {
+	if route := mux.CurrentRoute(req.Context()); route != nil {
+		return route.Name()
+	}
+
+	return "unmatched"
+}
+
+// Metrics counts requests and their duration per route
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, req)
+
+		if recorder.code == 0 {
+			recorder.code = http.StatusOK
+		}
+
+		route := RouteLabel(req)
+		IncrementRequests(route, req.Method, recorder.code)
+		ObserveRequestDuration(route, time.Since(start).Seconds())
+	})
+}
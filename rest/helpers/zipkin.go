@@ -5,29 +5,32 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/Gravitalia/gravitalia/mux"
 	"github.com/openzipkin/zipkin-go"
 	zipkinhttp "github.com/openzipkin/zipkin-go/middleware/http"
 
 	//httpreporter "github.com/openzipkin/zipkin-go/reporter/http"
+	"github.com/openzipkin/zipkin-go/reporter"
 	logreporter "github.com/openzipkin/zipkin-go/reporter/log"
 )
 
-func InitTracer() (*zipkinhttp.Client, func(http.Handler) http.Handler) {
+// spanReporter sends spans until CloseTracer is called
+var spanReporter reporter.Reporter
+
+func InitTracer(cfg *config.Config) (*zipkinhttp.Client, func(http.Handler) http.Handler) {
 	// set up a span reporter
 	//reporter := httpreporter.NewReporter("http://" + os.Getenv("ZIPKIN_ADDRESS") + "/api/v2/spans")
-	reporter := logreporter.NewReporter(log.New(os.Stderr, "", log.LstdFlags))
-	defer func() {
-		_ = reporter.Close()
-	}()
+	spanReporter = logreporter.NewReporter(log.New(os.Stderr, "", log.LstdFlags))
 
 	// create our local service endpoint
-	endpoint, err := zipkin.NewEndpoint("gravitaliaRest", "localhost:"+os.Getenv("PORT"))
+	endpoint, err := zipkin.NewEndpoint("gravitaliaRest", "localhost:"+cfg.Port)
 	if err != nil {
 		log.Printf("unable to create local endpoint: %+v\n", err)
 	}
 
 	// initialize our tracer
-	tracer, err := zipkin.NewTracer(reporter, zipkin.WithLocalEndpoint(endpoint))
+	tracer, err := zipkin.NewTracer(spanReporter, zipkin.WithLocalEndpoint(endpoint))
 	if err != nil {
 		log.Printf("unable to create tracer: %+v\n", err)
 	}
@@ -45,3 +48,27 @@ func InitTracer() (*zipkinhttp.Client, func(http.Handler) http.Handler) {
 
 	return client, serverMiddleware
 }
+
+// CloseTracer sends the remaining spans and stops the reporter
+func CloseTracer() error {
+	if spanReporter == nil {
+		return nil
+	}
+
+	return spanReporter.Close()
+}
+
+// NameSpan names the span of the request after its route,
+// so traces are grouped by endpoint instead of method
+func NameSpan(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if span := zipkin.SpanFromContext(req.Context()); span != nil {
+			if route := mux.CurrentRoute(req.Context()); route != nil {
+				span.SetName(route.Name())
+				span.Tag("http.route", route.Pattern())
+			}
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,385 @@
+// Package job runs tasks in the background, such as publishing a
+// post. Each job is a payload file, removed once the job is finished,
+// and a JSON info file polled by its owner. Jobs are kept in a
+// directory so they survive restarts, and any replica sharing it
+// runs them
+package job
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/Gravitalia/gravitalia/filestore"
+	"github.com/Gravitalia/gravitalia/problem"
+)
+
+var (
+	ErrNotFound = errors.New("job not found")
+	ErrLocked   = errors.New("job is being run by another worker")
+)
+
+// Status of a job
+const (
+	Pending = "pending"
+	Running = "running"
+	Done    = "done"
+	Failed  = "failed"
+)
+
+// lockTTL is the longest time a job can run. Jobs of instances
+// stopped while running them are run again after it
+const lockTTL = 5 * time.Minute
+
+// runTimeout leaves time to save the job before its lock expires
+const runTimeout = lockTTL - 30*time.Second
+
+// scanInterval is how often the directory is read, finding jobs
+// created by other replicas, retried, or left by stopped instances
+var scanInterval = 5 * time.Second
+
+// Job describes a task, and its result once finished
+type Job struct {
+	ID     string `json:"id"`
+	Owner  string `json:"owner"`
+	Status string `json:"status"`
+	// Result of a done job, such as the ID of the post
+	Result string `json:"result,omitempty"`
+	// Error of a failed job, or of the last attempt of a pending one
+	Error *problem.Problem `json:"error,omitempty"`
+	// Checkpoint is saved by the handler once a step must not be run
+	// again, such as the ID of a created post, so resumed jobs skip it
+	Checkpoint string `json:"checkpoint,omitempty"`
+	// Attempts counts the runs, including interrupted ones
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// RunAt delays the next attempt of a pending job
+	RunAt time.Time `json:"run_at"`
+}
+
+// Finished checks if the job is done or failed
+func (job *Job) Finished() bool {
+	return job.Status == Done || job.Status == Failed
+}
+
+// Handler runs a job from its payload, and returns its result.
+// Errors fail the job, unless they are returned by Retry
+type Handler func(ctx context.Context, job *Job, payload []byte) (string, error)
+
+// retryError asks to run the job again after a delay
+type retryError struct {
+	after time.Duration
+	err   error
+}
+
+func (r *retryError) Error() string {
+	return r.err.Error()
+}
+
+func (r *retryError) Unwrap() error {
+	return r.err
+}
+
+// Retry keeps the job pending, and runs it again after the delay
+func Retry(after time.Duration, err error) error {
+	return &retryError{after: after, err: err}
+}
+
+// Store keeps jobs in a directory, and runs them
+type Store struct {
+	dir       filestore.Dir
+	retention time.Duration
+	// locker prevents two workers, maybe of different
+	// instances, from running the same job at once
+	locker filestore.Locker
+
+	// queue holds jobs to run now, queued once
+	queue  chan string
+	mu     sync.Mutex
+	queued map[string]bool
+
+	running sync.WaitGroup
+}
+
+// NewStore creates the directory of jobs if needed. Finished jobs
+// are removed after the configured retention
+func NewStore(cfg config.Jobs, locker filestore.Locker) (*Store, error) {
+	// Jobs in a temporary directory would be lost on restart
+	if cfg.Dir == "" {
+		return nil, errors.New("directory of jobs is not set")
+	}
+
+	dir, err := filestore.Open(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		dir:       dir,
+		retention: cfg.Retention,
+		locker:    locker,
+		queue:     make(chan string, 256),
+		queued:    make(map[string]bool),
+	}, nil
+}
+
+// Create saves a pending job of the owner with its payload,
+// which is run as soon as a worker is free
+func (s *Store) Create(owner string, payload []byte) (*Job, error) {
+	id, err := filestore.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:        id,
+		Owner:     owner,
+		Status:    Pending,
+		CreatedAt: now,
+		UpdatedAt: now,
+		RunAt:     now,
+	}
+
+	// The payload is written first, so saved jobs always have one
+	if err := os.WriteFile(s.dir.Path(job.ID, ".bin"), payload, 0600); err != nil {
+		return nil, err
+	}
+	if err := s.save(job); err != nil {
+		return nil, err
+	}
+
+	s.enqueue(job.ID)
+	return job, nil
+}
+
+// Get returns the job of the owner. Jobs of other users are not found
+func (s *Store) Get(id string, owner string) (*Job, error) {
+	if !filestore.ValidID(id) {
+		return nil, ErrNotFound
+	}
+
+	job, err := s.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Owner != owner {
+		return nil, ErrNotFound
+	}
+
+	return job, nil
+}
+
+// Checkpoint saves the progress of the running job. If the instance
+// stops before the job is finished, the next attempt gets it
+func (s *Store) Checkpoint(job *Job, checkpoint string) error {
+	job.Checkpoint = checkpoint
+	job.UpdatedAt = time.Now()
+	return s.save(job)
+}
+
+// Run starts the workers running jobs with the handler, and finds
+// jobs in the directory at each scan, until ctx is done. Running
+// jobs are not canceled, see Wait
+func (s *Store) Run(ctx context.Context, workers int, handler Handler) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-s.queue:
+					s.mu.Lock()
+					delete(s.queued, id)
+					s.mu.Unlock()
+
+					s.run(ctx, id, handler)
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(scanInterval)
+	defer ticker.Stop()
+
+	for {
+		s.scan(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Wait blocks until running jobs are finished, or ctx is done.
+// Jobs still running then are run again after a restart
+func (s *Store) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// scan queues the jobs to run, and removes finished
+// jobs once their retention is over
+func (s *Store) scan(ctx context.Context) {
+	err := s.dir.Scan(s.retention, func(id string) {
+		job, err := s.load(id)
+		if err != nil {
+			return
+		}
+
+		if job.Finished() {
+			if time.Since(job.UpdatedAt) > s.retention {
+				if err := s.dir.Remove(id); err != nil {
+					log.Printf("(Job) Cannot remove job %v: %v", id, err)
+				}
+			}
+			return
+		}
+
+		// Running jobs are locked, unless their instance stopped
+		if time.Now().After(job.RunAt) && ctx.Err() == nil {
+			s.enqueue(id)
+		}
+	})
+	if err != nil {
+		log.Printf("(Job) Cannot read jobs: %v", err)
+	}
+}
+
+// enqueue gives the job to the next free worker. Jobs are
+// left to the next scan when the queue is full
+func (s *Store) enqueue(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.queued[id] {
+		return
+	}
+
+	select {
+	case s.queue <- id:
+		s.queued[id] = true
+	default:
+	}
+}
+
+// run runs the job if it is still pending, or if it was left running
+// by a stopped instance, then saves its result
+func (s *Store) run(ctx context.Context, id string, handler Handler) {
+	s.running.Add(1)
+	defer s.running.Done()
+
+	unlock, err := s.lock(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrLocked) {
+			log.Printf("(Job) Cannot lock job %v: %v", id, err)
+		}
+		return
+	}
+	defer unlock()
+
+	job, err := s.load(id)
+	if err != nil || job.Finished() || time.Now().Before(job.RunAt) {
+		return
+	}
+
+	payload, err := os.ReadFile(s.dir.Path(id, ".bin"))
+	if err != nil {
+		s.finish(job, "", err)
+		return
+	}
+
+	job.Status = Running
+	job.Attempts++
+	job.UpdatedAt = time.Now()
+	if err := s.save(job); err != nil {
+		log.Printf("(Job) Cannot save job %v: %v", id, err)
+		return
+	}
+
+	// Stopping the instance doesn't cancel the job, see Wait
+	runCtx, cancel := context.WithTimeout(context.Background(), runTimeout)
+	defer cancel()
+
+	result, err := handler(runCtx, job, payload)
+	s.finish(job, result, err)
+}
+
+// finish saves the result of the job, or schedules its next attempt
+func (s *Store) finish(job *Job, result string, err error) {
+	job.UpdatedAt = time.Now()
+
+	var retry *retryError
+	switch {
+	case err == nil:
+		job.Status = Done
+		job.Result = result
+		job.Error = nil
+	case errors.As(err, &retry):
+		job.Status = Pending
+		job.RunAt = job.UpdatedAt.Add(retry.after)
+		job.Error = problem.From(retry.err)
+	default:
+		job.Status = Failed
+		job.Error = problem.From(err)
+		log.Printf("(Job) Job %v of %v failed: %v", job.ID, job.Owner, err)
+	}
+
+	if err := s.save(job); err != nil {
+		log.Printf("(Job) Cannot save job %v: %v", job.ID, err)
+		return
+	}
+
+	// Retries don't wait for the next scan
+	if retry != nil {
+		time.AfterFunc(retry.after, func() { s.enqueue(job.ID) })
+	}
+
+	// The payload is no longer needed
+	if job.Finished() {
+		if err := os.Remove(s.dir.Path(job.ID, ".bin")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("(Job) Cannot remove payload of %v: %v", job.ID, err)
+		}
+	}
+}
+
+// lock takes the lock of the job, and returns the function releasing it
+func (s *Store) lock(ctx context.Context, id string) (func(), error) {
+	return filestore.Lock(ctx, s.locker, "job-"+id, lockTTL, ErrLocked)
+}
+
+// load reads the info of the job
+func (s *Store) load(id string) (*Job, error) {
+	job := &Job{}
+	err := s.dir.Load(id, job)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// save writes the info of the job
+func (s *Store) save(job *Job) error {
+	return s.dir.Save(job.ID, job)
+}
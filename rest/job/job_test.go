@@ -0,0 +1,151 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/Gravitalia/gravitalia/filestore"
+	"github.com/Gravitalia/gravitalia/problem"
+)
+
+func init() {
+	scanInterval = 5 * time.Millisecond
+}
+
+func newStore(t *testing.T, dir string, retention time.Duration) *Store {
+	t.Helper()
+
+	s, err := NewStore(config.Jobs{Dir: dir, Retention: retention}, filestore.NewMemoryLocks())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// run runs the jobs of s with the handler until the test ends
+func run(t *testing.T, s *Store, handler Handler) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+		s.Wait(context.Background())
+	})
+
+	go func() {
+		s.Run(ctx, 2, handler)
+		close(stopped)
+	}()
+}
+
+// finished waits for the job to be finished
+func finished(t *testing.T, s *Store, id string) *Job {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if job, err := s.Get(id, "author"); err == nil && job.Finished() {
+			return job
+		}
+	}
+
+	t.Fatalf("job %v is not finished", id)
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	s := newStore(t, t.TempDir(), time.Hour)
+
+	ok, _ := s.Create("author", []byte("ok"))
+	fail, _ := s.Create("author", []byte("fail"))
+
+	run(t, s, func(_ context.Context, _ *Job, payload []byte) (string, error) {
+		if string(payload) == "fail" {
+			return "", problem.New(problem.ProhibitedContent)
+		}
+		return "post", nil
+	})
+
+	if job := finished(t, s, ok.ID); job.Status != Done || job.Result != "post" || job.Attempts != 1 {
+		t.Fatalf("job got %+v, want done with its result", job)
+	}
+	if job := finished(t, s, fail.ID); job.Status != Failed || job.Error == nil || job.Error.Code != problem.ProhibitedContent {
+		t.Fatalf("job got %+v, want failed with its problem", job)
+	}
+
+	if _, err := s.Get(ok.ID, "stranger"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("job of another user got %v, want %v", err, ErrNotFound)
+	}
+
+	// The payload is removed once the result is saved
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if _, err := os.Stat(s.dir.Path(ok.ID, ".bin")); errors.Is(err, os.ErrNotExist) {
+			return
+		}
+	}
+	t.Fatal("payload of a finished job is kept")
+}
+
+func TestRetry(t *testing.T) {
+	s := newStore(t, t.TempDir(), time.Hour)
+	created, _ := s.Create("author", nil)
+
+	run(t, s, func(_ context.Context, job *Job, _ []byte) (string, error) {
+		if job.Attempts < 3 {
+			return "", Retry(time.Millisecond, problem.New(problem.ModerationUnavailable))
+		}
+		return "post", nil
+	})
+
+	if job := finished(t, s, created.ID); job.Status != Done || job.Attempts != 3 || job.Error != nil {
+		t.Fatalf("retried job got %+v", job)
+	}
+}
+
+func TestResume(t *testing.T) {
+	dir := t.TempDir()
+
+	// The instance stopped while running the job, whose lock expired
+	stopped := newStore(t, dir, time.Hour)
+	created, _ := stopped.Create("author", []byte("ok"))
+	created.Status = Running
+	created.Attempts = 1
+	stopped.Checkpoint(created, "post")
+
+	s := newStore(t, dir, time.Hour)
+	run(t, s, func(_ context.Context, job *Job, _ []byte) (string, error) {
+		// The step saved by the stopped attempt is not run again
+		return job.Checkpoint, nil
+	})
+
+	if job := finished(t, s, created.ID); job.Status != Done || job.Attempts != 2 || job.Result != "post" {
+		t.Fatalf("resumed job got %+v", job)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	s := newStore(t, t.TempDir(), 10*time.Millisecond)
+	created, _ := s.Create("author", nil)
+
+	// Info left by an instance stopped while saving it
+	tmp := s.dir.Path("stopped", ".json.tmp")
+	os.WriteFile(tmp, nil, 0600)
+	os.Chtimes(tmp, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+
+	run(t, s, func(context.Context, *Job, []byte) (string, error) {
+		return "post", nil
+	})
+	finished(t, s, created.ID)
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		_, err := s.Get(created.ID, "author")
+		if _, tmpErr := os.Stat(tmp); errors.Is(err, ErrNotFound) && errors.Is(tmpErr, os.ErrNotExist) {
+			return
+		}
+	}
+	t.Fatal("finished job or temporary info is kept after its retention")
+}
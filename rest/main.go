@@ -1,76 +1,165 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/Gravitalia/gravitalia/auth"
+	"github.com/Gravitalia/gravitalia/config"
 	"github.com/Gravitalia/gravitalia/database"
+	"github.com/Gravitalia/gravitalia/filestore"
+	"github.com/Gravitalia/gravitalia/grpc"
 	"github.com/Gravitalia/gravitalia/helpers"
+	"github.com/Gravitalia/gravitalia/job"
+	"github.com/Gravitalia/gravitalia/model"
+	"github.com/Gravitalia/gravitalia/mux"
 	route "github.com/Gravitalia/gravitalia/router"
-	"github.com/joho/godotenv"
+	"github.com/Gravitalia/gravitalia/upload"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
 	log.Println("Starting server...")
 
-	// Get key-value in .env file
-	godotenv.Load()
+	// Read configuration once, and stop if anything is invalid
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// Create a middleware to count requests
-	middleware := func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path == "/metrics" {
-				next.ServeHTTP(w, r)
-			} else {
-				start := time.Now()
-				helpers.IncrementRequests()
+	// Add tracer
+	client, serverMiddleware := helpers.InitTracer(cfg)
 
-				next.ServeHTTP(w, r)
+	// Stop on SIGTERM (docker, kubernetes) or SIGINT (Ctrl+C)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-				helpers.ObserveRequestDuration(time.Since(start).Seconds())
-			}
-		})
+	// Init every helpers function and database variables
+	helpers.Init()
+	if err := helpers.InitJWT(ctx, cfg.JWT); err != nil {
+		log.Fatalf("Cannot load keys verifying tokens: %v", err)
 	}
+	if err := grpc.Init(cfg); err != nil {
+		log.Fatalf("Cannot connect to gRPC services: %v", err)
+	}
+	store := database.Init(cfg)
+	route.Init(store, cfg)
+	// Before the jobs, whose resumed posts are published on NATS
+	helpers.InitNATS(cfg)
 
-	// Create routes
-	router := http.NewServeMux()
+	// Locks are shared by replicas in Memcached. The in-memory
+	// graph runs a single instance, which may have no Memcached
+	var locker filestore.Locker = database.Locker{}
+	if cfg.Graph.URL == database.MemoryURL {
+		locker = filestore.NewMemoryLocks()
+	}
 
-	// Add tracer
-	client, serverMiddleware := helpers.InitTracer()
-
-	router.HandleFunc("/", route.Index)
-	router.HandleFunc("/callback", route.OAuth(client))
-	router.HandleFunc("/users/", route.UserHandler)
-	router.HandleFunc("/relation/", route.RelationHandler)
-	router.HandleFunc("/posts/", route.PostHandler)
-	router.HandleFunc("/comment/", route.Handler)
-	router.HandleFunc("/list/", route.ListHandler)
-	router.HandleFunc("/request/", route.AcceptOrDecline)
-	router.HandleFunc("/account/deletion", route.DeleteUser(client))
-	router.HandleFunc("/account/suspend", route.Suspend)
-	router.HandleFunc("/account/data", route.GetData)
-	router.Handle("/metrics", promhttp.HandlerFor(helpers.GetRegistery(), promhttp.HandlerOpts{}))
+	// Resumable uploads, written by one instance at a time
+	uploads, err := upload.NewStore(cfg.Uploads, model.MaxImageSize, locker)
+	if err != nil {
+		log.Fatalf("Cannot create the directory of uploads: %v", err)
+	}
+	go uploads.SweepEvery(ctx, 10*time.Minute)
+	route.InitUploads(uploads)
 
-	// Init every helpers function and database variables
-	helpers.Init()
-	database.Init()
-	helpers.InitNATS()
+	// Posts published in the background, resumed after a restart
+	jobs, err := job.NewStore(cfg.Jobs, locker)
+	if err != nil {
+		log.Fatalf("Cannot create the directory of jobs: %v", err)
+	}
+	route.InitJobs(ctx, jobs, cfg.Jobs.Workers)
 
-	log.Println("Server is starting on port", os.Getenv("PORT"))
+	// Create routes
+	limiter := route.NewRateLimiter(cfg.RateLimit, database.Counters{})
+	router := mux.New()
+	router.Use(
+		helpers.Metrics,
+		helpers.NameSpan,
+		// Allowed web origins, before anything can reject the request
+		route.NewCORS(cfg.CORS).Middleware,
+		// Maximum duration of requests, per route
+		route.NewDeadlines(cfg.RouteTimeouts).Middleware,
+		// Reject expensive requests fast once their dependencies slow down
+		route.NewShedder(cfg.Shedding).Middleware,
+		// Maximum size of bodies, before signatures read them
+		route.NewBodyLimits(cfg.BodyLimits).Middleware,
+		// Unauthorized requests per IP address, before checking tokens
+		limiter.Authentication,
+		// Identify the caller once, routes then declare who they allow.
+		// Services sign their requests, nonces are shared by replicas
+		auth.NewAuthenticator(cfg.Services, auth.NonceFunc(database.RememberNonce), store.GetRoles).Middleware,
+		// Requests per user or IP address, counted in Memcached for every replica
+		limiter.Middleware,
+		// Replay responses to retries with an Idempotency-Key, from any replica
+		route.NewIdempotency(cfg.Idempotency, database.Responses{}).Middleware,
+	)
+
+	route.Register(router, client)
+	router.Handle(http.MethodGet, "/metrics", "metrics", promhttp.HandlerFor(helpers.GetRegistery(), promhttp.HandlerOpts{}))
+
+	log.Println("Server is starting on port", cfg.Port)
 
 	// Create web server
 	server := &http.Server{
-		Addr:              ":" + os.Getenv("PORT"),
+		Addr:              ":" + cfg.Port,
 		ReadHeaderTimeout: 3 * time.Second,
 	}
-	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		middleware(serverMiddleware(router)).ServeHTTP(w, r)
-	})
+	server.Handler = serverMiddleware(router)
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Cannot start server: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("Shutting down, waiting for running requests...")
+
+	shutdown(server, store, cfg.ShutdownTimeout)
+}
+
+// shutdown stops accepting connections, drains running requests
+// then closes dependencies, all within the grace period
+func shutdown(server *http.Server, store database.Store, grace time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Some requests have not finished: %v", err)
+	}
+
+	// Views, search indexation, published posts... may still use the store
+	if err := route.Wait(ctx); err != nil {
+		log.Printf("Some background tasks have not finished: %v", err)
+	}
 
-	if err := server.ListenAndServe(); err != nil {
-		panic(err)
+	// Send pending likes, comments... notifications before leaving
+	remaining := time.Second
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) > remaining {
+		remaining = time.Until(deadline)
 	}
+	if err := helpers.CloseNATS(remaining); err != nil {
+		log.Printf("Cannot flush NATS messages: %v", err)
+	}
+
+	if err := store.Close(ctx); err != nil {
+		log.Printf("Cannot close database: %v", err)
+	}
+
+	if err := grpc.Close(); err != nil {
+		log.Printf("Cannot close gRPC connections: %v", err)
+	}
+
+	if err := helpers.CloseTracer(); err != nil {
+		log.Printf("Cannot close tracer: %v", err)
+	}
+
+	log.Println("Server stopped")
 }
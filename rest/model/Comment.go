@@ -1,6 +1,8 @@
 package model
 
+// AddBody defines the body of a new comment
+// or of a reply to a comment
 type AddBody struct {
-	Content string `json:"content"`
-	ReplyTo string `json:"reply,omitempty"`
+	Content string `json:"content" validate:"required,max=500"`
+	ReplyTo string `json:"reply,omitempty" validate:"omitempty,format=id"`
 }
@@ -8,6 +8,8 @@ type Message struct {
 	From string `json:"from"`
 	// Must be User vanity or post ID
 	To string `json:"to"`
+	// Job is the ID of the job publishing the post, if any
+	Job string `json:"job,omitempty"`
 	// Set true to send push notification
 	Important bool `json:"important"`
 }
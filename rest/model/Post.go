@@ -11,9 +11,17 @@ type Post struct {
 	Comments    []any  `json:"comments,omitempty"`
 }
 
+// Limits of the images of a post, also set in the tags of PostBody
+const (
+	MaxImages    = 5
+	MaxImageSize = 5 << 20
+)
+
 // PostBody defines how body when posting
-// new image must be
+// new image must be. Images weigh up to 5 MiB each
 type PostBody struct {
-	Description string   `json:"description"`
-	Images      [][]byte `json:"images"`
+	Description string   `json:"description" validate:"max=2000"`
+	Images      [][]byte `json:"images" validate:"max=5,itembytes=5242880"`
+	// Uploads are IDs of complete resumable uploads, used as images
+	Uploads []string `json:"uploads,omitempty" validate:"max=5"`
 }
@@ -1,11 +1,23 @@
 package model
 
-// SetBody define the struct of the body
+// SetBody define the struct of the body.
+// Id is a user, a post or a comment depending on the relation
 type SetBody struct {
-	Id string `json:"id"`
+	Id string `json:"id" validate:"required,format=vanity|id"`
 }
 
 // UpdateBody define the body struct of patch route
 type UpdateBody struct {
 	Public *bool `json:"public,omitempty"`
 }
+
+// RelationState is the state of a relation once set or deleted
+type RelationState struct {
+	// Relation is the one set, such as REQUEST
+	// when subscribing to a private account
+	Relation string `json:"relation"`
+	Target   string `json:"target" validate:"required,format=vanity|id"`
+	Exists   bool   `json:"exists"`
+	// Changed is false if the relation was already in this state
+	Changed bool `json:"changed"`
+}
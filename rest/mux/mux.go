@@ -0,0 +1,236 @@
+// Package mux routes requests according to their method and path.
+//
+// Patterns are made of literal segments and parameters, such as
+// "/posts/{postID}" or "/users/{vanity}/posts/{page:int}". When several
+// patterns match a path, the most specific one wins: literal segments
+// before typed parameters, typed parameters before untyped ones.
+package mux
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Middleware wraps a handler
+type Middleware func(http.Handler) http.Handler
+
+// Router dispatches requests to the routes matching their
+// method and path, and answers OPTIONS and 405 by itself.
+// OPTIONS routes can be registered, the Allow header is then set
+// before they are called
+type Router struct {
+	routes      []*Route
+	middlewares []Middleware
+
+	// NotFound handles requests matching no pattern
+	NotFound http.Handler
+	// MethodNotAllowed handles requests matching a pattern, but not its method.
+	// The Allow header is already set when it is called
+	MethodNotAllowed http.Handler
+}
+
+// Route is a handler registered for a method and a pattern
+type Route struct {
+	name        string
+	method      string
+	pattern     string
+	segments    []segment
+	handler     http.Handler
+	middlewares []Middleware
+}
+
+type contextKey struct{}
+
+// match is saved in the request context once a route is found
+type match struct {
+	route  *Route
+	params map[string]string
+}
+
+// New creates an empty router
+func New() *Router {
+	return &Router{
+		NotFound: http.NotFoundHandler(),
+		MethodNotAllowed: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}),
+	}
+}
+
+// Use adds middlewares executed on every request, after the route
+// is found, so they can read it with CurrentRoute
+func (r *Router) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// Handle registers a handler for the method and the pattern.
+// The name identifies the route in metrics, traces and logs.
+// It panics if the pattern is invalid or already registered
+// with the same method
+func (r *Router) Handle(method string, pattern string, name string, handler http.Handler) *Route {
+	segments, err := parse(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("mux: %v", err))
+	}
+
+	route := &Route{
+		name:     name,
+		method:   strings.ToUpper(method),
+		pattern:  pattern,
+		segments: segments,
+		handler:  handler,
+	}
+
+	for _, existing := range r.routes {
+		if existing.method == route.method && existing.key() == route.key() {
+			panic(fmt.Sprintf("mux: %v %v conflicts with %v", method, pattern, existing.pattern))
+		}
+	}
+
+	r.routes = append(r.routes, route)
+	return route
+}
+
+// HandleFunc registers a handler function for the method and the pattern
+func (r *Router) HandleFunc(method string, pattern string, name string, handler http.HandlerFunc) *Route {
+	return r.Handle(method, pattern, name, handler)
+}
+
+// Routes returns every registered route
+func (r *Router) Routes() []*Route {
+	return r.routes
+}
+
+// ServeHTTP dispatches the request to the most specific route
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := split(req.URL.Path)
+
+	var (
+		found   *Route
+		params  map[string]string
+		allowed = make(map[string]bool)
+	)
+	for _, route := range r.routes {
+		values, ok := route.match(path)
+		if !ok {
+			continue
+		}
+		allowed[route.method] = true
+
+		if route.method != req.Method && (req.Method != http.MethodHead || route.method != http.MethodGet) {
+			continue
+		}
+
+		if found == nil || route.moreSpecific(found) {
+			found, params = route, values
+		}
+	}
+
+	var handler http.Handler
+	switch {
+	case found != nil:
+		// OPTIONS routes also get the methods of the path
+		if req.Method == http.MethodOptions {
+			w.Header().Set("Allow", allow(allowed))
+		}
+		req = req.WithContext(context.WithValue(req.Context(), contextKey{}, &match{route: found, params: params}))
+		handler = found.handler
+		for i := len(found.middlewares) - 1; i >= 0; i-- {
+			handler = found.middlewares[i](handler)
+		}
+	case len(allowed) == 0:
+		handler = r.NotFound
+	default:
+		w.Header().Set("Allow", allow(allowed))
+		if req.Method == http.MethodOptions {
+			handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+		} else {
+			handler = r.MethodNotAllowed
+		}
+	}
+
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		handler = r.middlewares[i](handler)
+	}
+
+	handler.ServeHTTP(w, req)
+}
+
+// Use adds middlewares executed only on this route,
+// after the ones of the router
+func (route *Route) Use(middlewares ...Middleware) *Route {
+	route.middlewares = append(route.middlewares, middlewares...)
+	return route
+}
+
+// Name returns the name of the route, such as "posts.get"
+func (route *Route) Name() string {
+	return route.name
+}
+
+// Method returns the HTTP method of the route
+func (route *Route) Method() string {
+	return route.method
+}
+
+// Pattern returns the pattern of the route, such as "/posts/{postID}"
+func (route *Route) Pattern() string {
+	return route.pattern
+}
+
+// CurrentRoute returns the route handling the request,
+// or nil if no route matched
+func CurrentRoute(ctx context.Context) *Route {
+	if m, ok := ctx.Value(contextKey{}).(*match); ok {
+		return m.route
+	}
+
+	return nil
+}
+
+// Param returns the value of a path parameter, already validated
+// by its type, or an empty string if it doesn't exist
+func Param(req *http.Request, name string) string {
+	if m, ok := req.Context().Value(contextKey{}).(*match); ok {
+		return m.params[name]
+	}
+
+	return ""
+}
+
+// WithParams returns a copy of the request handled by route,
+// with the path parameters. It is made for handler tests
+func WithParams(req *http.Request, route *Route, params map[string]string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), contextKey{}, &match{route: route, params: params}))
+}
+
+// allow returns the value of the Allow header
+func allow(methods map[string]bool) string {
+	if methods[http.MethodGet] {
+		methods[http.MethodHead] = true
+	}
+	methods[http.MethodOptions] = true
+
+	list := make([]string, 0, len(methods))
+	for method := range methods {
+		list = append(list, method)
+	}
+	sort.Strings(list)
+
+	return strings.Join(list, ", ")
+}
+
+// split returns the segments of a path, ignoring the trailing slash
+func split(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+
+	return strings.Split(path, "/")
+}
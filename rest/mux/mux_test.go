@@ -0,0 +1,106 @@
+package mux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// echo writes the route name and a parameter
+func echo(param string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(CurrentRoute(req.Context()).Name() + ":" + Param(req, param)))
+	}
+}
+
+func newRouter() *Router {
+	r := New()
+	r.HandleFunc(http.MethodGet, "/posts/{postID:id}", "posts.get", echo("postID"))
+	r.HandleFunc(http.MethodPost, "/posts/new", "posts.create", echo(""))
+	r.HandleFunc(http.MethodDelete, "/posts/{postID:id}", "posts.delete", echo("postID"))
+	r.HandleFunc(http.MethodGet, "/users/{vanity}", "users.get", echo("vanity"))
+	r.HandleFunc(http.MethodGet, "/users/{page:int}", "users.page", echo("page"))
+	r.HandleFunc(http.MethodPost, "/request/{choice:accept|decline}", "request", echo("choice"))
+	r.HandleFunc(http.MethodOptions, "/uploads", "uploads.options", echo(""))
+	r.HandleFunc(http.MethodPost, "/uploads", "uploads.create", echo(""))
+
+	return r
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := newRouter()
+
+	for _, test := range []struct {
+		method string
+		path   string
+		code   int
+		body   string
+		allow  string
+	}{
+		{http.MethodGet, "/posts/123", http.StatusOK, "posts.get:123", ""},
+		{http.MethodGet, "/posts/123/", http.StatusOK, "posts.get:123", ""},
+		{http.MethodPost, "/posts/new", http.StatusOK, "posts.create:", ""},
+		{http.MethodDelete, "/posts/123", http.StatusOK, "posts.delete:123", ""},
+		{http.MethodHead, "/posts/123", http.StatusOK, "posts.get:123", ""},
+		{http.MethodPost, "/posts/123", http.StatusMethodNotAllowed, "", "DELETE, GET, HEAD, OPTIONS"},
+		{http.MethodGet, "/posts/new", http.StatusMethodNotAllowed, "", "OPTIONS, POST"},
+		{http.MethodOptions, "/posts/new", http.StatusNoContent, "", "OPTIONS, POST"},
+		{http.MethodGet, "/posts/abc", http.StatusNotFound, "", ""},
+		{http.MethodOptions, "/uploads", http.StatusOK, "uploads.options:", "OPTIONS, POST"},
+		{http.MethodGet, "/users/12", http.StatusOK, "users.page:12", ""},
+		{http.MethodGet, "/users/twelve", http.StatusOK, "users.get:twelve", ""},
+		{http.MethodPost, "/request/accept", http.StatusOK, "request:accept", ""},
+		{http.MethodPost, "/request/ignore", http.StatusNotFound, "", ""},
+		{http.MethodGet, "/unknown", http.StatusNotFound, "", ""},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, nil))
+
+		if rec.Code != test.code {
+			t.Errorf("%v %v: got status %v, want %v", test.method, test.path, rec.Code, test.code)
+		}
+		if test.code == http.StatusOK && rec.Body.String() != test.body {
+			t.Errorf("%v %v: got %q, want %q", test.method, test.path, rec.Body.String(), test.body)
+		}
+		if allow := rec.Header().Get("Allow"); allow != test.allow {
+			t.Errorf("%v %v: got Allow %q, want %q", test.method, test.path, allow, test.allow)
+		}
+	}
+}
+
+func TestMiddlewares(t *testing.T) {
+	r := New()
+
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, req)
+			})
+		}
+	}
+
+	r.Use(trace("router"))
+	r.HandleFunc(http.MethodGet, "/", "index", func(http.ResponseWriter, *http.Request) {
+		order = append(order, "handler")
+	}).Use(trace("route"))
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if len(order) != 3 || order[0] != "router" || order[1] != "route" || order[2] != "handler" {
+		t.Fatalf("invalid middleware order: %v", order)
+	}
+}
+
+func TestHandleConflict(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic on conflicting routes")
+		}
+	}()
+
+	r := New()
+	r.HandleFunc(http.MethodGet, "/posts/{postID}", "posts.get", echo("postID"))
+	r.HandleFunc(http.MethodGet, "/posts/{id}", "posts.other", echo("id"))
+}
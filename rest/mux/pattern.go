@@ -0,0 +1,152 @@
+package mux
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Types of path parameters, written as {name:type}
+var types = map[string]func(string) bool{
+	"int": func(value string) bool {
+		_, err := strconv.ParseUint(value, 10, 64)
+		return err == nil
+	},
+	"uuid":  regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`).MatchString,
+	"alnum": regexp.MustCompile(`^[0-9a-zA-Z]+$`).MatchString,
+	// IDs of posts and comments, generated as numbers
+	"id": regexp.MustCompile(`^[0-9]{1,20}$`).MatchString,
+}
+
+// segment is a part of a pattern, between two slashes
+type segment struct {
+	literal string
+	param   string
+	kind    string
+	// check validates the value of a typed parameter
+	check func(string) bool
+}
+
+// Weights used to sort patterns by specificity
+const (
+	weightParam = iota + 1
+	weightTyped
+	weightLiteral
+)
+
+func (s segment) weight() int {
+	switch {
+	case s.param == "":
+		return weightLiteral
+	case s.check != nil:
+		return weightTyped
+	}
+
+	return weightParam
+}
+
+// parse reads a pattern. A type can also be a list
+// of allowed values, such as {choice:accept|decline}
+func parse(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("pattern %q must start with /", pattern)
+	}
+
+	segments := make([]segment, 0)
+	names := make(map[string]bool)
+	for _, part := range split(pattern) {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("invalid segment %q in %q", part, pattern)
+			}
+
+			segments = append(segments, segment{literal: part})
+			continue
+		}
+
+		name, kind, typed := strings.Cut(part[1:len(part)-1], ":")
+		if name == "" || names[name] {
+			return nil, fmt.Errorf("invalid or duplicated parameter %q in %q", part, pattern)
+		}
+		names[name] = true
+
+		s := segment{param: name, kind: kind}
+		if typed {
+			if check, ok := types[kind]; ok {
+				s.check = check
+			} else if strings.Contains(kind, "|") {
+				s.check = oneOf(strings.Split(kind, "|"))
+			} else {
+				return nil, fmt.Errorf("unknown type %q in %q", kind, pattern)
+			}
+		}
+
+		segments = append(segments, s)
+	}
+
+	return segments, nil
+}
+
+// oneOf only allows the values of the list
+func oneOf(values []string) func(string) bool {
+	return func(value string) bool {
+		for _, allowed := range values {
+			if value == allowed {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// match checks the path segments, and returns the parameters
+func (route *Route) match(path []string) (map[string]string, bool) {
+	if len(path) != len(route.segments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, s := range route.segments {
+		if s.param == "" {
+			if path[i] != s.literal {
+				return nil, false
+			}
+			continue
+		}
+
+		if s.check != nil && !s.check(path[i]) {
+			return nil, false
+		}
+		params[s.param] = path[i]
+	}
+
+	return params, true
+}
+
+// moreSpecific compares segments from left to right
+func (route *Route) moreSpecific(other *Route) bool {
+	for i := range route.segments {
+		if a, b := route.segments[i].weight(), other.segments[i].weight(); a != b {
+			return a > b
+		}
+	}
+
+	return false
+}
+
+// key identifies patterns matching the same paths,
+// whatever the names of their parameters
+func (route *Route) key() string {
+	parts := make([]string, len(route.segments))
+	for i, s := range route.segments {
+		if s.param == "" {
+			parts[i] = s.literal
+		} else {
+			parts[i] = "{" + s.kind + "}"
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
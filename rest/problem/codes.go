@@ -0,0 +1,120 @@
+package problem
+
+import "net/http"
+
+// Code identifies a problem. Codes never change,
+// unlike titles and details
+type Code string
+
+// Every possible problem
+const (
+	// Requests
+	NotFound          Code = "not_found"
+	MethodNotAllowed  Code = "method_not_allowed"
+	InvalidBody       Code = "invalid_body"
+	BodyTooLarge      Code = "body_too_large"
+	InvalidHeader     Code = "invalid_header"
+	UnsupportedMedia  Code = "unsupported_media_type"
+	UnsupportedTus    Code = "unsupported_tus_version"
+	InvalidQuery      Code = "invalid_query"
+	RateLimited       Code = "rate_limited"
+	ValidationFailed  Code = "validation_failed"
+	ProhibitedContent Code = "prohibited_content"
+	// The Idempotency-Key was sent with another body
+	IdempotencyKeyReused Code = "idempotency_key_reused"
+	// The first request with the Idempotency-Key is still running
+	IdempotencyConflict Code = "idempotency_conflict"
+
+	// Authentication
+	MissingToken     Code = "missing_token"
+	InvalidToken     Code = "invalid_token"
+	InvalidSignature Code = "invalid_signature"
+	ReplayedRequest  Code = "replayed_request"
+	Forbidden        Code = "forbidden"
+
+	// Resources
+	UserNotFound       Code = "user_not_found"
+	UserSuspended      Code = "user_suspended"
+	UserBlocked        Code = "user_blocked"
+	PostNotFound       Code = "post_not_found"
+	PostAccessDenied   Code = "post_access_denied"
+	CommentNotFound    Code = "comment_not_found"
+	InvalidRelation    Code = "invalid_relation"
+	RelationNotFound   Code = "relation_not_found"
+	InvalidList        Code = "invalid_list"
+	DataRequestedSoon  Code = "data_requested_recently"
+	InvalidOAuthCode   Code = "invalid_oauth_code"
+	AccountDeletedSoon Code = "account_deleted_recently"
+	UploadNotFound     Code = "upload_not_found"
+	UploadOffset       Code = "upload_offset_mismatch"
+	UploadLocked       Code = "upload_locked"
+	JobNotFound        Code = "job_not_found"
+
+	// Server
+	Internal       Code = "internal_error"
+	DatabaseError  Code = "database_error"
+	UploadFailed   Code = "upload_failed"
+	UpstreamFailed Code = "upstream_failed"
+	Timeout        Code = "timeout"
+	Canceled       Code = "canceled"
+	Unavailable    Code = "service_unavailable"
+	Overloaded     Code = "overloaded"
+	// Posts aren't published without moderation, see MODERATION_UNAVAILABLE
+	ModerationUnavailable Code = "moderation_unavailable"
+)
+
+type definition struct {
+	status int
+	title  string
+}
+
+var definitions = map[Code]definition{
+	NotFound:          {http.StatusNotFound, "Not found"},
+	MethodNotAllowed:  {http.StatusMethodNotAllowed, "Method not allowed"},
+	InvalidBody:       {http.StatusBadRequest, "Invalid body"},
+	BodyTooLarge:      {http.StatusRequestEntityTooLarge, "Body too large"},
+	InvalidHeader:     {http.StatusBadRequest, "Invalid header"},
+	UnsupportedMedia:  {http.StatusUnsupportedMediaType, "Unsupported content type"},
+	UnsupportedTus:    {http.StatusPreconditionFailed, "Unsupported tus version"},
+	InvalidQuery:      {http.StatusBadRequest, "Invalid query"},
+	RateLimited:       {http.StatusTooManyRequests, "Too many requests"},
+	ValidationFailed:  {http.StatusUnprocessableEntity, "Validation failed"},
+	ProhibitedContent: {http.StatusUnprocessableEntity, "Content does not comply with our rules"},
+
+	IdempotencyKeyReused: {http.StatusUnprocessableEntity, "Idempotency key already used by another request"},
+	IdempotencyConflict:  {http.StatusConflict, "A request with this idempotency key is running"},
+
+	MissingToken:     {http.StatusUnauthorized, "Missing token"},
+	InvalidToken:     {http.StatusUnauthorized, "Invalid token"},
+	InvalidSignature: {http.StatusUnauthorized, "Invalid request signature"},
+	ReplayedRequest:  {http.StatusUnauthorized, "Request already received"},
+	Forbidden:        {http.StatusForbidden, "Forbidden"},
+
+	UserNotFound:       {http.StatusNotFound, "User not found"},
+	UserSuspended:      {http.StatusForbidden, "User suspended"},
+	UserBlocked:        {http.StatusConflict, "User blocked"},
+	PostNotFound:       {http.StatusNotFound, "Post not found"},
+	PostAccessDenied:   {http.StatusForbidden, "No access to this post"},
+	CommentNotFound:    {http.StatusNotFound, "Comment not found"},
+	InvalidRelation:    {http.StatusBadRequest, "Invalid relation"},
+	RelationNotFound:   {http.StatusNotFound, "Relation not found"},
+	InvalidList:        {http.StatusBadRequest, "Invalid list"},
+	DataRequestedSoon:  {http.StatusTooManyRequests, "Data already requested recently"},
+	InvalidOAuthCode:   {http.StatusBadRequest, "Invalid code"},
+	AccountDeletedSoon: {http.StatusConflict, "Account deleted too soon"},
+	UploadNotFound:     {http.StatusNotFound, "Upload not found"},
+	UploadOffset:       {http.StatusConflict, "Offset doesn't match the upload"},
+	UploadLocked:       {http.StatusLocked, "Upload is being written"},
+	JobNotFound:        {http.StatusNotFound, "Job not found"},
+
+	Internal:       {http.StatusInternalServerError, "Internal server error"},
+	DatabaseError:  {http.StatusInternalServerError, "Couldn't get database response"},
+	UploadFailed:   {http.StatusBadGateway, "Error occurs when uploading content"},
+	UpstreamFailed: {http.StatusBadGateway, "Internal service failed"},
+	Timeout:        {http.StatusGatewayTimeout, "Request took too long"},
+	Canceled:       {499, "Request canceled"},
+	Unavailable:    {http.StatusServiceUnavailable, "Service unavailable"},
+	Overloaded:     {http.StatusServiceUnavailable, "Too many running requests, retry later"},
+
+	ModerationUnavailable: {http.StatusServiceUnavailable, "Images can't be moderated, retry later"},
+}
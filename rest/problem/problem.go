@@ -0,0 +1,124 @@
+// Package problem describes API errors as RFC 7807 documents
+// (application/problem+json), identified by stable codes
+// clients can rely on.
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// ContentType of every error response
+const ContentType = "application/problem+json"
+
+// typePrefix turns a code into the URI of the problem type
+const typePrefix = "urn:gravitalia:problem:"
+
+// Problem is an error sent to the client
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Code     Code   `json:"code"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Fields lists invalid fields of the request
+	Fields []FieldError `json:"errors,omitempty"`
+
+	// cause is logged, but never sent to the client
+	cause error
+}
+
+// FieldError describes why a field of the request is invalid
+type FieldError struct {
+	Field  string `json:"field"`
+	Code   string `json:"code"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// New creates the problem of a code, with its status and title
+func New(code Code) *Problem {
+	d, ok := definitions[code]
+	if !ok {
+		d = definitions[Internal]
+	}
+
+	return &Problem{
+		Type:   typePrefix + string(code),
+		Title:  d.title,
+		Status: d.status,
+		Code:   code,
+	}
+}
+
+// Wrap creates the problem of a code caused by err.
+// Expired or canceled contexts take precedence over the code
+func Wrap(code Code, err error) *Problem {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		code = Timeout
+	case errors.Is(err, context.Canceled):
+		code = Canceled
+	}
+
+	p := New(code)
+	p.cause = err
+	return p
+}
+
+// WithDetail explains this occurrence of the problem
+func (p *Problem) WithDetail(format string, args ...any) *Problem {
+	p.Detail = fmt.Sprintf(format, args...)
+	return p
+}
+
+// WithField adds an invalid field, such as ("content", "required")
+func (p *Problem) WithField(field string, code string, detail string) *Problem {
+	p.Fields = append(p.Fields, FieldError{Field: field, Code: code, Detail: detail})
+	return p
+}
+
+func (p *Problem) Error() string {
+	if p.cause != nil {
+		return fmt.Sprintf("%v: %v", p.Code, p.cause)
+	}
+	if p.Detail != "" {
+		return fmt.Sprintf("%v: %v", p.Code, p.Detail)
+	}
+
+	return string(p.Code)
+}
+
+func (p *Problem) Unwrap() error {
+	return p.cause
+}
+
+// From converts any error to a problem. Errors which are not
+// problems become internal errors, or timeouts for expired contexts
+func From(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+
+	return Wrap(Internal, err)
+}
+
+// Write sends the error as a problem document. Server errors
+// are logged with their cause, which is never sent
+func Write(w http.ResponseWriter, req *http.Request, err error) {
+	p := *From(err)
+	p.Instance = req.URL.Path
+
+	if p.Status >= http.StatusInternalServerError {
+		log.Printf("(%v %v) %v", req.Method, req.URL.Path, p.Error())
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,62 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	for _, test := range []struct {
+		err    error
+		status int
+		code   Code
+	}{
+		{New(PostNotFound), http.StatusNotFound, PostNotFound},
+		{fmt.Errorf("wrapped: %w", New(MissingToken)), http.StatusUnauthorized, MissingToken},
+		{errors.New("connection refused"), http.StatusInternalServerError, Internal},
+		{Wrap(DatabaseError, context.DeadlineExceeded), http.StatusGatewayTimeout, Timeout},
+		{New(ValidationFailed).WithField("content", "required", ""), http.StatusUnprocessableEntity, ValidationFailed},
+	} {
+		rec := httptest.NewRecorder()
+		Write(rec, httptest.NewRequest(http.MethodGet, "/posts/abc", nil), test.err)
+
+		if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+			t.Errorf("%v: got Content-Type %q", test.err, ct)
+		}
+
+		var p Problem
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+
+		if rec.Code != test.status || p.Status != test.status || p.Code != test.code {
+			t.Errorf("%v: got %v %+v, want %v %v", test.err, rec.Code, p, test.status, test.code)
+		}
+		if p.Instance != "/posts/abc" || p.Type != typePrefix+string(test.code) {
+			t.Errorf("%v: invalid type or instance %+v", test.err, p)
+		}
+	}
+}
+
+func TestWriteHidesCause(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Write(rec, httptest.NewRequest(http.MethodGet, "/", nil), Wrap(DatabaseError, errors.New("bolt://secret-host:7687 refused")))
+
+	if strings.Contains(rec.Body.String(), "secret-host") {
+		t.Fatalf("cause sent to the client: %v", rec.Body.String())
+	}
+}
+
+func TestDefinitions(t *testing.T) {
+	for code, d := range definitions {
+		if d.status < 400 || d.title == "" {
+			t.Errorf("%v: invalid definition %+v", code, d)
+		}
+	}
+}
@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.30.0
+// 	protoc-gen-go v1.31.0
 // 	protoc        v3.14.0
 // source: proto/spinoza.proto
 
@@ -204,20 +204,25 @@ var file_proto_spinoza_proto_rawDesc = []byte{
 	0x72, 0x72, 0x6f, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x65, 0x72, 0x72, 0x6f,
 	0x72, 0x22, 0x23, 0x0a, 0x0d, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65,
 	0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x61, 0x73, 0x68, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x04, 0x68, 0x61, 0x73, 0x68, 0x32, 0x7f, 0x0a, 0x07, 0x53, 0x70, 0x69, 0x6e, 0x6f, 0x7a,
-	0x61, 0x12, 0x39, 0x0a, 0x06, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x16, 0x2e, 0x73, 0x70,
-	0x69, 0x6e, 0x6f, 0x7a, 0x61, 0x2e, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x52, 0x65, 0x71, 0x75,
-	0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e, 0x73, 0x70, 0x69, 0x6e, 0x6f, 0x7a, 0x61, 0x2e, 0x42, 0x61,
-	0x73, 0x69, 0x63, 0x52, 0x65, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x39, 0x0a, 0x06,
-	0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x12, 0x16, 0x2e, 0x73, 0x70, 0x69, 0x6e, 0x6f, 0x7a, 0x61,
-	0x2e, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x15,
-	0x2e, 0x73, 0x70, 0x69, 0x6e, 0x6f, 0x7a, 0x61, 0x2e, 0x42, 0x61, 0x73, 0x69, 0x63, 0x52, 0x65,
-	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x42, 0x4d, 0x0a, 0x16, 0x63, 0x6f, 0x6d, 0x2e, 0x67,
-	0x72, 0x61, 0x76, 0x69, 0x74, 0x61, 0x6c, 0x69, 0x61, 0x2e, 0x73, 0x70, 0x69, 0x6e, 0x6f, 0x7a,
-	0x61, 0x42, 0x0c, 0x53, 0x70, 0x69, 0x6e, 0x6f, 0x7a, 0x61, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x50,
-	0x01, 0x5a, 0x23, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x67, 0x72,
-	0x61, 0x76, 0x69, 0x74, 0x61, 0x6c, 0x69, 0x61, 0x2f, 0x73, 0x70, 0x69, 0x6e, 0x6f, 0x7a, 0x61,
-	0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x52, 0x04, 0x68, 0x61, 0x73, 0x68, 0x32, 0xc2, 0x01, 0x0a, 0x07, 0x53, 0x70, 0x69, 0x6e, 0x6f,
+	0x7a, 0x61, 0x12, 0x39, 0x0a, 0x06, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x16, 0x2e, 0x73,
+	0x70, 0x69, 0x6e, 0x6f, 0x7a, 0x61, 0x2e, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e, 0x73, 0x70, 0x69, 0x6e, 0x6f, 0x7a, 0x61, 0x2e, 0x42,
+	0x61, 0x73, 0x69, 0x63, 0x52, 0x65, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x41, 0x0a,
+	0x0c, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x12, 0x16, 0x2e,
+	0x73, 0x70, 0x69, 0x6e, 0x6f, 0x7a, 0x61, 0x2e, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e, 0x73, 0x70, 0x69, 0x6e, 0x6f, 0x7a, 0x61, 0x2e,
+	0x42, 0x61, 0x73, 0x69, 0x63, 0x52, 0x65, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x28, 0x01,
+	0x12, 0x39, 0x0a, 0x06, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x12, 0x16, 0x2e, 0x73, 0x70, 0x69,
+	0x6e, 0x6f, 0x7a, 0x61, 0x2e, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x15, 0x2e, 0x73, 0x70, 0x69, 0x6e, 0x6f, 0x7a, 0x61, 0x2e, 0x42, 0x61, 0x73,
+	0x69, 0x63, 0x52, 0x65, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x42, 0x4d, 0x0a, 0x16, 0x63,
+	0x6f, 0x6d, 0x2e, 0x67, 0x72, 0x61, 0x76, 0x69, 0x74, 0x61, 0x6c, 0x69, 0x61, 0x2e, 0x73, 0x70,
+	0x69, 0x6e, 0x6f, 0x7a, 0x61, 0x42, 0x0c, 0x53, 0x70, 0x69, 0x6e, 0x6f, 0x7a, 0x61, 0x50, 0x72,
+	0x6f, 0x74, 0x6f, 0x50, 0x01, 0x5a, 0x23, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f,
+	0x6d, 0x2f, 0x67, 0x72, 0x61, 0x76, 0x69, 0x74, 0x61, 0x6c, 0x69, 0x61, 0x2f, 0x73, 0x70, 0x69,
+	0x6e, 0x6f, 0x7a, 0x61, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
 }
 
 var (
@@ -240,11 +245,13 @@ var file_proto_spinoza_proto_goTypes = []interface{}{
 }
 var file_proto_spinoza_proto_depIdxs = []int32{
 	0, // 0: spinoza.Spinoza.Upload:input_type -> spinoza.UploadRequest
-	2, // 1: spinoza.Spinoza.Delete:input_type -> spinoza.DeleteRequest
-	1, // 2: spinoza.Spinoza.Upload:output_type -> spinoza.BasicReponse
-	1, // 3: spinoza.Spinoza.Delete:output_type -> spinoza.BasicReponse
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
+	0, // 1: spinoza.Spinoza.UploadStream:input_type -> spinoza.UploadRequest
+	2, // 2: spinoza.Spinoza.Delete:input_type -> spinoza.DeleteRequest
+	1, // 3: spinoza.Spinoza.Upload:output_type -> spinoza.BasicReponse
+	1, // 4: spinoza.Spinoza.UploadStream:output_type -> spinoza.BasicReponse
+	1, // 5: spinoza.Spinoza.Delete:output_type -> spinoza.BasicReponse
+	3, // [3:6] is the sub-list for method output_type
+	0, // [0:3] is the sub-list for method input_type
 	0, // [0:0] is the sub-list for extension type_name
 	0, // [0:0] is the sub-list for extension extendee
 	0, // [0:0] is the sub-list for field type_name
@@ -24,6 +24,9 @@ const _ = grpc.SupportPackageIsVersion7
 type SpinozaClient interface {
 	// Compress and then upload the image to the CDN provider
 	Upload(ctx context.Context, in *UploadRequest, opts ...grpc.CallOption) (*BasicReponse, error)
+	// Same as Upload, with the image sent in chunks. Width and height
+	// are read from the first chunk
+	UploadStream(ctx context.Context, opts ...grpc.CallOption) (Spinoza_UploadStreamClient, error)
 	// Allows to remove a picture from CDN provider
 	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*BasicReponse, error)
 }
@@ -45,6 +48,40 @@ func (c *spinozaClient) Upload(ctx context.Context, in *UploadRequest, opts ...g
 	return out, nil
 }
 
+func (c *spinozaClient) UploadStream(ctx context.Context, opts ...grpc.CallOption) (Spinoza_UploadStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Spinoza_ServiceDesc.Streams[0], "/spinoza.Spinoza/UploadStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &spinozaUploadStreamClient{stream}
+	return x, nil
+}
+
+type Spinoza_UploadStreamClient interface {
+	Send(*UploadRequest) error
+	CloseAndRecv() (*BasicReponse, error)
+	grpc.ClientStream
+}
+
+type spinozaUploadStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *spinozaUploadStreamClient) Send(m *UploadRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *spinozaUploadStreamClient) CloseAndRecv() (*BasicReponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(BasicReponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *spinozaClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*BasicReponse, error) {
 	out := new(BasicReponse)
 	err := c.cc.Invoke(ctx, "/spinoza.Spinoza/Delete", in, out, opts...)
@@ -60,6 +97,9 @@ func (c *spinozaClient) Delete(ctx context.Context, in *DeleteRequest, opts ...g
 type SpinozaServer interface {
 	// Compress and then upload the image to the CDN provider
 	Upload(context.Context, *UploadRequest) (*BasicReponse, error)
+	// Same as Upload, with the image sent in chunks. Width and height
+	// are read from the first chunk
+	UploadStream(Spinoza_UploadStreamServer) error
 	// Allows to remove a picture from CDN provider
 	Delete(context.Context, *DeleteRequest) (*BasicReponse, error)
 	mustEmbedUnimplementedSpinozaServer()
@@ -72,6 +112,9 @@ type UnimplementedSpinozaServer struct {
 func (UnimplementedSpinozaServer) Upload(context.Context, *UploadRequest) (*BasicReponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Upload not implemented")
 }
+func (UnimplementedSpinozaServer) UploadStream(Spinoza_UploadStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method UploadStream not implemented")
+}
 func (UnimplementedSpinozaServer) Delete(context.Context, *DeleteRequest) (*BasicReponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
 }
@@ -106,6 +149,32 @@ func _Spinoza_Upload_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Spinoza_UploadStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SpinozaServer).UploadStream(&spinozaUploadStreamServer{stream})
+}
+
+type Spinoza_UploadStreamServer interface {
+	SendAndClose(*BasicReponse) error
+	Recv() (*UploadRequest, error)
+	grpc.ServerStream
+}
+
+type spinozaUploadStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *spinozaUploadStreamServer) SendAndClose(m *BasicReponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *spinozaUploadStreamServer) Recv() (*UploadRequest, error) {
+	m := new(UploadRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _Spinoza_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(DeleteRequest)
 	if err := dec(in); err != nil {
@@ -140,6 +209,12 @@ var Spinoza_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _Spinoza_Delete_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UploadStream",
+			Handler:       _Spinoza_UploadStream_Handler,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "proto/spinoza.proto",
 }
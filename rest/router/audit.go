@@ -0,0 +1,27 @@
+package router
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/Gravitalia/gravitalia/auth"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// audit traces an action done on the account of target
+// to the user or the service doing it. Actions of users
+// on their own account are not audited
+func audit(req *http.Request, action string, target string) {
+	p := auth.FromContext(req.Context())
+	if p != nil && !p.IsService() && p.Vanity == target {
+		return
+	}
+
+	log.Printf("(Audit) %v %v %v", p, action, target)
+
+	if span := zipkin.SpanFromContext(req.Context()); span != nil {
+		span.Tag("audit.actor", p.String())
+		span.Tag("audit.action", action)
+		span.Tag("audit.target", target)
+	}
+}
@@ -0,0 +1,40 @@
+package router
+
+import (
+	"context"
+	"sync"
+)
+
+// tasks counts the work still running after the response is sent
+var tasks sync.WaitGroup
+
+// background runs fn after the response, without the request context,
+// and lets Wait know about it
+func background(fn func(ctx context.Context)) {
+	tasks.Add(1)
+	go func() {
+		defer tasks.Done()
+		fn(context.Background())
+	}()
+}
+
+// Wait blocks until every background task and running job is
+// done, or returns the context error once it is canceled
+func Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		tasks.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if jobs == nil {
+		return nil
+	}
+	return jobs.Wait(ctx)
+}
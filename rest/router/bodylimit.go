@@ -0,0 +1,59 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/Gravitalia/gravitalia/model"
+	"github.com/Gravitalia/gravitalia/mux"
+	"github.com/Gravitalia/gravitalia/problem"
+)
+
+// BodyLimits limits the size of request bodies, per route or group of
+// routes, so handlers never read more than they can check
+type BodyLimits struct {
+	limits map[string]config.Size
+}
+
+// NewBodyLimits overrides the default limits with the configured ones.
+// The "default" key is used by routes without limit
+func NewBodyLimits(cfg map[string]config.Size) *BodyLimits {
+	limits := map[string]config.Size{
+		"default": 64 << 10,
+		// Up to 5 images of 5 MiB, encoded in base64
+		"posts.create": 40 << 20,
+		// Chunks of resumable uploads
+		"uploads.append": model.MaxImageSize,
+	}
+
+	for route, limit := range cfg {
+		limits[route] = limit
+	}
+
+	return &BodyLimits{limits: limits}
+}
+
+// Middleware rejects requests announcing a body larger than the limit
+// of their route, and stops reading other bodies at the limit
+func (b *BodyLimits) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var name string
+		if route := mux.CurrentRoute(req.Context()); route != nil {
+			name = route.Name()
+		}
+
+		limit := int64(b.limits[routeGroup(b.limits, name)])
+		if limit <= 0 || req.Body == nil || req.Body == http.NoBody {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		if req.ContentLength > limit {
+			problem.Write(w, req, problem.New(problem.BodyTooLarge).WithDetail("body must not exceed %d bytes", limit))
+			return
+		}
+
+		req.Body = http.MaxBytesReader(w, req.Body, limit)
+		next.ServeHTTP(w, req)
+	})
+}
@@ -2,164 +2,54 @@ package router
 
 import (
 	"encoding/json"
-	"io"
-	"log"
 	"net/http"
 	"strconv"
-	"strings"
 
-	"github.com/Gravitalia/gravitalia/database"
+	"github.com/Gravitalia/gravitalia/auth"
 	"github.com/Gravitalia/gravitalia/helpers"
 	"github.com/Gravitalia/gravitalia/model"
+	"github.com/Gravitalia/gravitalia/mux"
+	"github.com/Gravitalia/gravitalia/problem"
+	"github.com/Gravitalia/gravitalia/validate"
 )
 
-// getVanity permit to get vanity
-func getVanity(token string) string {
-	var vanity string
-	if token == "" {
-		return ""
-	} else {
-		data, err := helpers.CheckToken(token)
-		if err != nil {
-			return ""
-		}
-		vanity = data
-	}
-
-	return vanity
-}
-
-// doesCommentExists checks if a comment really exists
-func doesCommentExists(id string) bool {
-	res, err := database.MakeRequest("MATCH (c:Comment {id: $id}) RETURN c;", map[string]any{"id": id})
-	if err != nil {
-		return false
-	}
-
-	if res != nil {
-		return true
-	} else {
-		return false
-	}
-}
-
-// isAReply checks if the comment ID is a reply
-// if yes, return the original comment
-func isAReply(id string) string {
-	res, err := database.MakeRequest("MATCH (:Comment {id: $id})-[:Reply]->(c:Comment) RETURN c.id;", map[string]any{"id": id})
-	if err != nil {
-		return ""
-	}
-
-	return res.(string)
-}
-
-// Handler re-routes request to the right function
-// based on its method
-func Handler(w http.ResponseWriter, req *http.Request) {
-	if req.Method == http.MethodGet {
-		getComment(w, req)
-	} else if req.Method == http.MethodPost {
-		addComment(w, req)
-	} else if req.Method == http.MethodDelete {
-		deleteComment(w, req)
-	}
-}
-
 // getComment returns the comment and replies
 func getComment(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	jsonEncoder := json.NewEncoder(w)
 
-	vanity := getVanity(req.Header.Get("authorization"))
-
-	id := strings.TrimPrefix(req.URL.Path, "/comment/")
-	post, err := database.GetPost(id, "")
-	if err != nil || post.Id == "" {
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidPost,
-		})
-		return
-	}
-
-	// Get user profile
-	stats, err := database.GetBasicProfile(post.Author)
-	if err != nil || stats.Suspended {
-		log.Printf("(getPost) cannot get post author: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidUser,
-		})
-		return
-	}
+	vanity := auth.Vanity(req.Context())
 
-	// Check if viewer is following user
-	var viewerFollows bool
-	if req.Header.Get("authorization") != "" {
-		viewerFollows, err = database.IsUserSubscrirerTo(vanity, post.Author)
-		if err != nil {
-			log.Printf("(getPost) cannot know if user is subscriber: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInvalidRelation,
-			})
-			return
-		}
-	}
-
-	// Check if account is blocked
-	isBlocked, err := isAccountBlocked(vanity, post.Author)
+	id := mux.Param(req, "postID")
+	post, err := store.GetPost(req.Context(), id, "")
 	if err != nil {
-		log.Printf("(getPost) cannot know if users are blocked: %v", err)
-		isBlocked = false
-	}
-
-	// Check if viewer have access to the user's post
-	allowAccess := stats.Public || viewerFollows || (req.Header.Get("authorization") != "" && post.Author == vanity)
-	if isBlocked {
-		allowAccess = false
+		problem.Write(w, req, storeError(err, problem.PostNotFound))
+		return
 	}
 
-	if !allowAccess {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidPostAccess,
-		})
+	if err := checkPostAccess(req.Context(), vanity, post.Author); err != nil {
+		problem.Write(w, req, err)
 		return
 	}
 
 	var skip int
 	if req.URL.Query().Has("skip") {
-		intVar, _ := strconv.Atoi(req.URL.Query().Get("skip"))
-		skip = intVar
+		skip, err = strconv.Atoi(req.URL.Query().Get("skip"))
+		if err != nil || skip < 0 {
+			problem.Write(w, req, problem.New(problem.InvalidQuery).WithField("skip", "invalid", "must be a positive integer"))
+			return
+		}
 	}
 
 	var comments []any
 	if req.URL.Query().Has("reply") {
-		comments, err = database.GetReply(id, req.URL.Query().Get("reply"), skip*20, vanity)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorWithDatabase,
-			})
-			return
-		}
+		comments, err = store.GetReply(req.Context(), id, req.URL.Query().Get("reply"), skip*20, vanity)
 	} else {
-		comments, err = database.GetComments(id, skip*20, vanity)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorWithDatabase,
-			})
-			return
-		}
+		comments, err = store.GetComments(req.Context(), id, skip*20, vanity)
+	}
+	if err != nil {
+		problem.Write(w, req, storeError(err, problem.CommentNotFound))
+		return
 	}
 
 	if comments == nil {
@@ -174,84 +64,23 @@ func addComment(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	jsonEncoder := json.NewEncoder(w)
 
-	vanity := getVanity(req.Header.Get("authorization"))
-	if vanity == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
-		return
-	}
-
-	defer req.Body.Close()
-	body, err := io.ReadAll(req.Body)
+	vanity := auth.Vanity(req.Context())
 
+	getbody, err := validate.Decode[model.AddBody](req)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorUnableReadBody,
-		})
-		return
-	}
-
-	var getbody model.AddBody
-	json.Unmarshal(body, &getbody)
-
-	if strings.TrimSpace(getbody.Content) == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidBody,
-		})
-		return
-	}
-
-	id := strings.TrimPrefix(req.URL.Path, "/comment/")
-	post, err := database.GetPost(id, "")
-	if err != nil || post.Id == "" {
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidPost,
-		})
+		problem.Write(w, req, err)
 		return
 	}
 
-	stats, err := database.GetProfile(post.Author)
-	if err != nil || stats.Suspended {
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidUser,
-		})
+	id := mux.Param(req, "postID")
+	post, err := store.GetPost(req.Context(), id, "")
+	if err != nil {
+		problem.Write(w, req, storeError(err, problem.PostNotFound))
 		return
 	}
 
-	var allow_post_access bool
-	if stats.Public {
-		allow_post_access = true
-	} else if !stats.Public && req.Header.Get("authorization") != "" {
-		is, err := database.IsUserSubscrirerTo(post.Author, vanity)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInvalidRelation,
-			})
-			return
-		}
-
-		allow_post_access = is
-	}
-
-	if !allow_post_access {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidPostAccess,
-		})
+	if err := checkPostAccess(req.Context(), vanity, post.Author); err != nil {
+		problem.Write(w, req, err)
 		return
 	}
 
@@ -267,50 +96,39 @@ func addComment(w http.ResponseWriter, req *http.Request) {
 					Important: true,
 				},
 			)
-			helpers.Publish(post.Author, msg)
+			helpers.Publish(req.Context(), post.Author, msg)
 		}
 
 		// Create comment on database
-		comment_id, err = database.CommentPost(id, vanity, getbody.Content)
+		comment_id, err = store.CommentPost(req.Context(), id, vanity, getbody.Content)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInvalidBody,
-			})
+			problem.Write(w, req, storeError(err, problem.PostNotFound))
 			return
 		}
 	} else {
-		if !doesCommentExists(getbody.ReplyTo) {
-			w.WriteHeader(http.StatusBadRequest)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: "Invalid 'reply' ID",
-			})
+		if exists, err := store.CommentExists(req.Context(), getbody.ReplyTo); err != nil {
+			problem.Write(w, req, storeError(err, problem.CommentNotFound))
+			return
+		} else if !exists {
+			problem.Write(w, req, problem.New(problem.CommentNotFound).WithField("reply", "not_found", "replied comment doesn't exist"))
 			return
 		}
 
-		original_comment_id := isAReply(getbody.ReplyTo)
+		original_comment_id, err := store.GetOriginalComment(req.Context(), getbody.ReplyTo)
+		if err != nil {
+			problem.Write(w, req, storeError(err, problem.CommentNotFound))
+			return
+		}
+
+		// Replies to a reply are attached to the original comment
 		if original_comment_id == "" {
-			comment_id, err = database.CommentReply(getbody.ReplyTo, vanity, getbody.Content, getbody.ReplyTo)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				jsonEncoder.Encode(model.RequestError{
-					Error:   true,
-					Message: ErrorInvalidBody,
-				})
-				return
-			}
-		} else {
-			comment_id, err = database.CommentReply(getbody.ReplyTo, vanity, getbody.Content, original_comment_id)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				jsonEncoder.Encode(model.RequestError{
-					Error:   true,
-					Message: ErrorInvalidBody,
-				})
-				return
-			}
+			original_comment_id = getbody.ReplyTo
+		}
+
+		comment_id, err = store.CommentReply(req.Context(), getbody.ReplyTo, vanity, getbody.Content, original_comment_id)
+		if err != nil {
+			problem.Write(w, req, storeError(err, problem.CommentNotFound))
+			return
 		}
 	}
 
@@ -326,25 +144,12 @@ func deleteComment(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	jsonEncoder := json.NewEncoder(w)
 
-	vanity := getVanity(req.Header.Get("authorization"))
-	if vanity == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
-		return
-	}
+	vanity := auth.Vanity(req.Context())
 
-	id := strings.TrimPrefix(req.URL.Path, "/comment/")
+	id := mux.Param(req, "commentID")
 
-	_, err := database.MakeRequest("MATCH (c:Comment {id: $to})<-[:WROTE]-(u:User {name: $id}) OPTIONAL MATCH (r:Comment)-[:REPLY]-(c) WITH c, r DETACH DELETE r, c;", map[string]any{"id": vanity, "to": id})
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorWithDatabase,
-		})
+	if err := store.DeleteComment(req.Context(), vanity, id); err != nil {
+		problem.Write(w, req, storeError(err, problem.CommentNotFound))
 		return
 	}
 
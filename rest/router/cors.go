@@ -0,0 +1,148 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Gravitalia/gravitalia/config"
+)
+
+// CORS lets the allowed web origins call the API from browsers,
+// and answers their preflight requests
+type CORS struct {
+	cfg config.CORS
+	// anyOrigin is true if every origin is allowed
+	anyOrigin bool
+}
+
+// NewCORS creates the CORS middleware of the configuration
+func NewCORS(cfg config.CORS) *CORS {
+	c := &CORS{cfg: cfg}
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			c.anyOrigin = true
+		}
+	}
+
+	return c
+}
+
+// allowedOrigin checks if the origin, such as "https://www.gravitalia.com",
+// is allowed. "https://*.gravitalia.com" allows every subdomain
+func (c *CORS) allowedOrigin(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+
+	for _, allowed := range c.cfg.AllowedOrigins {
+		allowed = strings.TrimSuffix(allowed, "/")
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+
+		scheme, domain, found := strings.Cut(allowed, "://*.")
+		if found && strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(strings.ToLower(origin), "."+strings.ToLower(domain)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowedMethod checks if the method is allowed by the
+// configuration and, if set, by the Allow header of the route
+func (c *CORS) allowedMethod(method string, allow string) bool {
+	if allow != "" && !contains(strings.Split(allow, ", "), method) {
+		return false
+	}
+
+	return contains(c.cfg.AllowedMethods, method)
+}
+
+// allowedHeaders checks if every header requested
+// by the preflight request is allowed
+func (c *CORS) allowedHeaders(requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		if header = strings.TrimSpace(header); header != "" && !contains(c.cfg.AllowedHeaders, header) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Middleware adds CORS headers to the responses sent to allowed origins,
+// and answers their preflight requests on every existing route.
+// Requests of other origins get no CORS headers, so browsers block them
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+
+		if origin == "" || !c.allowedOrigin(origin) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		if c.anyOrigin && !c.cfg.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.cfg.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		method := req.Header.Get("Access-Control-Request-Method")
+		if req.Method != http.MethodOptions || method == "" {
+			if len(c.cfg.ExposedHeaders) != 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.cfg.ExposedHeaders, ", "))
+			}
+
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		// Preflight request. The router already set the methods of
+		// the path in Allow, unknown paths are left to the router
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		allow := w.Header().Get("Allow")
+		if allow == "" || !c.allowedMethod(method, allow) || !c.allowedHeaders(req.Header.Get("Access-Control-Request-Headers")) {
+			w.Header().Del("Access-Control-Allow-Origin")
+			w.Header().Del("Access-Control-Allow-Credentials")
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		methods := make([]string, 0, len(c.cfg.AllowedMethods))
+		for _, m := range c.cfg.AllowedMethods {
+			if c.allowedMethod(m, allow) {
+				methods = append(methods, m)
+			}
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(c.cfg.AllowedHeaders) != 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.cfg.AllowedHeaders, ", "))
+		}
+		if c.cfg.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.cfg.MaxAge.Seconds())))
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// contains checks if the list contains the value, ignoring case
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+
+	return false
+}
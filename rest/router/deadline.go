@@ -0,0 +1,60 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/mux"
+)
+
+// DefaultDeadline is used by routes without their own deadline
+const DefaultDeadline = 10 * time.Second
+
+// Deadlines holds the maximum duration of the requests,
+// per route name
+type Deadlines map[string]time.Duration
+
+// NewDeadlines overrides the default deadlines with the configured ones.
+// The "default" key replaces DefaultDeadline
+func NewDeadlines(configured map[string]time.Duration) Deadlines {
+	deadlines := Deadlines{
+		"default":      DefaultDeadline,
+		"posts":        time.Minute,
+		"account.data": time.Minute,
+	}
+
+	for route, deadline := range configured {
+		deadlines[route] = deadline
+	}
+
+	return deadlines
+}
+
+// get returns the deadline of a route. A route without its own
+// deadline, such as "posts.create", uses the one of its group ("posts")
+func (d Deadlines) get(route string) time.Duration {
+	return d[routeGroup(d, route)]
+}
+
+// Middleware cancels the request context once the deadline of the
+// route is exceeded, so database and gRPC calls stop with it
+func (d Deadlines) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var name string
+		if route := mux.CurrentRoute(req.Context()); route != nil {
+			name = route.Name()
+		}
+
+		deadline := d.get(name)
+		if deadline <= 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), deadline)
+		defer cancel()
+
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
@@ -0,0 +1,132 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/database"
+	"github.com/Gravitalia/gravitalia/grpc"
+	"github.com/Gravitalia/gravitalia/helpers"
+)
+
+// Statuses of the service and of its dependencies
+const (
+	StatusUp          = "up"
+	StatusDown        = "down"
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+)
+
+// dependency is a service checked by the readiness probe
+type dependency struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// CheckResult is the state of a dependency
+type CheckResult struct {
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// HealthReport is the response of the probes
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// dependencies returns every service needed to handle requests
+func dependencies() []dependency {
+	return []dependency{
+		{name: "memgraph", check: store.Ping},
+		{name: "memcached", check: func(_ context.Context) error { return database.PingMemcached() }},
+		{name: "nats", check: func(_ context.Context) error { return helpers.NatsStatus() }},
+		{name: "spinoza", check: grpc.SpinozaHealth},
+		{name: "torresix", check: grpc.TorresixHealth},
+	}
+}
+
+// isCritical checks if the service is unready without the dependency
+func isCritical(name string) bool {
+	for _, nonCritical := range conf.Health.NonCritical {
+		if nonCritical == name {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Healthz tells that the process is alive, without checking dependencies
+func Healthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HealthReport{Status: StatusUp})
+}
+
+// Readyz checks every dependency at the same time, and answers
+// 503 Service Unavailable if a critical one is down
+func Readyz(w http.ResponseWriter, req *http.Request) {
+	report := HealthReport{
+		Status: StatusUp,
+		Checks: make(map[string]CheckResult),
+	}
+
+	var (
+		mutex sync.Mutex
+		wg    sync.WaitGroup
+	)
+	for _, dep := range dependencies() {
+		wg.Add(1)
+		go func(dep dependency) {
+			defer wg.Done()
+
+			ctx := req.Context()
+			if conf.Health.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, conf.Health.Timeout)
+				defer cancel()
+			}
+
+			start := time.Now()
+			err := dep.check(ctx)
+
+			result := CheckResult{
+				Status:   StatusUp,
+				Critical: isCritical(dep.name),
+				Duration: time.Since(start).String(),
+			}
+			if err != nil {
+				result.Status = StatusDown
+				result.Error = err.Error()
+			}
+
+			mutex.Lock()
+			report.Checks[dep.name] = result
+			mutex.Unlock()
+		}(dep)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status == StatusUp {
+			continue
+		}
+
+		if result.Critical {
+			report.Status = StatusUnavailable
+			break
+		}
+		report.Status = StatusDegraded
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status == StatusUnavailable {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,327 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/auth"
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/Gravitalia/gravitalia/problem"
+	"github.com/Gravitalia/gravitalia/validate"
+)
+
+// ResponseStore keeps the responses of idempotent requests. It must
+// be shared by every instance, so retries are replayed by any replica
+type ResponseStore interface {
+	// Add saves value for ttl, and returns false if key already exists
+	Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// Get returns the value of key, or nil if it doesn't exist
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set replaces the value of key for ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes key
+	Delete(ctx context.Context, key string) error
+}
+
+// runningTTL is the longest time the first request holds its key.
+// Keys of instances stopped while running it are released after it
+const runningTTL = 5 * time.Minute
+
+// maxReplaySize is the size of the largest response saved,
+// requests with larger responses are run again
+const maxReplaySize = 64 << 10
+
+// Idempotency replays the response of the first request to retries
+// sent with the same Idempotency-Key, such as after a timeout
+type Idempotency struct {
+	ttl       time.Duration
+	responses ResponseStore
+}
+
+// NewIdempotency saves responses in the store for the configured TTL
+func NewIdempotency(cfg config.Idempotency, responses ResponseStore) *Idempotency {
+	return &Idempotency{ttl: cfg.TTL, responses: responses}
+}
+
+// savedResponse is the response to the first request with a key,
+// without status while the request is running
+type savedResponse struct {
+	// Fingerprint is the hash of the body of the request,
+	// or of its parts for multipart bodies
+	Fingerprint string      `json:"fingerprint,omitempty"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// Middleware runs the first POST, PATCH or DELETE request of a user or
+// service with an Idempotency-Key, and replays its response to retries
+// with the same key and body. Server errors are not saved, so they are
+// retried. Requests are run if the store is down
+func (i *Idempotency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header := req.Header.Get("Idempotency-Key")
+		principal := auth.FromContext(req.Context())
+		if header == "" || principal == nil || (req.Method != http.MethodPost && req.Method != http.MethodPatch && req.Method != http.MethodDelete) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		// Keys are structured field strings, sent quoted or not
+		key := strings.TrimSuffix(strings.TrimPrefix(header, `"`), `"`)
+		if len(key) == 0 || len(key) > 255 || strings.IndexFunc(key, func(r rune) bool { return r < 0x20 || r > 0x7e }) != -1 {
+			problem.Write(w, req, problem.New(problem.InvalidHeader).WithField("Idempotency-Key", "invalid", "must have 1 to 255 printable ASCII characters"))
+			return
+		}
+
+		// Keys are only shared by the same caller on the same route
+		sum := sha256.Sum256([]byte(principal.String() + "\n" + req.Method + " " + req.URL.Path + "\n" + key))
+		id := hex.EncodeToString(sum[:])
+
+		saved, err := i.load(req.Context(), id)
+		if err != nil {
+			log.Printf("(Idempotency) Cannot read response of %v: %v", id, err)
+			next.ServeHTTP(w, req)
+			return
+		}
+		if saved != nil {
+			i.replay(w, req, saved)
+			return
+		}
+
+		// The first request holds the key while it runs
+		running, _ := json.Marshal(savedResponse{})
+		added, err := i.responses.Add(req.Context(), id, running, runningTTL)
+		if err != nil {
+			log.Printf("(Idempotency) Cannot save response of %v: %v", id, err)
+			next.ServeHTTP(w, req)
+			return
+		}
+		if !added {
+			w.Header().Set("Retry-After", "1")
+			problem.Write(w, req, problem.New(problem.IdempotencyConflict))
+			return
+		}
+
+		fingerprint := newFingerprint(req.Header.Get("Content-Type"))
+		body := req.Body
+		if body == nil {
+			body = http.NoBody
+		}
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(body, fingerprint), body}
+
+		recorder := &replayWriter{ResponseWriter: w}
+		next.ServeHTTP(recorder, req)
+		if recorder.status == 0 {
+			recorder.WriteHeader(http.StatusOK)
+		}
+
+		// Bytes not read by the handler are part of the request
+		_, err = io.Copy(fingerprint, req.Body)
+		digest := fingerprint.Sum()
+
+		// The key is released, so the request can be retried
+		if err != nil || !recorder.replayable() {
+			if err := i.responses.Delete(context.Background(), id); err != nil {
+				log.Printf("(Idempotency) Cannot release %v: %v", id, err)
+			}
+			return
+		}
+
+		content, _ := json.Marshal(savedResponse{
+			Fingerprint: digest,
+			Status:      recorder.status,
+			Header:      recorder.header,
+			Body:        recorder.body.Bytes(),
+		})
+		if err := i.responses.Set(context.Background(), id, content, i.ttl); err != nil {
+			log.Printf("(Idempotency) Cannot save response of %v: %v", id, err)
+		}
+	})
+}
+
+// load returns the saved response of the key, or nil
+func (i *Idempotency) load(ctx context.Context, id string) (*savedResponse, error) {
+	content, err := i.responses.Get(ctx, id)
+	if err != nil || content == nil {
+		return nil, err
+	}
+
+	saved := &savedResponse{}
+	if err := json.Unmarshal(content, saved); err != nil {
+		return nil, err
+	}
+
+	return saved, nil
+}
+
+// replay sends the saved response if the retry has the same body
+func (i *Idempotency) replay(w http.ResponseWriter, req *http.Request, saved *savedResponse) {
+	if saved.Status == 0 {
+		w.Header().Set("Retry-After", "1")
+		problem.Write(w, req, problem.New(problem.IdempotencyConflict))
+		return
+	}
+
+	fingerprint := newFingerprint(req.Header.Get("Content-Type"))
+	if req.Body != nil {
+		if _, err := io.Copy(fingerprint, req.Body); err != nil {
+			fingerprint.Sum()
+			problem.Write(w, req, validate.BodyError(err, "cannot read body"))
+			return
+		}
+	}
+	if fingerprint.Sum() != saved.Fingerprint {
+		problem.Write(w, req, problem.New(problem.IdempotencyKeyReused).WithDetail("Idempotency-Key was sent with another body"))
+		return
+	}
+
+	// Headers of this request, such as rate limits, are kept
+	for name, values := range saved.Header {
+		if _, ok := w.Header()[name]; !ok {
+			w.Header()[name] = values
+		}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(saved.Status)
+	w.Write(saved.Body)
+}
+
+// fingerprint hashes the body of a request. Multipart bodies are
+// hashed from their parts, since retries may use another boundary
+type fingerprint struct {
+	io.Writer
+	hash hash.Hash
+	pipe *io.PipeWriter
+	done chan struct{}
+}
+
+// newFingerprint hashes bodies of the content type
+func newFingerprint(contentType string) *fingerprint {
+	f := &fingerprint{hash: sha256.New()}
+	f.Writer = f.hash
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return f
+	}
+
+	var reader *io.PipeReader
+	reader, f.pipe = io.Pipe()
+	f.Writer = f.pipe
+	f.done = make(chan struct{})
+	go func() {
+		defer close(f.done)
+
+		names := make([]string, 0, len(params))
+		for name := range params {
+			if name != "boundary" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		fmt.Fprintf(f.hash, "%s\n", mediaType)
+		for _, name := range names {
+			fmt.Fprintf(f.hash, "%s=%q\n", name, params[name])
+		}
+
+		parts := multipart.NewReader(reader, params["boundary"])
+		for {
+			part, err := parts.NextPart()
+			if err != nil {
+				// Malformed bodies are hashed as they are
+				if err != io.EOF {
+					fmt.Fprintf(f.hash, "malformed\n")
+					io.Copy(f.hash, reader)
+				}
+				break
+			}
+
+			fmt.Fprintf(f.hash, "part %q %q %q\n", part.FormName(), part.FileName(), part.Header.Get("Content-Type"))
+			content := sha256.New()
+			io.Copy(content, part)
+			fmt.Fprintf(f.hash, "%x\n", content.Sum(nil))
+		}
+
+		// The epilogue is ignored
+		io.Copy(io.Discard, reader)
+	}()
+
+	return f
+}
+
+// Sum returns the fingerprint of the body written
+func (f *fingerprint) Sum() string {
+	if f.pipe != nil {
+		f.pipe.Close()
+		<-f.done
+	}
+
+	return hex.EncodeToString(f.hash.Sum(nil))
+}
+
+// replayWriter copies the response, to save it
+type replayWriter struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	tooLarge bool
+}
+
+func (w *replayWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+		w.header = w.Header().Clone()
+		for name := range w.header {
+			if strings.HasPrefix(name, "Ratelimit-") {
+				delete(w.header, name)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *replayWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if !w.tooLarge && w.body.Len()+len(b) > maxReplaySize {
+		w.tooLarge = true
+		w.body.Reset()
+	}
+	if !w.tooLarge {
+		w.body.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the real writer
+func (w *replayWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// replayable checks if the response is final: server errors, rate
+// limits and requests closed by the client (499) are run again
+func (w *replayWriter) replayable() bool {
+	return !w.tooLarge && w.status < http.StatusInternalServerError &&
+		w.status != http.StatusTooManyRequests && w.status != 499
+}
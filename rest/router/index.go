@@ -1,29 +1,32 @@
 package router
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/Gravitalia/gravitalia/auth"
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/Gravitalia/gravitalia/database"
+	"github.com/Gravitalia/gravitalia/problem"
+	"github.com/Gravitalia/gravitalia/validate"
 )
 
 const ME = "@me"
 
-// Every possible error list
-const (
-	ErrorDataRequested         = "Data requested less than 24 hours ago"
-	ErrorExceededMaximumImages = "Maximum images exceeded"
-	ErrorInvalidList           = "Invalid list"
-	ErrorInvalidPost           = "Invalid post"
-	ErrorInvalidPostAccess     = "No access to this post"
-	ErrorInternalServerError   = "Internal server error"
-	ErrorInvalidToken          = "Invalid token"
-	ErrorInvalidBody           = "Invalid body"
-	ErrorInvalidRelation       = "Invalid relation"
-	ErrorInvalidQuery          = "Invalid query"
-	ErrorInvalidUser           = "Invalid user"
-	ErrorMethodNotAllowed      = "Method not allowed"
-	ErrorUnableReadBody        = "Unable to read body"
+var (
+	// store is the persistence layer used by every handler
+	store database.Store
+	// conf is the configuration loaded at startup
+	conf *config.Config
 )
 
+// Init sets the store and the configuration used by every handler
+func Init(s database.Store, c *config.Config) {
+	store = s
+	conf = c
+}
+
 // Every OK message reponse
 const (
 	Ok                = "OK"
@@ -35,3 +38,46 @@ const (
 func Index(w http.ResponseWriter, _ *http.Request) {
 	fmt.Fprintf(w, "OK")
 }
+
+// onBehalfOf returns the user targeted by the request: the connected
+// user, or the one set in the query parameter by internal services
+// and staff granted the scope. Actions on other accounts are audited
+func onBehalfOf(req *http.Request, parameter string, scope string) (string, error) {
+	p := auth.FromContext(req.Context())
+	vanity := req.URL.Query().Get(parameter)
+
+	if vanity != "" && !validate.Format("vanity", vanity) {
+		return "", problem.New(problem.ValidationFailed).WithField(parameter, "format", "must be a vanity")
+	}
+
+	if p.IsService() {
+		if vanity == "" {
+			return "", problem.New(problem.InvalidQuery).WithField(parameter, "required", "services must set the user")
+		}
+		return vanity, nil
+	}
+
+	if vanity == "" || vanity == auth.Vanity(req.Context()) {
+		return auth.Vanity(req.Context()), nil
+	}
+
+	granted, err := p.Can(req.Context(), scope)
+	if err != nil {
+		return "", problem.Wrap(problem.DatabaseError, err)
+	}
+	if !granted {
+		return "", problem.New(problem.Forbidden).WithDetail("only staff granted %v can act on another account", scope)
+	}
+
+	return vanity, nil
+}
+
+// storeError converts an error of the store. Missing
+// nodes are reported with the notFound code
+func storeError(err error, notFound problem.Code) error {
+	if errors.Is(err, database.ErrNotFound) {
+		return problem.Wrap(notFound, err)
+	}
+
+	return problem.Wrap(problem.DatabaseError, err)
+}
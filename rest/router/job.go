@@ -0,0 +1,66 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Gravitalia/gravitalia/auth"
+	"github.com/Gravitalia/gravitalia/job"
+	"github.com/Gravitalia/gravitalia/mux"
+	"github.com/Gravitalia/gravitalia/problem"
+)
+
+// jobs publishes posts in the background, even after a restart
+var jobs *job.Store
+
+// InitJobs sets the store of jobs, and runs them with
+// workers until ctx is done
+func InitJobs(ctx context.Context, j *job.Store, workers int) {
+	jobs = j
+	go j.Run(ctx, workers, publishPost)
+}
+
+// preferAsync checks if the client asked not to wait for the
+// result, with the Prefer header of RFC 7240
+func preferAsync(req *http.Request) bool {
+	for _, header := range req.Header.Values("Prefer") {
+		for _, preference := range strings.Split(header, ",") {
+			if strings.EqualFold(strings.TrimSpace(preference), "respond-async") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// writeJob accepts the request run by the job, whose status is
+// polled at Location. The response is asynchronous, even if the
+// client didn't prefer it
+func writeJob(w http.ResponseWriter, j *job.Job) {
+	w.Header().Set("Preference-Applied", "respond-async")
+	w.Header().Set("Location", "/posts/jobs/"+j.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(j)
+}
+
+// getJob returns the status of a job of the user,
+// with the ID of the post once it is done
+func getJob(w http.ResponseWriter, req *http.Request) {
+	j, err := jobs.Get(mux.Param(req, "jobID"), auth.Vanity(req.Context()))
+	if errors.Is(err, job.ErrNotFound) {
+		problem.Write(w, req, problem.Wrap(problem.JobNotFound, err))
+		return
+	}
+	if err != nil {
+		problem.Write(w, req, problem.Wrap(problem.Internal, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(j)
+}
@@ -1,55 +1,25 @@
 package router
 
 import (
-	"context"
 	"encoding/json"
 	"net/http"
 	"strings"
 
-	"github.com/Gravitalia/gravitalia/database"
-	"github.com/Gravitalia/gravitalia/helpers"
-	"github.com/Gravitalia/gravitalia/model"
-	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/Gravitalia/gravitalia/auth"
+	"github.com/Gravitalia/gravitalia/mux"
+	"github.com/Gravitalia/gravitalia/problem"
 )
 
-// ListHandler routes to the right function
-func ListHandler(w http.ResponseWriter, req *http.Request) {
-	if req.Method == http.MethodOptions {
-		Index(w, req)
-	} else if req.Method == http.MethodGet {
-		getList(w, req)
-	}
-}
-
 // getList allows to return a user or post list
 // based on the wanted list
 func getList(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	jsonEncoder := json.NewEncoder(w)
 
-	authToken := req.Header.Get("authorization")
-
 	// Check token
-	if authToken == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
-		return
-	}
+	vanity := auth.Vanity(req.Context())
 
-	vanity, err := helpers.CheckToken(authToken)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
-		return
-	}
-
-	id := strings.ToUpper(strings.TrimPrefix(req.URL.Path, "/list/"))
+	id := strings.ToUpper(mux.Param(req, "list"))
 	if id == "" || func() bool {
 		for _, v := range []string{"SUBSCRIBER", "SUBSCRIPTION", "BLOCK", "REQUEST"} {
 			if v == id {
@@ -58,72 +28,14 @@ func getList(w http.ResponseWriter, req *http.Request) {
 		}
 		return true
 	}() {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidList,
-		})
+		problem.Write(w, req, problem.New(problem.InvalidList).WithDetail("%q is not a list", mux.Param(req, "list")))
 		return
 	}
 
-	list := make([]any, 0)
-	ctx := context.Background()
-	if id == "SUBSCRIPTION" {
-		_, err := database.Session.ExecuteWrite(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
-			result, err := transaction.Run(ctx,
-				"MATCH (:User {name: $id})-[:SUBSCRIBER]->(u:User) RETURN DISTINCT(u.name);",
-				map[string]any{"id": vanity})
-			if err != nil {
-				return nil, err
-			}
-
-			for result.Next(ctx) {
-				if result.Record().Values[0] == nil {
-					return list, nil
-				}
-
-				list = append(list, result.Record().Values[0].(string))
-			}
-
-			return nil, result.Err()
-		})
-
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorWithDatabase,
-			})
-			return
-		}
-	} else {
-		_, err := database.Session.ExecuteWrite(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
-			result, err := transaction.Run(ctx,
-				"MATCH (u:User)-[:"+id+"]->(:User {name: $id}) RETURN DISTINCT(u.name);",
-				map[string]any{"id": vanity})
-			if err != nil {
-				return nil, err
-			}
-
-			for result.Next(ctx) {
-				if result.Record().Values[0] == nil {
-					return list, nil
-				}
-
-				list = append(list, result.Record().Values[0].(string))
-			}
-
-			return nil, result.Err()
-		})
-
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorWithDatabase,
-			})
-			return
-		}
+	list, err := store.GetList(req.Context(), vanity, id)
+	if err != nil {
+		problem.Write(w, req, storeError(err, problem.UserNotFound))
+		return
 	}
 
 	jsonEncoder.Encode(list)
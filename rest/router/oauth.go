@@ -2,17 +2,19 @@ package router
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"log"
 	"math/rand"
 	"net/http"
-	"os"
 	"time"
 
+	"github.com/Gravitalia/gravitalia/auth"
 	"github.com/Gravitalia/gravitalia/database"
 	"github.com/Gravitalia/gravitalia/model"
+	"github.com/Gravitalia/gravitalia/problem"
 
 	zipkinhttp "github.com/openzipkin/zipkin-go/middleware/http"
 )
@@ -30,8 +32,8 @@ func randomString(n int) string {
 }
 
 // makeRequest allows to make requests and return the body
-func makeRequest(zipkinClient *zipkinhttp.Client, url string, method string, reqBody io.Reader, authHeader string) ([]byte, error) {
-	req, err := http.NewRequest(method, url, reqBody)
+func makeRequest(ctx context.Context, zipkinClient *zipkinhttp.Client, url string, method string, reqBody io.Reader, authHeader string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return nil, errors.New("unable to create request")
 	}
@@ -53,6 +55,38 @@ func makeRequest(zipkinClient *zipkinhttp.Client, url string, method string, req
 	return body, nil
 }
 
+// searchRequest sends the document of a user to the search service,
+// signed with the key of this service. Search is not critical,
+// errors are only logged
+func searchRequest(ctx context.Context, zipkinClient *zipkinhttp.Client, method string, path string, document []byte) {
+	if conf.SearchAPI == "" {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, conf.SearchAPI+path, bytes.NewReader(document))
+	if err != nil {
+		log.Printf("(Search) Cannot create request to %v: %v", path, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if err := auth.Sign(req, conf.SearchService, conf.SearchKey); err != nil {
+		log.Printf("(Search) Cannot sign request to %v: %v", path, err)
+		return
+	}
+
+	response, err := zipkinClient.Do(req)
+	if err != nil {
+		log.Printf("(Search) Cannot send request to %v: %v", path, err)
+		return
+	}
+	response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		log.Printf("(Search) Request to %v got %v", path, response.Status)
+	}
+}
+
 // OAuth handles requests for connections, and will grant a Json Web Token
 // or redirect the user to the public data sharing acceptance page.
 func OAuth(zipkinClient *zipkinhttp.Client) http.HandlerFunc {
@@ -60,13 +94,11 @@ func OAuth(zipkinClient *zipkinhttp.Client) http.HandlerFunc {
 		w.Header().Set("Content-Type", "application/json")
 
 		if req.URL.Query().Has("state") && req.URL.Query().Has("code") {
-			jsonEncoder := json.NewEncoder(w)
-
 			val, err := database.Mem.Get(req.URL.Query().Get("state"))
 			if err != nil || string(val.Value) != "ok" {
 				state := randomString(24)
 				database.Set(state, "ok", 500)
-				http.Redirect(w, req, os.Getenv("OAUTH_HOST")+"/oauth2/authorize?client_id=suba&scope=identity&redirect_uri=https://api.gravitalia.com/callback&response_type=code&state="+state, http.StatusTemporaryRedirect)
+				http.Redirect(w, req, conf.OAuth.Host+"/oauth2/authorize?client_id=suba&scope=identity&redirect_uri=https://api.gravitalia.com/callback&response_type=code&state="+state, http.StatusTemporaryRedirect)
 			} else {
 				postBody, _ := json.Marshal(struct {
 					ClientId     string `json:"client_id"`
@@ -75,65 +107,50 @@ func OAuth(zipkinClient *zipkinhttp.Client) http.HandlerFunc {
 					RedirectUri  string `json:"redirect_uri"`
 				}{
 					ClientId:     "suba",
-					ClientSecret: os.Getenv("SECRET"),
+					ClientSecret: conf.OAuth.Secret,
 					Code:         req.URL.Query().Get("code"),
-					RedirectUri:  os.Getenv("REDIRECT_URL"),
+					RedirectUri:  conf.OAuth.RedirectURL,
 				})
 
-				body, err := makeRequest(zipkinClient, os.Getenv("OAUTH_API")+"/oauth2/token", "POST", bytes.NewBuffer(postBody), "")
+				body, err := makeRequest(req.Context(), zipkinClient, conf.OAuth.API+"/oauth2/token", "POST", bytes.NewBuffer(postBody), "")
 				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-					jsonEncoder.Encode(model.RequestError{
-						Error:   true,
-						Message: "Internal error:" + err.Error(),
-					})
+					problem.Write(w, req, problem.Wrap(problem.UpstreamFailed, err))
 					return
 				}
 				var data model.RequestError
 				json.Unmarshal(body, &data)
 				if data.Error {
 					log.Printf("(OAuth) Cannot get code: %v", data.Message)
-					w.WriteHeader(http.StatusBadRequest)
-					jsonEncoder.Encode(model.RequestError{
-						Error:   true,
-						Message: "Invalid code",
-					})
+					problem.Write(w, req, problem.New(problem.InvalidOAuthCode))
 					return
 				}
 
-				body, err = makeRequest(zipkinClient, os.Getenv("OAUTH_API")+"/users/@me", "GET", nil, data.Message)
+				body, err = makeRequest(req.Context(), zipkinClient, conf.OAuth.API+"/users/@me", "GET", nil, data.Message)
 				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-					jsonEncoder.Encode(model.RequestError{
-						Error:   true,
-						Message: "Internal error:" + err.Error(),
-					})
+					problem.Write(w, req, problem.Wrap(problem.UpstreamFailed, err))
 					return
 				}
-				
+
 				var user model.AuthaUser
 				json.Unmarshal(body, &user)
 				if user.Vanity == "" {
-					w.WriteHeader(http.StatusBadRequest)
-					jsonEncoder.Encode(model.RequestError{
-						Error:   true,
-						Message: "Invalid JWT",
-					})
+					problem.Write(w, req, problem.New(problem.UpstreamFailed).WithDetail("identity provider returned no user"))
 					return
 				}
 
 				// Check if account has been deleted 1 hour ago
 				val, _ = database.Mem.Get(user.Vanity + "-gd")
 				if val != nil && string(val.Value) == "ok" {
-					w.WriteHeader(http.StatusBadRequest)
-					jsonEncoder.Encode(model.RequestError{
-						Error:   true,
-						Message: "Account deleted too soon",
-					})
+					problem.Write(w, req, problem.New(problem.AccountDeletedSoon))
 					return
 				}
 
-				database.CreateUser(user.Vanity)
+				store.CreateUser(req.Context(), user.Vanity)
+
+				// Staff roles follow the flags of the identity provider
+				if err := store.SetRoles(req.Context(), user.Vanity, auth.RolesFromFlags(user.Flags, conf.RoleFlags)); err != nil {
+					log.Printf("(OAuth) Cannot update roles of %v: %v", user.Vanity, err)
+				}
 
 				// Add user into document in case of search
 				documentUser, _ := json.Marshal(struct {
@@ -145,14 +162,16 @@ func OAuth(zipkinClient *zipkinhttp.Client) http.HandlerFunc {
 					Username: user.Username,
 					Flags:    user.Flags,
 				})
-				go makeRequest(zipkinClient, os.Getenv("SEARCH_API")+"/search/add", "POST", bytes.NewBuffer(documentUser), os.Getenv("GLOBAL_AUTH"))
+				background(func(ctx context.Context) {
+					searchRequest(ctx, zipkinClient, http.MethodPost, "/search/add", documentUser)
+				})
 
 				http.Redirect(w, req, "https://www.gravitalia.com/callback?token="+data.Message, http.StatusTemporaryRedirect)
 			}
 		} else {
 			state := randomString(24)
 			database.Set(state, "ok", 500)
-			http.Redirect(w, req, os.Getenv("OAUTH_HOST")+"/oauth2/authorize?client_id=suba&scope=identity&redirect_uri=https://api.gravitalia.com/callback&response_type=code&state="+state, http.StatusTemporaryRedirect)
+			http.Redirect(w, req, conf.OAuth.Host+"/oauth2/authorize?client_id=suba&scope=identity&redirect_uri=https://api.gravitalia.com/callback&response_type=code&state="+state, http.StatusTemporaryRedirect)
 		}
 	}
 }
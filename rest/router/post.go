@@ -3,301 +3,637 @@ package router
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
-	"strings"
+	"time"
 
-	"github.com/Gravitalia/gravitalia/database"
+	"github.com/Gravitalia/gravitalia/auth"
 	"github.com/Gravitalia/gravitalia/grpc"
 	"github.com/Gravitalia/gravitalia/helpers"
+	"github.com/Gravitalia/gravitalia/job"
 	"github.com/Gravitalia/gravitalia/model"
-	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/Gravitalia/gravitalia/mux"
+	"github.com/Gravitalia/gravitalia/problem"
+	"github.com/Gravitalia/gravitalia/saga"
+	"github.com/Gravitalia/gravitalia/upload"
+	"github.com/Gravitalia/gravitalia/validate"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-const NEW = "new"
-
-const (
-	ErrorInvalidContent = "Content does not comply with our rules"
-	ErrorWithDatabase   = "Couldn't get database reponse"
-	ErrorUploading      = "Error occurs when uploading content"
-)
-
-// PostHandler re-routes to the requested handler
-func PostHandler(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/posts/")
-	if r.Method == http.MethodGet && id != NEW {
-		getPost(w, r)
-	} else if r.Method == http.MethodPost && id == NEW {
-		newPost(w, r)
-	} else if r.Method == http.MethodDelete {
-		deletePost(w, r)
-	}
-}
-
 // getPost routes to a post getter
 func getPost(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	jsonEncoder := json.NewEncoder(w)
 
-	authHeader := req.Header.Get("authorization")
-
 	// Check token
-	var vanity string
-	if authHeader != "" {
-		data, err := helpers.CheckToken(authHeader)
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInvalidToken,
-			})
-			return
-		}
-		vanity = data
-	}
+	vanity := auth.Vanity(req.Context())
 
 	// Get post
-	id := strings.TrimPrefix(req.URL.Path, "/posts/")
-	post, err := database.GetPost(id, vanity)
-	if err != nil || post.Id == "" {
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidPost,
-		})
+	id := mux.Param(req, "postID")
+	post, err := store.GetPost(req.Context(), id, vanity)
+	if err != nil {
+		problem.Write(w, req, storeError(err, problem.PostNotFound))
 		return
 	}
 
-	// Get user profile
-	stats, err := database.GetBasicProfile(post.Author)
-	if err != nil || stats.Suspended {
-		log.Printf("(getPost) cannot get post author: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidUser,
-		})
+	if err := checkPostAccess(req.Context(), vanity, post.Author); err != nil {
+		problem.Write(w, req, err)
 		return
 	}
 
-	// Check if viewer is following user
-	var viewerFollows bool
-	if authHeader != "" {
-		viewerFollows, err = database.IsUserSubscrirerTo(vanity, post.Author)
-		if err != nil {
-			log.Printf("(getPost) cannot know if user is subscriber: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInvalidRelation,
-			})
-			return
-		}
+	// Set post as viewed
+	background(func(ctx context.Context) { store.ViewPost(ctx, vanity, post.Id) })
+
+	jsonEncoder.Encode(post)
+}
+
+// checkPostAccess checks if the viewer can see the posts of the author.
+// An empty viewer is an anonymous user
+func checkPostAccess(ctx context.Context, vanity string, author string) error {
+	// Get user profile
+	stats, err := store.GetBasicProfile(ctx, author)
+	if err != nil {
+		return storeError(err, problem.UserNotFound)
+	}
+	if stats.Suspended {
+		return problem.New(problem.UserSuspended)
 	}
 
 	// Check if account is blocked
-	isBlocked, err := isAccountBlocked(vanity, post.Author)
+	isBlocked, err := store.IsBlocked(ctx, vanity, author)
 	if err != nil {
-		log.Printf("(getPost) cannot know if users are blocked: %v", err)
+		log.Printf("(checkPostAccess) cannot know if users are blocked: %v", err)
 		isBlocked = false
 	}
-
-	// Check if viewer have access to the user's post
-	allowAccess := stats.Public || viewerFollows || (authHeader != "" && post.Author == vanity)
 	if isBlocked {
-		allowAccess = false
+		return problem.New(problem.PostAccessDenied)
 	}
 
-	if !allowAccess {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidPostAccess,
-		})
-		return
+	if stats.Public || (vanity != "" && vanity == author) {
+		return nil
 	}
 
-	// Set post as viewed
-	go database.MakeRequest("MATCH (a:User {name: $id}) MATCH (b:Post {id: $to}) MERGE (a)-[:VIEW]->(b);",
-		map[string]any{"id": vanity, "to": post.Id})
+	// Check if viewer is following user
+	if vanity != "" {
+		viewerFollows, err := store.IsUserSubscrirerTo(ctx, vanity, author)
+		if err != nil {
+			return storeError(err, problem.UserNotFound)
+		}
 
-	jsonEncoder.Encode(post)
+		if viewerFollows {
+			return nil
+		}
+	}
+
+	return problem.New(problem.PostAccessDenied)
 }
 
-// newPost routes allows to create a new post
+// newPost routes allows to create a new post, from a multipart form
+// with a description field and images files, or from a JSON PostBody.
+// The post is created by a saga, whose failures leave nothing behind.
+// With "Prefer: respond-async", the post is published by a job
 func newPost(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	jsonEncoder := json.NewEncoder(w)
 
 	// Checks authorization
-	if req.Header.Get("Authorization") == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
+	p := &postCreation{vanity: auth.Vanity(req.Context())}
+
+	if preferAsync(req) {
+		// Images are moderated by the job
+		body, err := readPost(req, p.vanity, func([]byte) {})
+		if err != nil {
+			problem.Write(w, req, err)
+			return
+		}
+		p.body = body
+
+		j, err := enqueuePost(p)
+		if err != nil {
+			problem.Write(w, req, err)
+			return
+		}
+		writeJob(w, j)
 		return
 	}
 
-	vanity, err := helpers.CheckToken(req.Header.Get("Authorization"))
+	// Define channels. Images are moderated as soon as they are received
+	tag := make(chan string, 1)
+	verdicts := make([]chan verdict, 0, model.MaxImages)
+	moderate := func(image []byte) {
+		if len(verdicts) == 0 {
+			go func() {
+				res, _ := grpc.TagImage(req.Context(), 0, image)
+				tag <- res
+			}()
+		}
+
+		v := make(chan verdict, 1)
+		verdicts = append(verdicts, v)
+		go func() {
+			nude, err := isNude(req.Context(), image)
+			v <- verdict{nude: nude, err: err}
+		}()
+	}
+
+	err := saga.Run(req.Context(),
+		saga.Step{Name: "validate", Do: func(_ context.Context) error {
+			var err error
+			p.body, err = readPost(req, p.vanity, moderate)
+			return err
+		}},
+		saga.Step{Name: "moderate", Do: func(_ context.Context) error {
+			// Posts are never published before their images are moderated
+			var unavailable error
+			for _, v := range verdicts {
+				result := <-v
+				if result.err != nil {
+					unavailable = result.err
+				} else if result.nude {
+					return problem.New(problem.ProhibitedContent)
+				}
+			}
+			if unavailable != nil {
+				return problem.Wrap(problem.ModerationUnavailable, unavailable)
+			}
+
+			p.tag = <-tag
+			return nil
+		}},
+		p.upload(),
+		p.persist(),
+		p.notify(),
+	)
+
+	if err != nil && problem.From(err).Code == problem.ModerationUnavailable && conf.Moderation.Unavailable == "queue" {
+		// The job waits for Torresix
+		j, err := enqueuePost(p)
+		if err != nil {
+			problem.Write(w, req, err)
+			return
+		}
+		writeJob(w, j)
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
+		problem.Write(w, req, err)
 		return
 	}
+	p.done()
 
-	// Read body
-	defer req.Body.Close()
-	body, err := io.ReadAll(req.Body)
+	// Success reponse with post ID
+	jsonEncoder.Encode(model.RequestError{
+		Error:   false,
+		Message: p.id,
+	})
+}
 
+// readPost reads the body, then the images uploaded before.
+// Each image is given to received once read
+func readPost(req *http.Request, vanity string, received func(image []byte)) (model.PostBody, error) {
+	var body model.PostBody
+	var err error
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		body, err = readPostForm(req, received)
+	} else if body, err = validate.Decode[model.PostBody](req); err == nil {
+		if err = checkImageCount(body); err == nil {
+			for _, image := range body.Images {
+				received(image)
+			}
+		}
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorUnableReadBody,
-		})
-		return
+		return body, err
 	}
 
-	var getbody model.PostBody
-	if err = json.Unmarshal(body, &getbody); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidBody,
-		})
-		return
+	// Images uploaded before are added to the sent ones
+	for i, id := range body.Uploads {
+		image, err := uploads.Read(id, vanity)
+		if errors.Is(err, upload.ErrNotFound) {
+			return body, problem.Wrap(problem.ValidationFailed, err).WithField(fmt.Sprintf("uploads[%d]", i), "not_found", "upload doesn't exist or expired")
+		}
+		if errors.Is(err, upload.ErrIncomplete) {
+			return body, problem.Wrap(problem.ValidationFailed, err).WithField(fmt.Sprintf("uploads[%d]", i), "incomplete", "upload is not complete")
+		}
+		if err != nil {
+			return body, problem.Wrap(problem.Internal, err)
+		}
+
+		body.Images = append(body.Images, image)
+		received(image)
 	}
 
-	if len(getbody.Images) > 5 {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorExceededMaximumImages,
-		})
-		return
+	return body, nil
+}
+
+// verdict is the result of the moderation of an image
+type verdict struct {
+	nude bool
+	err  error
+}
+
+// isNude asks Torresix if the image is prohibited
+func isNude(ctx context.Context, image []byte) (bool, error) {
+	res, err := grpc.TagImage(ctx, 1, image)
+	return res == "nude", err
+}
+
+// enqueuePost saves the post in a job, published in the background
+func enqueuePost(p *postCreation) (*job.Job, error) {
+	payload, err := json.Marshal(p.body)
+	if err != nil {
+		return nil, problem.Wrap(problem.Internal, err)
+	}
+
+	j, err := jobs.Create(p.vanity, payload)
+	if err != nil {
+		return nil, problem.Wrap(problem.Internal, err)
 	}
 
-	// Define channels
-	tag := make(chan string)
-	isNude := make([]chan bool, len(getbody.Images))
+	return j, nil
+}
+
+// publishPost is the job publishing a post once its images are
+// moderated. While Torresix or Spinoza is unavailable, the job is
+// retried until conf.Moderation.QueueFor is over if posts are queued
+func publishPost(ctx context.Context, j *job.Job, payload []byte) (string, error) {
+	p := &postCreation{vanity: j.Owner, job: j}
+	if err := json.Unmarshal(payload, &p.body); err != nil {
+		return "", err
+	}
 
-	go func() {
-		res, _ := grpc.TagImage(0, getbody.Images[0])
-		tag <- res
-	}()
+	// The post was created by an attempt stopped before the end
+	if j.Checkpoint != "" {
+		p.id = j.Checkpoint
+		p.notify().Do(ctx)
+		p.done()
 
-	for i, image := range getbody.Images {
-		isNude[i] = make(chan bool)
-		go func(i int, image []byte) {
-			res, _ := grpc.TagImage(1, image)
-			isNude[i] <- res == "nude"
-		}(i, image)
+		return p.id, nil
 	}
 
-	// Checks if content is prohibited
-	for _, isNudeChan := range isNude {
-		if <-isNudeChan {
-			w.WriteHeader(http.StatusBadRequest)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInvalidContent,
-			})
-			return
+	tag, nude, err := moderateImages(ctx, p.body.Images)
+	if err != nil {
+		err = problem.Wrap(problem.ModerationUnavailable, err)
+		if queued(j) {
+			return "", retryLater(j, err)
 		}
+		return "", p.reject(ctx, err)
+	}
+	if nude {
+		return "", p.reject(ctx, problem.New(problem.ProhibitedContent))
 	}
 
-	// Publish contents
-	hashChans := make([]chan string, len(getbody.Images))
-	for i, image := range getbody.Images {
-		hashChans[i] = make(chan string)
-		go func(i int, image []byte) {
-			res, err := grpc.UploadImage(image)
-			if err != nil {
-				log.Println(err)
-				w.WriteHeader(http.StatusInternalServerError)
-				jsonEncoder.Encode(model.RequestError{
-					Error:   true,
-					Message: ErrorUploading,
+	p.tag = tag
+	if err := saga.Run(ctx, p.upload(), p.persist(), p.notify()); err != nil {
+		// The uploaded images are deleted, so the next attempt starts over
+		if unavailable(err) && queued(j) {
+			return "", retryLater(j, err)
+		}
+		return "", p.reject(ctx, err)
+	}
+	p.done()
+
+	return p.id, nil
+}
+
+// queued checks if the job can still wait for unavailable services
+func queued(j *job.Job) bool {
+	return conf.Moderation.Unavailable == "queue" && time.Since(j.CreatedAt) < conf.Moderation.QueueFor
+}
+
+// retryLater retries the job after 1s, 2s, 4s... up to a minute
+func retryLater(j *job.Job, err error) error {
+	wait := time.Minute
+	if j.Attempts < 7 {
+		wait = time.Second << (j.Attempts - 1)
+	}
+
+	return job.Retry(wait, err)
+}
+
+// unavailable checks if err comes from a service which
+// can't be reached, and may be back later
+func unavailable(err error) bool {
+	return errors.Is(err, grpc.ErrCircuitOpen) || status.Code(err) == codes.Unavailable
+}
+
+// moderateImages returns the tag of the first image,
+// and whether an image is prohibited
+func moderateImages(ctx context.Context, images [][]byte) (string, bool, error) {
+	var tag string
+	if len(images) > 0 {
+		tag, _ = grpc.TagImage(ctx, 0, images[0])
+	}
+
+	for _, image := range images {
+		nude, err := isNude(ctx, image)
+		if err != nil || nude {
+			return tag, nude, err
+		}
+	}
+
+	return tag, false, nil
+}
+
+// postCreation is the state of a post being created,
+// shared by the steps of its saga
+type postCreation struct {
+	vanity string
+	body   model.PostBody
+	tag    string
+	// hashes of the uploaded images, empty for the other ones
+	hashes []string
+	id     string
+	// job publishing the post, if any
+	job *job.Job
+}
+
+// upload sends every image to Spinoza at once. The first failure
+// cancels the other uploads, and uploaded images are deleted
+func (p *postCreation) upload() saga.Step {
+	return saga.Step{
+		Name: "upload",
+		Do: func(ctx context.Context) error {
+			p.hashes = make([]string, len(p.body.Images))
+
+			g, ctx := errgroup.WithContext(ctx)
+			for i, image := range p.body.Images {
+				i, image := i, image
+				g.Go(func() error {
+					hash, err := grpc.UploadImage(ctx, image)
+					if err != nil {
+						return problem.Wrap(problem.UploadFailed, err)
+					}
+					p.hashes[i] = hash
+					return nil
 				})
-				return
 			}
 
-			hashChans[i] <- res
-		}(i, image)
+			return g.Wait()
+		},
+		Undo: func(ctx context.Context) error {
+			uploaded := make([]string, 0, len(p.hashes))
+			for _, hash := range p.hashes {
+				if hash != "" {
+					uploaded = append(uploaded, hash)
+				}
+			}
+
+			// Images are stored by hash, so the same
+			// image may be used by another post
+			unused, err := store.UnusedMedia(ctx, uploaded)
+			if err != nil {
+				return problem.Wrap(problem.DatabaseError, err)
+			}
+
+			var errs []error
+			for _, hash := range unused {
+				if _, err := grpc.DeleteImage(ctx, hash); err != nil {
+					errs = append(errs, fmt.Errorf("image %v: %w", hash, err))
+				}
+			}
+
+			return errors.Join(errs...)
+		},
 	}
+}
+
+// persist creates the post with the uploaded images
+func (p *postCreation) persist() saga.Step {
+	return saga.Step{
+		Name: "persist",
+		Do: func(ctx context.Context) error {
+			id, err := store.CreatePost(ctx, p.vanity, p.tag, p.body.Description, p.hashes)
+			if err != nil {
+				return storeError(err, problem.UserNotFound)
+			}
+
+			p.id = id
 
-	// Convert string channel to string
-	hash := make([]string, len(getbody.Images))
-	for i, hashChan := range hashChans {
-		hash[i] = <-hashChan
+			// Resumed jobs must not create the post again
+			if p.job != nil {
+				if err := jobs.Checkpoint(p.job, id); err != nil {
+					return problem.Wrap(problem.Internal, err)
+				}
+			}
+
+			return nil
+		},
+		Undo: func(ctx context.Context) error {
+			if p.id == "" {
+				return nil
+			}
+
+			if _, err := store.DeletePost(ctx, p.vanity, p.id); err != nil {
+				return err
+			}
+			if p.job != nil {
+				return jobs.Checkpoint(p.job, "")
+			}
+
+			return nil
+		},
 	}
+}
 
-	id, err := database.CreatePost(vanity, <-tag, getbody.Description, hash)
-	if err != nil {
-		log.Printf("(newPost) cannot create post in database: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorWithDatabase,
-		})
-		return
+// notify tells the author that the post is published, such as on
+// other devices or once a queued post is moderated. Notifications
+// are not guaranteed, they never fail the creation
+func (p *postCreation) notify() saga.Step {
+	return saga.Step{
+		Name: "notify",
+		Do: func(ctx context.Context) error {
+			msg, _ := json.Marshal(
+				model.Message{
+					Type:      "post_published",
+					From:      p.vanity,
+					To:        p.id,
+					Job:       p.jobID(),
+					Important: false,
+				},
+			)
+			helpers.Publish(ctx, p.vanity, msg)
+
+			return nil
+		},
 	}
+}
 
-	// Success reponse with post ID
-	jsonEncoder.Encode(model.RequestError{
-		Error:   false,
-		Message: id,
+// reject tells the author that the post of the job is not published,
+// because its images are prohibited or the creation failed
+func (p *postCreation) reject(ctx context.Context, err error) error {
+	kind := "post_failed"
+	if problem.From(err).Code == problem.ProhibitedContent {
+		kind = "post_rejected"
+	}
+
+	msg, _ := json.Marshal(
+		model.Message{
+			Type:      kind,
+			From:      p.vanity,
+			Job:       p.jobID(),
+			Important: false,
+		},
+	)
+	helpers.Publish(ctx, p.vanity, msg)
+
+	return err
+}
+
+// jobID returns the ID of the job publishing the post, if any
+func (p *postCreation) jobID() string {
+	if p.job == nil {
+		return ""
+	}
+
+	return p.job.ID
+}
+
+// done deletes the uploads of the published post, which are no
+// longer needed. They are kept until the saga succeeds, so a
+// failed post can be sent again
+func (p *postCreation) done() {
+	background(func(ctx context.Context) {
+		for _, id := range p.body.Uploads {
+			if err := uploads.Delete(ctx, id, p.vanity); err != nil {
+				log.Printf("(newPost) cannot delete upload %v: %v", id, err)
+			}
+		}
 	})
 }
 
-// deletePost delete wanted post if related to connected user
-func deletePost(w http.ResponseWriter, req *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	jsonEncoder := json.NewEncoder(w)
+// maxDescriptionSize is the maximum size of the description
+// field, whose length is then checked in graphemes
+const maxDescriptionSize = 32 << 10
 
-	vanity := getVanity(req.Header.Get("authorization"))
-	if vanity == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
-		return
-	}
+// readPostForm streams the parts of a multipart post: a description
+// field and up to MaxImages "images" files. Each image is given to
+// received once read, while the next ones are still being sent
+func readPostForm(req *http.Request, received func(image []byte)) (model.PostBody, error) {
+	var body model.PostBody
 
-	id := strings.TrimPrefix(req.URL.Path, "/posts/")
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return body, problem.Wrap(problem.InvalidBody, err).WithDetail("body is not a valid multipart form")
+	}
 
-	ctx := context.Background()
-	if _, err := database.Session.ExecuteWrite(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
-		result, err := transaction.Run(ctx,
-			"MATCH (p:Post {id: $to})<-[:CREATE]-(:User {name: $id}) MATCH (p)-[:CONTAINS]-(m:Media) OPTIONAL MATCH (c:Comment)-[:COMMENT]-(p) DETACH DELETE p, c WITH m OPTIONAL MATCH (m:Media)-[r:CONTAINS]-(:Post) WITH m, COUNT(r) as count WHERE count = 0 WITH m, m.hash as hash DETACH DELETE m RETURN hash;",
-			map[string]any{"id": vanity, "to": id})
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return nil, err
+			return body, validate.BodyError(err, "body is not a valid multipart form")
 		}
 
-		for result.Next(ctx) {
-			_, err := grpc.DeleteImage(result.Record().Values[0].(string))
+		switch part.FormName() {
+		case "description":
+			description, err := readPart(part, maxDescriptionSize)
+			if err != nil {
+				return body, validate.BodyError(err, "cannot read description")
+			}
+			if description == nil {
+				return body, problem.New(problem.ValidationFailed).WithField("description", "too_large", fmt.Sprintf("must not exceed %d bytes", maxDescriptionSize))
+			}
+			body.Description = string(description)
+		case "uploads":
+			id, err := readPart(part, 64)
+			if err != nil {
+				return body, validate.BodyError(err, "cannot read upload")
+			}
+			if id == nil {
+				return body, problem.New(problem.ValidationFailed).WithField(fmt.Sprintf("uploads[%d]", len(body.Uploads)), "too_large", "must not exceed 64 bytes")
+			}
+			body.Uploads = append(body.Uploads, string(id))
+			if err := checkImageCount(body); err != nil {
+				return body, err
+			}
+		case "images":
+			if len(body.Images)+len(body.Uploads) == model.MaxImages {
+				return body, problem.New(problem.ValidationFailed).WithField("images", "max", fmt.Sprintf("must have at most %d items", model.MaxImages))
+			}
+
+			image, err := readPart(part, model.MaxImageSize)
+			if err != nil {
+				return body, validate.BodyError(err, "cannot read image")
+			}
+			if image == nil {
+				return body, problem.New(problem.ValidationFailed).WithField(fmt.Sprintf("images[%d]", len(body.Images)), "too_large", fmt.Sprintf("must not exceed %d bytes", model.MaxImageSize))
+			}
+			if len(image) == 0 {
+				return body, problem.New(problem.ValidationFailed).WithField(fmt.Sprintf("images[%d]", len(body.Images)), "required", "must not be empty")
+			}
 
-			return nil, err
+			body.Images = append(body.Images, image)
+			received(image)
 		}
 
-		return nil, result.Err()
-	}); err != nil {
-		log.Printf("(deletePost) %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorWithDatabase,
-		})
+		part.Close()
+	}
+
+	if err := validate.Check(body); err != nil {
+		return body, err
+	}
+
+	return body, checkImageCount(body)
+}
+
+// checkImageCount checks that the post has between one
+// and MaxImages images, sent or uploaded before
+func checkImageCount(body model.PostBody) error {
+	count := len(body.Images) + len(body.Uploads)
+	if count == 0 {
+		return problem.New(problem.ValidationFailed).WithField("images", "required", "images or uploads are required")
+	}
+	if count > model.MaxImages {
+		return problem.New(problem.ValidationFailed).WithField("images", "max", fmt.Sprintf("must have at most %d items", model.MaxImages))
+	}
+
+	return nil
+}
+
+// readPart reads the part, or returns nil if it is larger than limit
+func readPart(part *multipart.Part, limit int64) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(part, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > limit {
+		return nil, nil
+	}
+
+	return content, nil
+}
+
+// deletePost delete wanted post if related to connected user
+func deletePost(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonEncoder := json.NewEncoder(w)
+
+	vanity := auth.Vanity(req.Context())
+
+	id := mux.Param(req, "postID")
+
+	hashes, err := store.DeletePost(req.Context(), vanity, id)
+	if err != nil {
+		problem.Write(w, req, storeError(err, problem.PostNotFound))
 		return
 	}
 
+	// Remove medias no longer used by any post
+	for _, hash := range hashes {
+		if _, err := grpc.DeleteImage(req.Context(), hash); err != nil {
+			log.Printf("(deletePost) cannot delete image %v: %v", hash, err)
+		}
+	}
+
 	jsonEncoder.Encode(model.RequestError{
 		Error:   false,
 		Message: Ok,
@@ -0,0 +1,195 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/auth"
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/Gravitalia/gravitalia/mux"
+	"github.com/Gravitalia/gravitalia/problem"
+)
+
+// Counter counts the requests of a key. Counters must be shared
+// by every instance for limits to hold across replicas
+type Counter interface {
+	// Increment adds a request to the counter of key, created
+	// for ttl if needed, and returns the number of requests
+	Increment(ctx context.Context, key string, ttl time.Duration) (uint64, error)
+	// Count returns the number of requests of key, 0 if it doesn't exist
+	Count(ctx context.Context, key string) (uint64, error)
+}
+
+// RateLimiter limits the number of requests of each caller,
+// per route or group of routes, in fixed windows
+type RateLimiter struct {
+	limits     map[string]config.Rate
+	counter    Counter
+	trustProxy bool
+}
+
+// NewRateLimiter overrides the default limits with the configured ones.
+// The "default" key is used by routes without limit
+func NewRateLimiter(cfg config.RateLimit, counter Counter) *RateLimiter {
+	limits := map[string]config.Rate{
+		"default": {Requests: 600, Window: time.Minute},
+		// Changes of relations share a limit, whichever route is used
+		"relation":        {Requests: 60, Window: time.Minute},
+		"relation.exists": {Requests: 600, Window: time.Minute},
+		"comment.create":  {Requests: 20, Window: time.Minute},
+		"posts.create":    {Requests: 20, Window: time.Hour},
+		// Requests rejected with 401 per IP address, before
+		// the caller is known, so tokens can't be guessed
+		"authentication": {Requests: 20, Window: time.Minute},
+		// Probes and metrics are called by the infrastructure
+		"healthz": {},
+		"readyz":  {},
+		"metrics": {},
+	}
+
+	for route, rate := range cfg.Limits {
+		limits[route] = rate
+	}
+
+	return &RateLimiter{limits: limits, counter: counter, trustProxy: cfg.TrustProxy}
+}
+
+// Middleware counts the request, and rejects it once the caller has
+// exceeded the limit of the route. RateLimit-* headers tell clients
+// how many requests remain. Requests are allowed if counters are down
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var name string
+		if route := mux.CurrentRoute(req.Context()); route != nil {
+			name = route.Name()
+		}
+
+		group := routeGroup(l.limits, name)
+		rate := l.limits[group]
+		if rate.Requests == 0 || rate.Window <= 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		now := time.Now()
+		window := now.Truncate(rate.Window)
+		key := fmt.Sprintf("rl-%v-%v-%d", group, l.caller(req), window.Unix())
+
+		count, err := l.counter.Increment(req.Context(), key, rate.Window)
+		if err != nil {
+			log.Printf("(RateLimiter) Cannot count requests of %v: %v", key, err)
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		var remaining uint64
+		if count < rate.Requests {
+			remaining = rate.Requests - count
+		}
+		reset := strconv.Itoa(int(window.Add(rate.Window).Sub(now).Seconds() + 1))
+
+		w.Header().Set("RateLimit-Limit", strconv.FormatUint(rate.Requests, 10))
+		w.Header().Set("RateLimit-Remaining", strconv.FormatUint(remaining, 10))
+		w.Header().Set("RateLimit-Reset", reset)
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rate.Requests, int(rate.Window.Seconds())))
+
+		if count > rate.Requests {
+			w.Header().Set("Retry-After", reset)
+			problem.Write(w, req, problem.New(problem.RateLimited).WithDetail("%v requests allowed every %v", rate.Requests, rate.Window))
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Authentication runs before the authenticator, and rejects the
+// requests of an IP address once too many of its requests were
+// unauthorized, with a missing or invalid token or signature
+func (l *RateLimiter) Authentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rate := l.limits["authentication"]
+		if rate.Requests == 0 || rate.Window <= 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		now := time.Now()
+		window := now.Truncate(rate.Window)
+		key := fmt.Sprintf("rl-authentication-%v-%d", l.address(req), window.Unix())
+
+		count, err := l.counter.Count(req.Context(), key)
+		if err != nil {
+			log.Printf("(RateLimiter) Cannot count requests of %v: %v", key, err)
+			next.ServeHTTP(w, req)
+			return
+		}
+		if count >= rate.Requests {
+			w.Header().Set("Retry-After", strconv.Itoa(int(window.Add(rate.Window).Sub(now).Seconds()+1)))
+			problem.Write(w, req, problem.New(problem.RateLimited).WithDetail("%v unauthorized requests allowed every %v", rate.Requests, rate.Window))
+			return
+		}
+
+		recorder := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(recorder, req)
+		if recorder.status == http.StatusUnauthorized {
+			if _, err := l.counter.Increment(req.Context(), key, rate.Window); err != nil {
+				log.Printf("(RateLimiter) Cannot count requests of %v: %v", key, err)
+			}
+		}
+	})
+}
+
+// statusWriter keeps the status of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap allows http.ResponseController to reach the real writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// caller identifies who sends the request: the user or the
+// service if authenticated, otherwise the IP address
+func (l *RateLimiter) caller(req *http.Request) string {
+	if p := auth.FromContext(req.Context()); p != nil {
+		return p.String()
+	}
+
+	return l.address(req)
+}
+
+// address identifies the IP address sending the request
+func (l *RateLimiter) address(req *http.Request) string {
+	if l.trustProxy {
+		// The load balancer appends the address of its client
+		if forwarded := req.Header.Values("X-Forwarded-For"); len(forwarded) != 0 {
+			addresses := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := net.ParseIP(strings.TrimSpace(addresses[len(addresses)-1])); ip != nil {
+				return "ip:" + ip.String()
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return "ip:" + req.RemoteAddr
+	}
+
+	return "ip:" + host
+}
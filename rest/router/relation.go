@@ -1,180 +1,77 @@
 package router
 
 import (
+	"context"
 	"encoding/json"
-	"io"
 	"log"
 	"net/http"
 	"strings"
 
-	"github.com/Gravitalia/gravitalia/database"
+	"github.com/Gravitalia/gravitalia/auth"
 	"github.com/Gravitalia/gravitalia/helpers"
 	"github.com/Gravitalia/gravitalia/model"
+	"github.com/Gravitalia/gravitalia/mux"
+	"github.com/Gravitalia/gravitalia/problem"
+	"github.com/Gravitalia/gravitalia/validate"
 )
 
-// RelationHandler re-routes to the requested handler
-func RelationHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		Exists(w, r)
-	} else if r.Method == http.MethodPost {
-		Relation(w, r)
-	}
-}
+// relations can be set by users, REQUEST is set when
+// subscribing to a private account
+var relations = []string{"LIKE", "SUBSCRIBER", "BLOCK", "LOVE", "VIEW"}
 
 // Relation is a route for allowing users to subscribe to each other
-// or like posts, depending on the chosen route
+// or like posts, depending on the chosen route. It deletes the relation
+// if it exists. Deprecated in favour of PUT and DELETE, which can be
+// retried, it is kept for older clients
 func Relation(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	jsonEncoder := json.NewEncoder(w)
 
 	// Check valid relation
-	relation := strings.ToUpper(strings.TrimPrefix(req.URL.Path, "/relation/"))
-	if relation == "" || func() bool {
-		for _, v := range []string{"LIKE", "SUBSCRIBER", "BLOCK", "LOVE", "VIEW"} {
-			if v == relation {
-				return false
-			}
-		}
-		return true
-	}() {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidRelation,
-		})
-		return
-	}
-
-	// Check token
-	var vanity string
-	if req.Header.Get("Authorization") == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
-		return
-	}
-
-	data, err := helpers.CheckToken(req.Header.Get("Authorization"))
+	relation, err := relationParam(req, relations)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
+		problem.Write(w, req, err)
 		return
 	}
-	vanity = data
-
-	defer req.Body.Close()
-	body, err := io.ReadAll(req.Body)
 
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorUnableReadBody,
-		})
-		return
-	}
+	// Check token
+	vanity := auth.Vanity(req.Context())
 
-	var getbody model.SetBody
-	err = json.Unmarshal(body, &getbody)
+	getbody, err := validate.Decode[model.SetBody](req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidBody,
-		})
+		problem.Write(w, req, err)
 		return
 	}
 
-	if getbody.Id == "" || vanity == getbody.Id {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidBody,
-		})
+	if vanity == getbody.Id {
+		problem.Write(w, req, problem.New(problem.ValidationFailed).WithField("id", "invalid", "relation with yourself is not allowed"))
 		return
 	}
 
-	var content string
-	switch relation {
-	case "SUBSCRIBER", "BLOCK", "REQUEST":
-		content = "User"
-	case "LIKE", "VIEW":
-		content = "Post"
-	case "LOVE":
-		content = "Comment"
-	}
-
-	var identifier string
-	if content == "User" {
-		identifier = "name"
-	} else {
-		identifier = "id"
-	}
-
 	// Remove subscription relations
 	if relation == "BLOCK" {
-		_, err = database.MakeRequest("MATCH (:User {name: $id})-[r:SUBSCRIBER]-(:User {name: $to}) DELETE r;",
-			map[string]any{"id": vanity, "to": getbody.Id})
-		if err != nil {
-			log.Printf("(Relation) Cannot remove subscription: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorWithDatabase,
-			})
+		if err := store.RemoveSubscriptions(req.Context(), vanity, getbody.Id); err != nil {
+			problem.Write(w, req, storeError(err, problem.UserNotFound))
 			return
 		}
 	}
 
 	if relation == "SUBSCRIBER" {
-		// Check if account is blocked
-		isBlocked, err := isAccountBlocked(vanity, getbody.Id)
+		public, err := checkSubscription(req.Context(), vanity, getbody.Id)
 		if err != nil {
-			log.Printf("(Relation) Cannot know if users are blocked: %v", err)
-			isBlocked = false
-		}
-
-		if isBlocked {
-			w.WriteHeader(http.StatusConflict)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInvalidUser,
-			})
+			problem.Write(w, req, err)
 			return
 		}
 
-		// Check if account is private
-		stats, err := database.GetBasicProfile(getbody.Id)
-		if err != nil || stats.Suspended {
-			log.Printf("(Relation) cannot get targeted user: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInvalidUser,
-			})
-			return
-		}
-
-		if !stats.Public {
+		if !public {
 			// If sub relation exists, remove it
-			res, err := database.MakeRequest("MATCH (a:User {name: $id}) MATCH (b:User {name: $to}) OPTIONAL MATCH (a)-[r:SUBSCRIBER]->(b) WITH r, a, b WHERE r IS NOT NULL DELETE r RETURN NOT(r IS NULL);",
-				map[string]any{"id": vanity, "to": getbody.Id})
+			deleted, err := store.DeleteRelation(req.Context(), vanity, getbody.Id, "SUBSCRIBER")
 			if err != nil {
-				log.Printf("(Relation) Cannot remove sub private: %v", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				jsonEncoder.Encode(model.RequestError{
-					Error:   true,
-					Message: ErrorWithDatabase,
-				})
+				problem.Write(w, req, storeError(err, problem.UserNotFound))
 				return
 			}
 
-			if res != nil && res.(bool) {
+			if deleted {
 				jsonEncoder.Encode(model.RequestError{
 					Error:   false,
 					Message: OkDeletedRelation,
@@ -183,35 +80,20 @@ func Relation(w http.ResponseWriter, req *http.Request) {
 			}
 
 			// Remove or create sub request
-			res, err = database.MakeRequest("MATCH (a:User {name: $id}) MATCH (b:User {name: $to}) OPTIONAL MATCH (a)-[r:REQUEST]->(b) DELETE r FOREACH (x IN CASE WHEN r IS NULL THEN [1] ELSE [] END |	CREATE (a)-[:REQUEST]->(b)	) RETURN NOT(r IS NULL);",
-				map[string]any{"id": vanity, "to": getbody.Id})
+			deleted, err = store.ToggleRelation(req.Context(), vanity, getbody.Id, "REQUEST")
 			if err != nil {
-				log.Printf("(Relation) Got an error : %v", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				jsonEncoder.Encode(model.RequestError{
-					Error:   true,
-					Message: ErrorWithDatabase,
-				})
+				problem.Write(w, req, storeError(err, problem.UserNotFound))
 				return
 			}
 
-			if res.(bool) {
+			if deleted {
 				jsonEncoder.Encode(model.RequestError{
 					Error:   false,
 					Message: OkDeletedRelation,
 				})
 				return
 			} else {
-				// Notify target that requester wants to follow him
-				msg, _ := json.Marshal(
-					model.Message{
-						Type:      "request_subscription",
-						From:      vanity,
-						To:        getbody.Id,
-						Important: true,
-					},
-				)
-				helpers.Nats.Publish(getbody.Id, msg)
+				notifyRelation(req.Context(), vanity, "REQUEST", getbody.Id)
 
 				jsonEncoder.Encode(model.RequestError{
 					Error:   false,
@@ -223,49 +105,21 @@ func Relation(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Create or delete asked relation
-	res, err := database.MakeRequest("MATCH (a:User {name: $id}) MATCH (b:"+content+"{"+identifier+": $to}) OPTIONAL MATCH (a)-[r:"+relation+"]->(b) DELETE r FOREACH (x IN CASE WHEN r IS NULL THEN [1] ELSE [] END |	CREATE (a)-[:"+relation+"]->(b)	) RETURN NOT(r IS NULL);",
-		map[string]any{"id": vanity, "to": getbody.Id})
+	deleted, err := store.ToggleRelation(req.Context(), vanity, getbody.Id, relation)
 	if err != nil {
-		log.Printf("(Relation) Got an error: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorWithDatabase,
-		})
+		problem.Write(w, req, storeError(err, notFoundCode(relation)))
 		return
 	}
 
-	if res.(bool) {
+	if deleted {
 		jsonEncoder.Encode(model.RequestError{
 			Error:   false,
 			Message: OkDeletedRelation,
 		})
 	} else {
-		// Notify post author if a new like appears
-		if relation == "LIKE" {
-			res, _ := database.MakeRequest("MATCH (u:User)-[:CREATE]-(:Post {id: $id}) RETURN u.name;",
-				map[string]any{"id": getbody.Id})
-			if err != nil {
-				log.Printf("(Relation) Cannot get post creator: %v", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				jsonEncoder.Encode(model.RequestError{
-					Error:   true,
-					Message: ErrorWithDatabase,
-				})
-				return
-			}
-
-			if vanity != res.(string) {
-				msg, _ := json.Marshal(
-					model.Message{
-						Type:      "post_like",
-						From:      vanity,
-						To:        getbody.Id,
-						Important: true,
-					},
-				)
-				helpers.Publish(res.(string), msg)
-			}
+		if err := notifyRelation(req.Context(), vanity, relation, getbody.Id); err != nil {
+			problem.Write(w, req, err)
+			return
 		}
 
 		jsonEncoder.Encode(model.RequestError{
@@ -275,88 +129,225 @@ func Relation(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// Exists handles route to know if a relation
-// exists between two nodes
-func Exists(w http.ResponseWriter, req *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	jsonEncoder := json.NewEncoder(w)
+// setRelation creates the relation unless it exists. Subscribing
+// to a private account requests the subscription instead
+func setRelation(w http.ResponseWriter, req *http.Request) {
+	vanity := auth.Vanity(req.Context())
+
+	state, err := relationState(req, vanity)
+	if err != nil {
+		problem.Write(w, req, err)
+		return
+	}
 
-	relation := strings.ToUpper(strings.TrimPrefix(req.URL.Path, "/relation/"))
-	if relation == "" || func() bool {
-		for _, v := range []string{"LIKE", "SUBSCRIBER", "BLOCK", "LOVE", "REQUEST"} {
-			if v == relation {
-				return false
+	switch state.Relation {
+	case "BLOCK":
+		// Remove subscription relations
+		if err := store.RemoveSubscriptions(req.Context(), vanity, state.Target); err != nil {
+			problem.Write(w, req, storeError(err, problem.UserNotFound))
+			return
+		}
+	case "SUBSCRIBER":
+		public, err := checkSubscription(req.Context(), vanity, state.Target)
+		if err != nil {
+			problem.Write(w, req, err)
+			return
+		}
+
+		if !public {
+			subscribed, err := store.RelationExists(req.Context(), vanity, state.Target, "SUBSCRIBER")
+			if err != nil {
+				problem.Write(w, req, storeError(err, problem.UserNotFound))
+				return
+			}
+			if !subscribed {
+				state.Relation = "REQUEST"
 			}
 		}
-		return true
-	}() {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidRelation,
-		})
-		return
 	}
 
-	if req.Header.Get("Authorization") == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
+	state.Changed, err = store.SetRelation(req.Context(), vanity, state.Target, state.Relation)
+	if err != nil {
+		problem.Write(w, req, storeError(err, notFoundCode(state.Relation)))
 		return
 	}
+	state.Exists = true
+
+	if state.Changed {
+		if err := notifyRelation(req.Context(), vanity, state.Relation, state.Target); err != nil {
+			problem.Write(w, req, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// deleteRelation deletes the relation if it exists. Unsubscribing
+// also cancels the subscription request
+func deleteRelation(w http.ResponseWriter, req *http.Request) {
+	vanity := auth.Vanity(req.Context())
 
-	vanity, err := helpers.CheckToken(req.Header.Get("Authorization"))
+	state, err := relationState(req, vanity)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
+		problem.Write(w, req, err)
 		return
 	}
 
-	target := req.URL.Query().Get("target")
-	if target == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidQuery,
-		})
+	state.Changed, err = store.DeleteRelation(req.Context(), vanity, state.Target, state.Relation)
+	if err != nil {
+		problem.Write(w, req, storeError(err, notFoundCode(state.Relation)))
 		return
 	}
 
-	var content string
+	if state.Relation == "SUBSCRIBER" {
+		requested, err := store.DeleteRelation(req.Context(), vanity, state.Target, "REQUEST")
+		if err != nil {
+			problem.Write(w, req, storeError(err, problem.UserNotFound))
+			return
+		}
+		state.Changed = state.Changed || requested
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// relationParam returns the relation of the path, in upper case,
+// or InvalidRelation if it is not allowed
+func relationParam(req *http.Request, allowed []string) (string, error) {
+	relation := strings.ToUpper(mux.Param(req, "relation"))
+	for _, v := range allowed {
+		if v == relation {
+			return relation, nil
+		}
+	}
+
+	return "", problem.New(problem.InvalidRelation).WithDetail("%q is not a relation", mux.Param(req, "relation"))
+}
+
+// relationState reads the relation and its target from the path
+func relationState(req *http.Request, vanity string) (model.RelationState, error) {
+	relation, err := relationParam(req, relations)
+	if err != nil {
+		return model.RelationState{}, err
+	}
+
+	state := model.RelationState{Relation: relation, Target: mux.Param(req, "target")}
+	if err := validate.Check(state); err != nil {
+		return state, err
+	}
+	if state.Target == vanity {
+		return state, problem.New(problem.ValidationFailed).WithField("target", "invalid", "relation with yourself is not allowed")
+	}
+
+	return state, nil
+}
+
+// checkSubscription checks that the user can subscribe to the target,
+// and returns whether the account of the target is public
+func checkSubscription(ctx context.Context, vanity string, target string) (bool, error) {
+	// Check if account is blocked
+	isBlocked, err := store.IsBlocked(ctx, vanity, target)
+	if err != nil {
+		log.Printf("(Relation) Cannot know if users are blocked: %v", err)
+		isBlocked = false
+	}
+
+	if isBlocked {
+		return false, problem.New(problem.UserBlocked)
+	}
+
+	// Check if account is private
+	stats, err := store.GetBasicProfile(ctx, target)
+	if err != nil {
+		return false, storeError(err, problem.UserNotFound)
+	}
+	if stats.Suspended {
+		return false, problem.New(problem.UserSuspended)
+	}
+
+	return stats.Public, nil
+}
+
+// notifyRelation notifies the target of a new subscription
+// request, or the author of a post of a new like
+func notifyRelation(ctx context.Context, vanity string, relation string, target string) error {
+	switch relation {
+	case "REQUEST":
+		// Notify target that requester wants to follow him
+		msg, _ := json.Marshal(
+			model.Message{
+				Type:      "request_subscription",
+				From:      vanity,
+				To:        target,
+				Important: true,
+			},
+		)
+		helpers.Publish(ctx, target, msg)
+	case "LIKE":
+		author, err := store.GetPostAuthor(ctx, target)
+		if err != nil {
+			return storeError(err, problem.PostNotFound)
+		}
+
+		if vanity != author {
+			msg, _ := json.Marshal(
+				model.Message{
+					Type:      "post_like",
+					From:      vanity,
+					To:        target,
+					Important: true,
+				},
+			)
+			helpers.Publish(ctx, author, msg)
+		}
+	}
+
+	return nil
+}
+
+// notFoundCode returns the code sent when the target of the relation doesn't exist
+func notFoundCode(relation string) problem.Code {
 	switch relation {
-	case "SUBSCRIBER", "BLOCK", "REQUEST":
-		content = "User"
 	case "LIKE", "VIEW":
-		content = "Post"
+		return problem.PostNotFound
 	case "LOVE":
-		content = "Comment"
+		return problem.CommentNotFound
 	}
 
-	var identifier string
-	if content == "User" {
-		identifier = "name"
-	} else {
-		identifier = "id"
+	return problem.UserNotFound
+}
+
+// Exists handles route to know if a relation
+// exists between two nodes
+func Exists(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonEncoder := json.NewEncoder(w)
+
+	relation, err := relationParam(req, []string{"LIKE", "SUBSCRIBER", "BLOCK", "LOVE", "REQUEST"})
+	if err != nil {
+		problem.Write(w, req, err)
+		return
+	}
+
+	vanity := auth.Vanity(req.Context())
+
+	target := req.URL.Query().Get("target")
+	if target == "" {
+		problem.Write(w, req, problem.New(problem.InvalidQuery).WithField("target", "required", "target of the relation is required"))
+		return
 	}
 
 	var existence string
-	res, err := database.MakeRequest("MATCH (a:User {name: $id})-[:"+relation+"]->(b:"+content+"{"+identifier+": $to}) RETURN a;",
-		map[string]any{"id": vanity, "to": target})
+	exists, err := store.RelationExists(req.Context(), vanity, target, relation)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorWithDatabase,
-		})
+		problem.Write(w, req, storeError(err, notFoundCode(relation)))
 		return
-	} else if res != nil {
+	} else if exists {
 		existence = "existent"
-	} else if res == nil {
+	} else {
 		existence = "non-existent"
 	}
 
@@ -0,0 +1,1194 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/auth"
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/Gravitalia/gravitalia/database"
+	"github.com/Gravitalia/gravitalia/filestore"
+	"github.com/Gravitalia/gravitalia/grpc"
+	"github.com/Gravitalia/gravitalia/helpers"
+	"github.com/Gravitalia/gravitalia/job"
+	"github.com/Gravitalia/gravitalia/model"
+	"github.com/Gravitalia/gravitalia/mux"
+	"github.com/Gravitalia/gravitalia/problem"
+	"github.com/Gravitalia/gravitalia/proto"
+	"github.com/Gravitalia/gravitalia/upload"
+	"github.com/cristalhq/jwt/v5"
+	"github.com/openzipkin/zipkin-go"
+	zipkinhttp "github.com/openzipkin/zipkin-go/middleware/http"
+	rpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ctx    = context.Background()
+	signer jwt.Signer
+)
+
+func TestMain(m *testing.M) {
+	helpers.Init()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	public, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err := helpers.InitJWT(ctx, config.JWT{PublicKeys: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))}); err != nil {
+		panic(err)
+	}
+
+	signer, _ = jwt.NewSignerRS(jwt.RS256, key)
+
+	os.Exit(m.Run())
+}
+
+// token creates a valid token for the vanity
+func token(t *testing.T, vanity string) string {
+	t.Helper()
+
+	token, err := jwt.NewBuilder(signer).Build(jwt.RegisteredClaims{
+		Subject:   vanity,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token.String()
+}
+
+// newStore sets an in-memory store with the users
+func newStore(t *testing.T, users ...string) *database.Memory {
+	t.Helper()
+
+	memory := database.NewMemory()
+	for _, user := range users {
+		memory.CreateUser(ctx, user)
+	}
+	Init(memory, &config.Config{})
+
+	u, err := upload.NewStore(config.Uploads{Dir: t.TempDir(), Expiration: time.Hour}, model.MaxImageSize, filestore.NewMemoryLocks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	InitUploads(u)
+
+	j, err := job.NewStore(config.Jobs{Dir: t.TempDir(), Retention: time.Hour}, filestore.NewMemoryLocks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+	InitJobs(ctx, j, 1)
+
+	return memory
+}
+
+// torre tags every image with the same label
+type torre struct {
+	proto.UnimplementedTorreServer
+	tag string
+}
+
+func (t torre) TorrePredict(context.Context, *proto.TorreRequest) (*proto.TorreReply, error) {
+	return &proto.TorreReply{Message: t.tag}, nil
+}
+
+// cdn uploads images, except the ones containing "fail",
+// named after their content
+type cdn struct {
+	proto.UnimplementedSpinozaServer
+	mu      sync.Mutex
+	deleted []string
+}
+
+func (c *cdn) Upload(_ context.Context, req *proto.UploadRequest) (*proto.BasicReponse, error) {
+	if bytes.Contains(req.GetData(), []byte("fail")) {
+		// Other uploads finish first
+		time.Sleep(50 * time.Millisecond)
+		return nil, status.Error(codes.Internal, "cannot upload")
+	}
+
+	return &proto.BasicReponse{Message: string(req.GetData())}, nil
+}
+
+func (c *cdn) Delete(_ context.Context, req *proto.DeleteRequest) (*proto.BasicReponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deleted = append(c.deleted, req.GetHash())
+	return &proto.BasicReponse{}, nil
+}
+
+// connect starts the services which are not nil, and connects to
+// them. Services which are nil are not running
+func connect(t *testing.T, torresix proto.TorreServer, spinoza proto.SpinozaServer) {
+	t.Helper()
+
+	// listen serves the service, and returns its address
+	listen := func(register func(server *rpc.Server)) string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := rpc.NewServer()
+		register(server)
+		go server.Serve(listener)
+		t.Cleanup(server.Stop)
+
+		return listener.Addr().String()
+	}
+
+	cfg := &config.Config{Grpc: config.Grpc{SpinozaAddress: "127.0.0.1:1", TorresixAddress: "127.0.0.1:1", PoolSize: 1, Keepalive: time.Minute}}
+	if torresix != nil {
+		cfg.Grpc.TorresixAddress = listen(func(server *rpc.Server) { proto.RegisterTorreServer(server, torresix) })
+	}
+	if spinoza != nil {
+		cfg.Grpc.SpinozaAddress = listen(func(server *rpc.Server) { proto.RegisterSpinozaServer(server, spinoza) })
+	}
+
+	if err := grpc.Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { grpc.Close() })
+}
+
+// moderation connects to a Torresix answering tag, or to
+// no Torresix if tag is empty. Spinoza is never running
+func moderation(t *testing.T, tag string) {
+	t.Helper()
+
+	var torresix proto.TorreServer
+	if tag != "" {
+		torresix = torre{tag: tag}
+	}
+	connect(t, torresix, nil)
+}
+
+// response holds successful responses and problems
+type response struct {
+	model.RequestError
+	Code   problem.Code         `json:"code"`
+	Fields []problem.FieldError `json:"errors"`
+}
+
+// serve routes the request and decodes the JSON response
+func serve(method string, target string, authorization string, body string) (int, response) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	return serveRequest(req)
+}
+
+// serveRequest routes the request and decodes the JSON response
+func serveRequest(req *http.Request) (int, response) {
+	rec := record(req)
+
+	var res response
+	json.Unmarshal(rec.Body.Bytes(), &res)
+
+	return rec.Code, res
+}
+
+// record routes the request and returns the response
+func record(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+
+	router := mux.New()
+	router.Use(
+		NewBodyLimits(conf.BodyLimits).Middleware,
+		auth.NewAuthenticator(conf.Services, auth.NewMemoryNonces(), store.GetRoles).Middleware,
+	)
+	Register(router, nil)
+	router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestGetPostPrivateAccount(t *testing.T) {
+	memory := newStore(t, "author", "follower", "stranger")
+	memory.SetPublic(ctx, "author", false)
+	memory.ToggleRelation(ctx, "follower", "author", "SUBSCRIBER")
+
+	id, _ := memory.CreatePost(ctx, "author", "cat", "legend", []string{"hash"})
+
+	if code, res := serve(http.MethodGet, "/posts/"+id, token(t, "stranger"), ""); code != http.StatusForbidden || res.Code != problem.PostAccessDenied {
+		t.Fatalf("stranger got %d %q, want %d %q", code, res.Code, http.StatusForbidden, problem.PostAccessDenied)
+	}
+
+	if code, _ := serve(http.MethodGet, "/posts/"+id, token(t, "follower"), ""); code != http.StatusOK {
+		t.Fatalf("follower got %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestRelationToggleLike(t *testing.T) {
+	memory := newStore(t, "author", "liker")
+	id, _ := memory.CreatePost(ctx, "author", "cat", "legend", []string{"hash"})
+
+	_, res := serve(http.MethodPost, "/relation/like", token(t, "liker"), `{"id":"`+id+`"}`)
+	if res.Message != OkCreatedRelation {
+		t.Fatalf("first like = %q, want %q", res.Message, OkCreatedRelation)
+	}
+
+	post, _ := memory.GetPost(ctx, id, "")
+	if post.Like != 1 {
+		t.Fatalf("post has %d likes, want 1", post.Like)
+	}
+
+	_, res = serve(http.MethodPost, "/relation/like", token(t, "liker"), `{"id":"`+id+`"}`)
+	if res.Message != OkDeletedRelation {
+		t.Fatalf("second like = %q, want %q", res.Message, OkDeletedRelation)
+	}
+}
+
+func TestSetRelation(t *testing.T) {
+	memory := newStore(t, "author", "liker", "private")
+	memory.SetPublic(ctx, "private", false)
+	id, _ := memory.CreatePost(ctx, "author", "cat", "legend", []string{"hash"})
+
+	like := "/relation/like/" + id
+
+	for _, test := range []struct {
+		method string
+		target string
+		code   int
+		state  model.RelationState
+	}{
+		// Double taps keep the post liked
+		{http.MethodPut, like, http.StatusOK, model.RelationState{Relation: "LIKE", Target: id, Exists: true, Changed: true}},
+		{http.MethodPut, like, http.StatusOK, model.RelationState{Relation: "LIKE", Target: id, Exists: true, Changed: false}},
+		{http.MethodDelete, like, http.StatusOK, model.RelationState{Relation: "LIKE", Target: id, Exists: false, Changed: true}},
+		{http.MethodDelete, like, http.StatusOK, model.RelationState{Relation: "LIKE", Target: id, Exists: false, Changed: false}},
+		// Private accounts are requested, and requests canceled
+		{http.MethodPut, "/relation/subscriber/private", http.StatusOK, model.RelationState{Relation: "REQUEST", Target: "private", Exists: true, Changed: true}},
+		{http.MethodDelete, "/relation/subscriber/private", http.StatusOK, model.RelationState{Relation: "SUBSCRIBER", Target: "private", Exists: false, Changed: true}},
+		{http.MethodPut, "/relation/like/unknown", http.StatusNotFound, model.RelationState{}},
+		{http.MethodPut, "/relation/subscriber/liker", http.StatusUnprocessableEntity, model.RelationState{}},
+		{http.MethodPut, "/relation/request/private", http.StatusBadRequest, model.RelationState{}},
+	} {
+		req := httptest.NewRequest(test.method, test.target, nil)
+		req.Header.Set("Authorization", token(t, "liker"))
+		rec := record(req)
+
+		var state model.RelationState
+		if rec.Code == http.StatusOK {
+			json.Unmarshal(rec.Body.Bytes(), &state)
+		}
+		if rec.Code != test.code || state != test.state {
+			t.Fatalf("%v %v got %d %+v, want %d %+v", test.method, test.target, rec.Code, state, test.code, test.state)
+		}
+	}
+
+	if post, _ := memory.GetPost(ctx, id, ""); post.Like != 0 {
+		t.Fatalf("post has %d likes, want 0", post.Like)
+	}
+	if exists, _ := memory.RelationExists(ctx, "liker", "private", "REQUEST"); exists {
+		t.Fatal("canceled request still exists")
+	}
+}
+
+func TestAcceptOrDecline(t *testing.T) {
+	memory := newStore(t, "private", "requester")
+	memory.SetPublic(ctx, "private", false)
+
+	_, res := serve(http.MethodPost, "/relation/subscriber", token(t, "requester"), `{"id":"private"}`)
+	if res.Message != OkAddedRequest {
+		t.Fatalf("subscription = %q, want %q", res.Message, OkAddedRequest)
+	}
+
+	code, _ := serve(http.MethodPost, "/request/accept?target=requester", token(t, "private"), "")
+	if code != http.StatusOK {
+		t.Fatalf("accept got %d, want %d", code, http.StatusOK)
+	}
+
+	if follows, _ := memory.IsUserSubscrirerTo(ctx, "requester", "private"); !follows {
+		t.Fatal("requester should follow private after acceptance")
+	}
+
+	if code, res := serve(http.MethodPost, "/request/accept?target=requester", token(t, "private"), ""); code != http.StatusNotFound || res.Code != problem.RelationNotFound {
+		t.Fatalf("accepting twice got %d %q, want %d %q", code, res.Code, http.StatusNotFound, problem.RelationNotFound)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	newStore(t)
+	conf.Health = config.Health{
+		NonCritical: []string{"memcached", "nats", "spinoza", "torresix"},
+		Timeout:     100 * time.Millisecond,
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	rec := httptest.NewRecorder()
+	Readyz(rec, req)
+
+	var report HealthReport
+	json.Unmarshal(rec.Body.Bytes(), &report)
+
+	if rec.Code != http.StatusOK || report.Status != StatusDegraded {
+		t.Fatalf("expected degraded service, got %v %+v", rec.Code, report)
+	}
+	if report.Checks["memgraph"].Status != StatusUp || report.Checks["nats"].Status != StatusDown {
+		t.Errorf("invalid checks: %+v", report.Checks)
+	}
+
+	// Without NATS, the service can't send notifications
+	conf.Health.NonCritical = []string{"memcached", "spinoza", "torresix"}
+	rec = httptest.NewRecorder()
+	Readyz(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %v", rec.Code)
+	}
+}
+
+func TestGetPostNotFound(t *testing.T) {
+	newStore(t, "viewer")
+
+	code, res := serve(http.MethodGet, "/posts/404", token(t, "viewer"), "")
+	if code != http.StatusNotFound || res.Code != problem.PostNotFound {
+		t.Fatalf("got %d %q, want %d %q", code, res.Code, http.StatusNotFound, problem.PostNotFound)
+	}
+
+	// "new" is not the ID of a post
+	rec := record(httptest.NewRequest(http.MethodGet, "/posts/new", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "OPTIONS, POST" {
+		t.Fatalf("GET /posts/new got %d %v, want %d", rec.Code, rec.Header(), http.StatusMethodNotAllowed)
+	}
+}
+
+func TestSearchRequest(t *testing.T) {
+	newStore(t)
+	key := "0123456789abcdef0123456789abcdef"
+
+	// The search service authenticates this service by its signature
+	var service, body string
+	verifier := auth.NewAuthenticator(config.Services{Keys: map[string]string{"gravitalia": key}, MaxSkew: time.Minute}, auth.NewMemoryNonces(), nil)
+	search := httptest.NewServer(verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if p := auth.FromContext(req.Context()); p != nil {
+			service = p.Service
+		}
+		b, _ := io.ReadAll(req.Body)
+		body = string(b)
+	})))
+	defer search.Close()
+
+	conf.SearchAPI = search.URL
+	conf.SearchService = "gravitalia"
+	conf.SearchKey = key
+
+	tracer, _ := zipkin.NewTracer(nil)
+	client, _ := zipkinhttp.NewClient(tracer)
+	searchRequest(ctx, client, http.MethodDelete, "/search/delete", []byte(`{"vanity":"user"}`))
+
+	if service != "gravitalia" || body != `{"vanity":"user"}` {
+		t.Fatalf("search service got %q from %q, want a signed request", body, service)
+	}
+}
+
+func TestSuspendRequiresService(t *testing.T) {
+	memory := newStore(t, "user")
+	conf.Services = config.Services{
+		Keys:    map[string]string{"moderation": "moderation-key", "account": "account-key"},
+		Scopes:  map[string]string{"moderation": auth.ScopeSuspendUsers, "account": auth.ScopeDeleteUsers},
+		MaxSkew: time.Minute,
+	}
+
+	if code, res := serve(http.MethodPost, "/account/suspend?vanity=user", token(t, "user"), ""); code != http.StatusForbidden || res.Code != problem.Forbidden {
+		t.Fatalf("user got %d %q, want %d %q", code, res.Code, http.StatusForbidden, problem.Forbidden)
+	}
+
+	// Services can only use the routes of their scopes
+	req := httptest.NewRequest(http.MethodPost, "/account/suspend?vanity=user", nil)
+	auth.Sign(req, "account", "account-key")
+	if code, res := serveRequest(req); code != http.StatusForbidden || res.Code != problem.Forbidden {
+		t.Fatalf("account service got %d %q, want %d %q", code, res.Code, http.StatusForbidden, problem.Forbidden)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/account/suspend?vanity=user", nil)
+	auth.Sign(req, "moderation", "moderation-key")
+	if code, _ := serveRequest(req); code != http.StatusOK {
+		t.Fatalf("moderation service got %d, want %d", code, http.StatusOK)
+	}
+
+	if profile, _ := memory.GetBasicProfile(ctx, "user"); !profile.Suspended {
+		t.Fatal("user should be suspended")
+	}
+}
+
+func TestSuspendUnknownUser(t *testing.T) {
+	memory := newStore(t, "moderator")
+	memory.SetRoles(ctx, "moderator", []string{"moderator"})
+
+	for _, test := range []struct {
+		vanity string
+		code   problem.Code
+	}{
+		{"unknown", problem.UserNotFound},
+		{"not+a+vanity", problem.ValidationFailed},
+	} {
+		if _, res := serve(http.MethodPost, "/account/suspend?vanity="+test.vanity, token(t, "moderator"), ""); res.Code != test.code {
+			t.Errorf("suspending %q got %q, want %q", test.vanity, res.Code, test.code)
+		}
+	}
+}
+
+func TestSuspendAudit(t *testing.T) {
+	memory := newStore(t, "moderator", "user")
+	memory.SetRoles(ctx, "moderator", []string{"moderator"})
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	serve(http.MethodPost, "/account/suspend?vanity=user", token(t, "moderator"), "")
+	serve(http.MethodPost, "/account/suspend?vanity=user&suspend=false", token(t, "moderator"), "")
+	// Failed actions are not audited
+	serve(http.MethodPost, "/account/suspend?vanity=unknown", token(t, "moderator"), "")
+
+	for _, entry := range []string{"(Audit) user:moderator suspended user", "(Audit) user:moderator unsuspended user"} {
+		if !strings.Contains(logs.String(), entry) {
+			t.Errorf("logs don't contain %q:\n%v", entry, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "unknown") {
+		t.Errorf("failed suspension is audited:\n%v", logs.String())
+	}
+}
+
+func TestInvalidTokenOnAnonymousRoute(t *testing.T) {
+	memory := newStore(t, "author")
+	id, _ := memory.CreatePost(ctx, "author", "cat", "legend", []string{"hash"})
+
+	if code, res := serve(http.MethodGet, "/posts/"+id, "invalid", ""); code != http.StatusUnauthorized || res.Code != problem.InvalidToken {
+		t.Fatalf("got %d %q, want %d %q", code, res.Code, http.StatusUnauthorized, problem.InvalidToken)
+	}
+}
+
+func TestStaffRoles(t *testing.T) {
+	memory := newStore(t, "moderator", "support", "user")
+	memory.SetRoles(ctx, "moderator", []string{"moderator"})
+	memory.SetRoles(ctx, "support", []string{"support"})
+
+	if code, res := serve(http.MethodPost, "/account/suspend?vanity=user", token(t, "support"), ""); code != http.StatusForbidden || res.Code != problem.Forbidden {
+		t.Fatalf("support got %d %q, want %d %q", code, res.Code, http.StatusForbidden, problem.Forbidden)
+	}
+
+	if code, _ := serve(http.MethodPost, "/account/suspend?vanity=user", token(t, "moderator"), ""); code != http.StatusOK {
+		t.Fatalf("moderator got %d, want %d", code, http.StatusOK)
+	}
+
+	if profile, _ := memory.GetBasicProfile(ctx, "user"); !profile.Suspended {
+		t.Fatal("user should be suspended")
+	}
+
+	// Only staff can delete other accounts
+	if code, res := serve(http.MethodDelete, "/account/deletion?user=support", token(t, "moderator"), ""); code != http.StatusForbidden || res.Code != problem.Forbidden {
+		t.Fatalf("moderator deleting support got %d %q, want %d %q", code, res.Code, http.StatusForbidden, problem.Forbidden)
+	}
+
+	// Users set in the query are vanities
+	if code, res := serve(http.MethodGet, "/account/data?vanity=a'}),(b", token(t, "moderator"), ""); code != http.StatusUnprocessableEntity || res.Code != problem.ValidationFailed {
+		t.Fatalf("invalid user got %d %q, want %d %q", code, res.Code, http.StatusUnprocessableEntity, problem.ValidationFailed)
+	}
+}
+
+// memoryCounter counts requests of a single instance
+type memoryCounter map[string]uint64
+
+func (c memoryCounter) Increment(_ context.Context, key string, _ time.Duration) (uint64, error) {
+	c[key]++
+	return c[key], nil
+}
+
+func (c memoryCounter) Count(_ context.Context, key string) (uint64, error) {
+	return c[key], nil
+}
+
+// memoryResponses keeps responses of a single instance
+type memoryResponses map[string][]byte
+
+func (m memoryResponses) Add(_ context.Context, key string, value []byte, _ time.Duration) (bool, error) {
+	if _, ok := m[key]; ok {
+		return false, nil
+	}
+	m[key] = value
+	return true, nil
+}
+
+func (m memoryResponses) Get(_ context.Context, key string) ([]byte, error) {
+	return m[key], nil
+}
+
+func (m memoryResponses) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	m[key] = value
+	return nil
+}
+
+func (m memoryResponses) Delete(_ context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func TestIdempotency(t *testing.T) {
+	memory := newStore(t, "author", "liker")
+	id, _ := memory.CreatePost(ctx, "author", "cat", "legend", []string{"hash"})
+	responses := memoryResponses{}
+
+	router := mux.New()
+	router.Use(
+		auth.NewAuthenticator(conf.Services, auth.NewMemoryNonces(), store.GetRoles).Middleware,
+		NewIdempotency(config.Idempotency{TTL: time.Hour}, responses).Middleware,
+	)
+	Register(router, nil)
+
+	like := func(vanity string, key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/relation/like", strings.NewReader(body))
+		req.Header.Set("Authorization", token(t, vanity))
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	body := `{"id":"` + id + `"}`
+
+	if rec := like("liker", `"retried"`, body); rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first like got %d %v", rec.Code, rec.Header())
+	}
+
+	// A retry doesn't remove the like, it gets the same response
+	rec := like("liker", `"retried"`, body)
+	var res response
+	json.Unmarshal(rec.Body.Bytes(), &res)
+	if rec.Header().Get("Idempotent-Replayed") != "true" || res.Message != OkCreatedRelation {
+		t.Fatalf("retried like got %v %+v", rec.Header(), res)
+	}
+	if post, _ := memory.GetPost(ctx, id, ""); post.Like != 1 {
+		t.Fatalf("post has %d likes, want 1", post.Like)
+	}
+
+	if rec := like("liker", `"retried"`, `{"id":"other"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("key reused with another body got %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	// Keys of other users are not shared
+	if rec := like("author", `"retried"`, body); rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("key of another user is replayed")
+	}
+	if rec := like("liker", "", body); rec.Code != http.StatusOK {
+		t.Fatalf("like without key got %d", rec.Code)
+	}
+	if rec := like("liker", `"`+strings.Repeat("a", 256)+`"`, body); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid key got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// The first request is still running
+	for key := range responses {
+		responses[key] = []byte(`{}`)
+	}
+	if rec := like("liker", `"retried"`, body); rec.Code != http.StatusConflict {
+		t.Fatalf("retry of a running request got %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestIdempotencyMultipart(t *testing.T) {
+	memory := newStore(t, "author")
+	connect(t, torre{tag: "cat"}, &cdn{})
+
+	router := mux.New()
+	router.Use(
+		auth.NewAuthenticator(conf.Services, auth.NewMemoryNonces(), store.GetRoles).Middleware,
+		NewIdempotency(config.Idempotency{TTL: time.Hour}, memoryResponses{}).Middleware,
+	)
+	Register(router, nil)
+
+	// post sends a form, with a new boundary like browsers do
+	post := func(boundary string, image []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.SetBoundary(boundary)
+		writer.WriteField("description", "cat")
+		part, _ := writer.CreateFormFile("images", "cat.png")
+		part.Write(image)
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/posts/new", &body)
+		req.Header.Set("Authorization", token(t, "author"))
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Idempotency-Key", `"post"`)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := post("first", []byte{1})
+	if first.Code != http.StatusOK {
+		t.Fatalf("first post got %d: %v", first.Code, first.Body)
+	}
+	if rec := post("retry", []byte{1}); rec.Header().Get("Idempotent-Replayed") != "true" || rec.Body.String() != first.Body.String() {
+		t.Fatalf("retry with another boundary got %d %v", rec.Code, rec.Header())
+	}
+	if posts, _ := memory.GetUserPost(ctx, "author", 0); len(posts) != 1 {
+		t.Fatalf("author has %d posts, want 1", len(posts))
+	}
+
+	if rec := post("retry", []byte{2}); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("key reused with another image got %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(config.RateLimit{
+		Limits: map[string]config.Rate{"comment.create": {Requests: 2, Window: time.Minute}},
+	}, memoryCounter{})
+
+	router := mux.New()
+	router.Use(auth.NewAuthenticator(config.Services{}, auth.NewMemoryNonces(), nil).Middleware, limiter.Middleware)
+	router.HandleFunc(http.MethodPost, "/comment/{postID}", "comment.create", func(http.ResponseWriter, *http.Request) {})
+	router.HandleFunc(http.MethodGet, "/healthz", "healthz", func(http.ResponseWriter, *http.Request) {})
+
+	send := func(target string, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		if target == "/healthz" {
+			req.Method = http.MethodGet
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	user := token(t, "user")
+	for i := 0; i < 2; i++ {
+		if rec := send("/comment/1", user); rec.Code != http.StatusOK {
+			t.Fatalf("request %d got %d", i, rec.Code)
+		}
+	}
+
+	rec := send("/comment/2", user)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" || rec.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("third comment got %d %v", rec.Code, rec.Header())
+	}
+
+	// Other callers have their own counter
+	if rec := send("/comment/1", token(t, "other")); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("other user got %d %v", rec.Code, rec.Header())
+	}
+	if rec := send("/comment/1", ""); rec.Code != http.StatusOK {
+		t.Fatalf("anonymous user got %d", rec.Code)
+	}
+
+	// Probes are never limited
+	for i := 0; i < 700; i++ {
+		if rec := send("/healthz", ""); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("probe got %d %v", rec.Code, rec.Header())
+		}
+	}
+}
+
+func TestAuthenticationLimit(t *testing.T) {
+	newStore(t, "user")
+	limiter := NewRateLimiter(config.RateLimit{
+		Limits: map[string]config.Rate{"authentication": {Requests: 2, Window: time.Minute}},
+	}, memoryCounter{})
+
+	router := mux.New()
+	router.Use(limiter.Authentication, auth.NewAuthenticator(config.Services{}, auth.NewMemoryNonces(), nil).Middleware, limiter.Middleware)
+	router.HandleFunc(http.MethodGet, "/account", "account", func(http.ResponseWriter, *http.Request) {}).Use(auth.Require(auth.User))
+
+	send := func(remoteAddr string, authorization string) int {
+		req := httptest.NewRequest(http.MethodGet, "/account", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Valid tokens are not counted
+	for i := 0; i < 3; i++ {
+		if code := send("192.0.2.1:1234", token(t, "user")); code != http.StatusOK {
+			t.Fatalf("request %d got %d", i, code)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := send("192.0.2.1:1234", "invalid"); code != http.StatusUnauthorized {
+			t.Fatalf("invalid token %d got %d", i, code)
+		}
+	}
+	if code := send("192.0.2.1:1234", "invalid"); code != http.StatusTooManyRequests {
+		t.Fatalf("third invalid token got %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := send("192.0.2.1:1234", token(t, "user")); code != http.StatusTooManyRequests {
+		t.Fatalf("valid token of a limited address got %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	// Other addresses have their own counter
+	if code := send("192.0.2.2:1234", token(t, "user")); code != http.StatusOK {
+		t.Fatalf("other address got %d", code)
+	}
+}
+
+func TestAdaptiveLimit(t *testing.T) {
+	limit := &adaptiveLimit{limit: 4, max: 4, target: 100 * time.Millisecond}
+
+	for i := 0; i < 4; i++ {
+		if ok, _, _ := limit.acquire(); !ok {
+			t.Fatalf("request %d rejected under the limit", i)
+		}
+	}
+	if ok, _, _ := limit.acquire(); ok {
+		t.Fatal("request accepted above the limit")
+	}
+
+	// Slow requests decrease the limit
+	for i := 0; i < 3; i++ {
+		limit.release(time.Second)
+	}
+	if current, inFlight := limit.release(time.Second); current >= 3 || inFlight != 0 {
+		t.Fatalf("limit is %v with %d running requests after slow requests", current, inFlight)
+	}
+
+	// Fast requests increase it back, up to the maximum
+	for i := 0; i < 100; i++ {
+		limit.acquire()
+		limit.release(time.Millisecond)
+	}
+	if limit.limit != 4 {
+		t.Fatalf("limit is %v after fast requests, want 4", limit.limit)
+	}
+}
+
+func TestShedder(t *testing.T) {
+	shedder := NewShedder(config.Shedding{MaxConcurrency: map[string]int{"posts.create": 1}})
+
+	running := make(chan struct{})
+	done := make(chan struct{})
+
+	router := mux.New()
+	router.Use(shedder.Middleware)
+	router.HandleFunc(http.MethodPost, "/posts/new", "posts.create", func(http.ResponseWriter, *http.Request) {
+		running <- struct{}{}
+		<-done
+	})
+
+	go router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/posts/new", nil))
+	<-running
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/posts/new", nil))
+	close(done)
+
+	var res response
+	json.Unmarshal(rec.Body.Bytes(), &res)
+	if rec.Code != http.StatusServiceUnavailable || res.Code != problem.Overloaded || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("got %d %q %v, want %d %q", rec.Code, res.Code, rec.Header(), http.StatusServiceUnavailable, problem.Overloaded)
+	}
+}
+
+// slowBody is a body sent by a slow client
+type slowBody struct {
+	io.Reader
+	delay time.Duration
+}
+
+func (b slowBody) Read(p []byte) (int, error) {
+	time.Sleep(b.delay)
+	return b.Reader.Read(p)
+}
+
+func TestShedderSlowClient(t *testing.T) {
+	shedder := NewShedder(config.Shedding{TargetLatency: map[string]time.Duration{"posts.create": 10 * time.Millisecond}})
+
+	router := mux.New()
+	router.Use(shedder.Middleware)
+	router.HandleFunc(http.MethodPost, "/posts/new", "posts.create", func(w http.ResponseWriter, req *http.Request) {
+		io.ReadAll(req.Body)
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/posts/new", slowBody{strings.NewReader("cat"), 20 * time.Millisecond}))
+	if limit := shedder.limits["posts.create"]; limit.limit != limit.max {
+		t.Fatalf("limit got %v after a slow client, want %v", limit.limit, limit.max)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	newStore(t)
+
+	router := mux.New()
+	router.Use(NewCORS(config.CORS{
+		AllowedOrigins: []string{"https://www.gravitalia.com", "http://*.localhost:3000"},
+		AllowedMethods: []string{"GET", "POST", "DELETE"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		ExposedHeaders: []string{"Retry-After"},
+		MaxAge:         time.Hour,
+	}).Middleware)
+	Register(router, nil)
+
+	send := func(method string, origin string, requestMethod string, requestHeaders string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/posts/1", nil)
+		req.Header.Set("Origin", origin)
+		if requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", requestMethod)
+			req.Header.Set("Access-Control-Request-Headers", requestHeaders)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send(http.MethodOptions, "https://www.gravitalia.com", http.MethodDelete, "authorization")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "https://www.gravitalia.com" ||
+		rec.Header().Get("Access-Control-Allow-Methods") != "GET, DELETE" || rec.Header().Get("Access-Control-Max-Age") != "3600" {
+		t.Fatalf("invalid preflight response %d %v", rec.Code, rec.Header())
+	}
+
+	if rec := send(http.MethodOptions, "http://staging.localhost:3000", http.MethodGet, ""); rec.Header().Get("Access-Control-Allow-Origin") != "http://staging.localhost:3000" {
+		t.Errorf("subdomain not allowed: %v", rec.Header())
+	}
+
+	// PATCH is not a method of the route, X-Custom is not allowed
+	for _, rec := range []*httptest.ResponseRecorder{
+		send(http.MethodOptions, "https://www.gravitalia.com", http.MethodPatch, ""),
+		send(http.MethodOptions, "https://www.gravitalia.com", http.MethodGet, "X-Custom"),
+		send(http.MethodOptions, "https://evil.com", http.MethodGet, ""),
+	} {
+		if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("preflight should fail: %v", rec.Header())
+		}
+	}
+
+	rec = send(http.MethodGet, "https://www.gravitalia.com", "", "")
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Access-Control-Allow-Origin") != "https://www.gravitalia.com" || rec.Header().Get("Access-Control-Expose-Headers") != "Retry-After" {
+		t.Errorf("invalid response to an allowed origin %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestValidation(t *testing.T) {
+	memory := newStore(t, "author", "reader")
+	id, _ := memory.CreatePost(ctx, "author", "cat", "legend", []string{"hash"})
+
+	for _, test := range []struct {
+		target string
+		body   string
+		status int
+		code   problem.Code
+		field  string
+	}{
+		{"/posts/new", `{"description":"cat","images":[]}`, http.StatusUnprocessableEntity, problem.ValidationFailed, "images"},
+		{"/comment/" + id, `{"content":"` + strings.Repeat("🐈", 501) + `"}`, http.StatusUnprocessableEntity, problem.ValidationFailed, "content"},
+		{"/comment/" + id, `{"content":"nice","reply":"../1"}`, http.StatusUnprocessableEntity, problem.ValidationFailed, "reply"},
+		{"/relation/subscriber", `{"id":"author\nMATCH"}`, http.StatusUnprocessableEntity, problem.ValidationFailed, "id"},
+		{"/relation/subscriber", `{"id":`, http.StatusBadRequest, problem.InvalidBody, ""},
+		{"/comment/" + id, `{"content":"` + strings.Repeat("a", 65<<10) + `"}`, http.StatusRequestEntityTooLarge, problem.BodyTooLarge, ""},
+	} {
+		code, res := serve(http.MethodPost, test.target, token(t, "reader"), test.body)
+		if code != test.status || res.Code != test.code || (test.field != "" && (len(res.Fields) != 1 || res.Fields[0].Field != test.field)) {
+			t.Errorf("%v %.40s: got %d %+v, want %d %q on %q", test.target, test.body, code, res, test.status, test.code, test.field)
+		}
+	}
+
+	// Comments are counted in graphemes
+	if code, _ := serve(http.MethodPost, "/comment/"+id, token(t, "reader"), `{"content":"`+strings.Repeat("🐈", 500)+`"}`); code != http.StatusOK {
+		t.Fatalf("comment of 500 emojis got %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestNewPostMultipart(t *testing.T) {
+	newStore(t, "author")
+	moderation(t, "cat")
+
+	form := func(images ...[]byte) (string, string) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("description", "cat")
+		for i, image := range images {
+			part, _ := writer.CreateFormFile("images", fmt.Sprintf("%d.png", i))
+			part.Write(image)
+		}
+		writer.Close()
+		return body.String(), writer.FormDataContentType()
+	}
+
+	for _, test := range []struct {
+		images [][]byte
+		code   problem.Code
+		field  string
+	}{
+		{nil, problem.ValidationFailed, "images"},
+		{[][]byte{{1}, {2}, {3}, {4}, {5}, {6}}, problem.ValidationFailed, "images"},
+		{[][]byte{{1}, make([]byte, model.MaxImageSize+1)}, problem.ValidationFailed, "images[1]"},
+		{[][]byte{{1}, {}}, problem.ValidationFailed, "images[1]"},
+		// Images are read, then sent to Spinoza, which is not running
+		{[][]byte{{1}}, problem.UploadFailed, ""},
+	} {
+		body, contentType := form(test.images...)
+		req := httptest.NewRequest(http.MethodPost, "/posts/new", strings.NewReader(body))
+		req.Header.Set("Authorization", token(t, "author"))
+		req.Header.Set("Content-Type", contentType)
+
+		_, res := serveRequest(req)
+		if res.Code != test.code || (test.field != "" && (len(res.Fields) != 1 || res.Fields[0].Field != test.field)) {
+			t.Errorf("%d images: got %q %v, want %q on %q", len(test.images), res.Code, res.Fields, test.code, test.field)
+		}
+	}
+
+	// IDs of uploads are short, longer ones are not truncated
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("uploads", strings.Repeat("a", 65))
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/posts/new", &body)
+	req.Header.Set("Authorization", token(t, "author"))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if _, res := serveRequest(req); res.Code != problem.ValidationFailed || len(res.Fields) != 1 || res.Fields[0].Field != "uploads[0]" || res.Fields[0].Code != "too_large" {
+		t.Fatalf("long upload ID got %q %v, want too_large on uploads[0]", res.Code, res.Fields)
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	newStore(t, "author", "stranger")
+	moderation(t, "cat")
+
+	send := func(method string, target string, vanity string, headers map[string]string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", token(t, vanity))
+		req.Header.Set("Tus-Resumable", TusVersion)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+
+		return record(req)
+	}
+	chunk := func(offset string) map[string]string {
+		return map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": offset}
+	}
+
+	if rec := send(http.MethodOptions, "/uploads", "author", nil, ""); rec.Header().Get("Tus-Version") != TusVersion || rec.Header().Get("Tus-Max-Size") == "" {
+		t.Fatalf("invalid OPTIONS response %v", rec.Header())
+	}
+	if rec := send(http.MethodPost, "/uploads", "author", map[string]string{"Tus-Resumable": "0.2.2", "Upload-Length": "6"}, ""); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("unsupported version got %d, want %d", rec.Code, http.StatusPreconditionFailed)
+	}
+
+	rec := send(http.MethodPost, "/uploads", "author", map[string]string{"Upload-Length": "6"}, "")
+	location := rec.Header().Get("Location")
+	if rec.Code != http.StatusCreated || !strings.HasPrefix(location, "/uploads/") {
+		t.Fatalf("creation got %d %v", rec.Code, rec.Header())
+	}
+	id := strings.TrimPrefix(location, "/uploads/")
+
+	if rec := send(http.MethodPatch, location, "author", chunk("0"), "cat"); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "3" {
+		t.Fatalf("first chunk got %d %v", rec.Code, rec.Header())
+	}
+
+	// Posts need complete uploads
+	if code, res := serve(http.MethodPost, "/posts/new", token(t, "author"), `{"uploads":["`+id+`"]}`); code != http.StatusUnprocessableEntity || len(res.Fields) != 1 || res.Fields[0].Code != "incomplete" {
+		t.Fatalf("post with an incomplete upload got %d %+v", code, res)
+	}
+
+	if rec := send(http.MethodHead, location, "stranger", nil, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("upload of another user got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := send(http.MethodHead, location, "author", nil, ""); rec.Header().Get("Upload-Offset") != "3" || rec.Header().Get("Upload-Length") != "6" {
+		t.Fatalf("invalid HEAD response %v", rec.Header())
+	}
+	if rec := send(http.MethodPatch, location, "author", chunk("0"), "cat"); rec.Code != http.StatusConflict {
+		t.Fatalf("chunk at a previous offset got %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if rec := send(http.MethodPatch, location, "author", chunk("3"), "dog"); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "6" {
+		t.Fatalf("last chunk got %d %v", rec.Code, rec.Header())
+	}
+
+	// The upload is read, then sent to Spinoza, which is not running
+	if _, res := serve(http.MethodPost, "/posts/new", token(t, "author"), `{"uploads":["`+id+`"]}`); res.Code != problem.UploadFailed {
+		t.Fatalf("post with a complete upload got %q, want %q", res.Code, problem.UploadFailed)
+	}
+
+	if rec := send(http.MethodDelete, location, "author", nil, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("termination got %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
+
+func TestPublishPostResumed(t *testing.T) {
+	memory := newStore(t, "author")
+	id, _ := memory.CreatePost(ctx, "author", "cat", "cat", []string{"ok"})
+
+	// The stopped attempt created the post before saving the job
+	payload, _ := json.Marshal(model.PostBody{Description: "cat", Images: [][]byte{[]byte("ok")}})
+	result, err := publishPost(ctx, &job.Job{ID: "resumed", Owner: "author", Checkpoint: id}, payload)
+	if err != nil || result != id {
+		t.Fatalf("resumed job got %q %v, want %q", result, err, id)
+	}
+
+	if posts, _ := memory.GetUserPost(ctx, "author", 0); len(posts) != 1 {
+		t.Fatalf("resumed job created %d posts, want 1", len(posts))
+	}
+}
+
+func TestModeration(t *testing.T) {
+	newStore(t, "author")
+	post := `{"description":"cat","images":["AQ=="]}`
+
+	moderation(t, "nude")
+	if _, res := serve(http.MethodPost, "/posts/new", token(t, "author"), post); res.Code != problem.ProhibitedContent {
+		t.Fatalf("nude image got %q, want %q", res.Code, problem.ProhibitedContent)
+	}
+
+	// Torresix is down, posts are rejected by default
+	moderation(t, "")
+	if code, res := serve(http.MethodPost, "/posts/new", token(t, "author"), post); code != http.StatusServiceUnavailable || res.Code != problem.ModerationUnavailable {
+		t.Fatalf("post without moderation got %d %q, want %q", code, res.Code, problem.ModerationUnavailable)
+	}
+
+	// or queued until Torresix is back
+	conf.Moderation = config.Moderation{Unavailable: "queue", QueueFor: 10 * time.Millisecond}
+	req := httptest.NewRequest(http.MethodPost, "/posts/new", strings.NewReader(post))
+	req.Header.Set("Authorization", token(t, "author"))
+	rec := record(req)
+	if rec.Code != http.StatusAccepted || rec.Header().Get("Preference-Applied") != "respond-async" || !strings.HasPrefix(rec.Header().Get("Location"), "/posts/jobs/") {
+		t.Fatalf("queued post got %d %v", rec.Code, rec.Header())
+	}
+
+	// The post is dropped once it waited too long
+	if j := finished(t, rec.Header().Get("Location"), "author"); j.Status != job.Failed || j.Error.Code != problem.ModerationUnavailable {
+		t.Fatalf("queued post got %+v, want failed with %q", j, problem.ModerationUnavailable)
+	}
+}
+
+func TestQueuedUpload(t *testing.T) {
+	memory := newStore(t, "author")
+	conf.Moderation = config.Moderation{Unavailable: "queue", QueueFor: time.Hour}
+
+	// Spinoza is down, the post waits for it
+	connect(t, torre{tag: "cat"}, nil)
+	req := httptest.NewRequest(http.MethodPost, "/posts/new", strings.NewReader(`{"description":"cat","images":["AQ=="]}`))
+	req.Header.Set("Authorization", token(t, "author"))
+	req.Header.Set("Prefer", "respond-async")
+	rec := record(req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("queued post got %d: %v", rec.Code, rec.Body)
+	}
+
+	var j job.Job
+	for deadline := time.Now().Add(5 * time.Second); j.Error == nil && time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		req := httptest.NewRequest(http.MethodGet, rec.Header().Get("Location"), nil)
+		req.Header.Set("Authorization", token(t, "author"))
+		json.Unmarshal(record(req).Body.Bytes(), &j)
+	}
+	if j.Status != job.Pending || j.Error == nil || j.Error.Code != problem.UploadFailed {
+		t.Fatalf("post without Spinoza got %+v, want pending with %q", j, problem.UploadFailed)
+	}
+
+	// and is published once it is back
+	connect(t, torre{tag: "cat"}, &cdn{})
+	if j := finished(t, rec.Header().Get("Location"), "author"); j.Status != job.Done || j.Result == "" {
+		t.Fatalf("queued post got %+v, want done", j)
+	} else if _, err := memory.GetPost(context.Background(), j.Result, "author"); err != nil {
+		t.Fatalf("queued post is not created: %v", err)
+	}
+}
+
+// finished polls the job at location until it is finished
+func finished(t *testing.T, location string, vanity string) *job.Job {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		req := httptest.NewRequest(http.MethodGet, location, nil)
+		req.Header.Set("Authorization", token(t, vanity))
+		rec := record(req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("job got %d: %v", rec.Code, rec.Body)
+		}
+
+		var j job.Job
+		json.Unmarshal(rec.Body.Bytes(), &j)
+		if j.Finished() {
+			return &j
+		}
+	}
+
+	t.Fatalf("job %v is not finished", location)
+	return nil
+}
+
+func TestAsyncPost(t *testing.T) {
+	memory := newStore(t, "author", "stranger")
+	connect(t, torre{tag: "cat"}, &cdn{})
+
+	req := httptest.NewRequest(http.MethodPost, "/posts/new", strings.NewReader(`{"description":"cat","images":["b2s="]}`))
+	req.Header.Set("Authorization", token(t, "author"))
+	req.Header.Set("Prefer", "respond-async, wait=0")
+	rec := record(req)
+	location := rec.Header().Get("Location")
+	if rec.Code != http.StatusAccepted || rec.Header().Get("Preference-Applied") != "respond-async" || !strings.HasPrefix(location, "/posts/jobs/") {
+		t.Fatalf("async post got %d %v", rec.Code, rec.Header())
+	}
+
+	if code, _ := serve(http.MethodGet, location, token(t, "stranger"), ""); code != http.StatusNotFound {
+		t.Fatalf("job of another user got %d, want %d", code, http.StatusNotFound)
+	}
+
+	j := finished(t, location, "author")
+	if j.Status != job.Done || j.Result == "" {
+		t.Fatalf("async post got %+v", j)
+	}
+	if _, err := memory.GetPost(ctx, j.Result, "author"); err != nil {
+		t.Fatalf("published post got %v", err)
+	}
+}
+
+func TestNewPostCompensation(t *testing.T) {
+	newStore(t, "author")
+	spinoza := &cdn{}
+	connect(t, torre{tag: "cat"}, spinoza)
+
+	// Images "ok" and "fail"
+	if _, res := serve(http.MethodPost, "/posts/new", token(t, "author"), `{"description":"cat","images":["b2s=","ZmFpbA=="]}`); res.Code != problem.UploadFailed {
+		t.Fatalf("failed upload got %q, want %q", res.Code, problem.UploadFailed)
+	}
+	if len(spinoza.deleted) != 1 || spinoza.deleted[0] != "ok" {
+		t.Fatalf("deleted %v, want the uploaded image", spinoza.deleted)
+	}
+
+	spinoza.deleted = nil
+	if code, res := serve(http.MethodPost, "/posts/new", token(t, "author"), `{"description":"cat","images":["b2s="]}`); code != http.StatusOK || res.Message == "" {
+		t.Fatalf("post got %d %+v", code, res)
+	}
+	if len(spinoza.deleted) != 0 {
+		t.Fatalf("images of a published post are deleted: %v", spinoza.deleted)
+	}
+
+	// The image "ok" is used by the published post
+	if _, res := serve(http.MethodPost, "/posts/new", token(t, "author"), `{"description":"cat","images":["b2s=","ZmFpbA=="]}`); res.Code != problem.UploadFailed {
+		t.Fatalf("failed upload got %q, want %q", res.Code, problem.UploadFailed)
+	}
+	if len(spinoza.deleted) != 0 {
+		t.Fatalf("images of a published post are deleted: %v", spinoza.deleted)
+	}
+}
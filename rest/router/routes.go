@@ -0,0 +1,88 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Gravitalia/gravitalia/auth"
+	"github.com/Gravitalia/gravitalia/mux"
+	"github.com/Gravitalia/gravitalia/problem"
+	zipkinhttp "github.com/openzipkin/zipkin-go/middleware/http"
+)
+
+// Register adds every endpoint of the API to the router.
+// Route names are used by metrics, traces and deadlines.
+// Routes without policy are also open to anonymous users
+// and services; the caller is authenticated by auth.Authenticator.
+// Internal services and staff need a scope for each administrative route
+func Register(r *mux.Router, zipkinClient *zipkinhttp.Client) {
+	r.NotFound = http.HandlerFunc(notFound)
+	r.MethodNotAllowed = http.HandlerFunc(methodNotAllowed)
+
+	user := auth.Require(auth.User)
+
+	r.HandleFunc(http.MethodGet, "/", "index", Index)
+	r.HandleFunc(http.MethodGet, "/healthz", "healthz", Healthz)
+	r.HandleFunc(http.MethodGet, "/readyz", "readyz", Readyz)
+	r.HandleFunc(http.MethodGet, "/callback", "callback", OAuth(zipkinClient))
+
+	r.HandleFunc(http.MethodGet, "/users/{vanity}", "users.get", getUser)
+	r.HandleFunc(http.MethodPatch, "/users/@me", "users.update", update).Use(user)
+
+	r.HandleFunc(http.MethodGet, "/relation/{relation}", "relation.exists", Exists).Use(user)
+	r.HandleFunc(http.MethodPost, "/relation/{relation}", "relation.toggle", Relation).Use(user)
+	r.HandleFunc(http.MethodPut, "/relation/{relation}/{target}", "relation.set", setRelation).Use(user)
+	r.HandleFunc(http.MethodDelete, "/relation/{relation}/{target}", "relation.delete", deleteRelation).Use(user)
+
+	r.HandleFunc(http.MethodPost, "/posts/new", "posts.create", newPost).Use(user)
+	r.HandleFunc(http.MethodGet, "/posts/jobs/{jobID}", "posts.job", getJob).Use(user)
+	r.HandleFunc(http.MethodGet, "/posts/{postID:id}", "posts.get", getPost)
+	r.HandleFunc(http.MethodDelete, "/posts/{postID:id}", "posts.delete", deletePost).Use(user)
+
+	// Resumable uploads of images, referenced when creating posts
+	r.HandleFunc(http.MethodOptions, "/uploads", "uploads.options", uploadOptions).Use(tus)
+	r.HandleFunc(http.MethodPost, "/uploads", "uploads.create", createUpload).Use(user, tus)
+	r.HandleFunc(http.MethodHead, "/uploads/{uploadID}", "uploads.get", headUpload).Use(user, tus)
+	r.HandleFunc(http.MethodPatch, "/uploads/{uploadID}", "uploads.append", appendUpload).Use(user, tus)
+	r.HandleFunc(http.MethodDelete, "/uploads/{uploadID}", "uploads.delete", deleteUpload).Use(user, tus)
+
+	r.HandleFunc(http.MethodGet, "/comment/{postID}", "comment.list", getComment)
+	r.HandleFunc(http.MethodPost, "/comment/{postID}", "comment.create", addComment).Use(user)
+	r.HandleFunc(http.MethodDelete, "/comment/{commentID}", "comment.delete", deleteComment).Use(user)
+
+	r.HandleFunc(http.MethodGet, "/list/{list}", "list.get", getList).Use(user)
+	r.HandleFunc(http.MethodPost, "/request/{choice:accept|decline}", "request", AcceptOrDecline).Use(user)
+
+	r.HandleFunc(http.MethodDelete, "/account/deletion", "account.deletion", DeleteUser(zipkinClient)).Use(auth.Require(auth.UserOrService, auth.ScopeDeleteUsers))
+	r.HandleFunc(http.MethodPost, "/account/suspend", "account.suspend", Suspend).Use(auth.Require(auth.ServiceOrStaff, auth.ScopeSuspendUsers))
+	r.HandleFunc(http.MethodGet, "/account/data", "account.data", GetData).Use(auth.Require(auth.UserOrService, auth.ScopeExportUsers))
+}
+
+// notFound answers requests matching no route
+func notFound(w http.ResponseWriter, req *http.Request) {
+	problem.Write(w, req, problem.New(problem.NotFound))
+}
+
+// methodNotAllowed answers requests using a method not
+// declared on the route, listed in the Allow header
+func methodNotAllowed(w http.ResponseWriter, req *http.Request) {
+	problem.Write(w, req, problem.New(problem.MethodNotAllowed).WithDetail("allowed methods are %v", w.Header().Get("Allow")))
+}
+
+// routeGroup returns the key of settings used by a route: its name,
+// else its group such as "posts" for "posts.create", else "default"
+func routeGroup[T any](settings map[string]T, route string) string {
+	for route != "" {
+		if _, ok := settings[route]; ok {
+			return route
+		}
+
+		index := strings.LastIndex(route, ".")
+		if index == -1 {
+			break
+		}
+		route = route[:index]
+	}
+
+	return "default"
+}
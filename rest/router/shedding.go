@@ -0,0 +1,152 @@
+package router
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/Gravitalia/gravitalia/helpers"
+	"github.com/Gravitalia/gravitalia/mux"
+	"github.com/Gravitalia/gravitalia/problem"
+)
+
+// backoff multiplies the limit after a slow request
+const backoff = 0.9
+
+// adaptiveLimit is an AIMD limit of concurrent requests: it grows
+// by one request per limit of fast requests, and is multiplied by
+// backoff for each request slower than the target
+type adaptiveLimit struct {
+	mu       sync.Mutex
+	limit    float64
+	max      float64
+	inFlight int
+	target   time.Duration
+}
+
+// acquire reserves a place for a request, false if the limit is reached
+func (l *adaptiveLimit) acquire() (bool, float64, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if float64(l.inFlight) >= math.Floor(l.limit) {
+		return false, l.limit, l.inFlight
+	}
+
+	l.inFlight++
+	return true, l.limit, l.inFlight
+}
+
+// release frees the place of a request, and adapts
+// the limit to its latency
+func (l *adaptiveLimit) release(latency time.Duration) (float64, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	if latency > l.target {
+		l.limit = math.Max(1, l.limit*backoff)
+	} else {
+		l.limit = math.Min(l.max, l.limit+1/l.limit)
+	}
+
+	return l.limit, l.inFlight
+}
+
+// Shedder rejects requests of expensive routes once too many are
+// running, instead of piling them on slow dependencies
+type Shedder struct {
+	limits map[string]*adaptiveLimit
+}
+
+// NewShedder overrides the default limits with the configured ones.
+// Routes without limit, in their name or group, are never shed
+func NewShedder(cfg config.Shedding) *Shedder {
+	maxConcurrency := map[string]int{
+		"posts.create": 50,
+		"users.get":    200,
+		"account.data": 4,
+	}
+	targetLatency := map[string]time.Duration{
+		"default":      time.Second,
+		"posts.create": 10 * time.Second,
+		"users.get":    500 * time.Millisecond,
+		"account.data": 20 * time.Second,
+	}
+
+	for route, limit := range cfg.MaxConcurrency {
+		maxConcurrency[route] = limit
+	}
+	for route, latency := range cfg.TargetLatency {
+		targetLatency[route] = latency
+	}
+
+	s := &Shedder{limits: make(map[string]*adaptiveLimit)}
+	for route, limit := range maxConcurrency {
+		if limit <= 0 {
+			continue
+		}
+
+		s.limits[route] = &adaptiveLimit{
+			limit:  float64(limit),
+			max:    float64(limit),
+			target: targetLatency[routeGroup(targetLatency, route)],
+		}
+		helpers.SetConcurrency(route, float64(limit), 0)
+	}
+
+	return s
+}
+
+// Middleware rejects the request with 503 if the limit of its route
+// is reached, and measures its latency to adapt the limit
+func (s *Shedder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var name string
+		if route := mux.CurrentRoute(req.Context()); route != nil {
+			name = route.Name()
+		}
+
+		group := routeGroup(s.limits, name)
+		limit := s.limits[group]
+		if limit == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		ok, current, inFlight := limit.acquire()
+		helpers.SetConcurrency(group, current, inFlight)
+		if !ok {
+			helpers.IncrementShedRequests(group)
+			w.Header().Set("Retry-After", "1")
+			problem.Write(w, req, problem.New(problem.Overloaded))
+			return
+		}
+
+		// Clients sending their body slowly don't lower the limit
+		body := &timedBody{ReadCloser: req.Body, read: time.Now()}
+		req.Body = body
+		defer func() {
+			current, inFlight := limit.release(time.Since(body.read))
+			helpers.SetConcurrency(group, current, inFlight)
+		}()
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// timedBody keeps the time of the last read of the body, from
+// which the latency of the request is measured
+type timedBody struct {
+	io.ReadCloser
+	read time.Time
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read = time.Now()
+	return n, err
+}
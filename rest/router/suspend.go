@@ -3,43 +3,25 @@ package router
 import (
 	"encoding/json"
 	"net/http"
-	"os"
 	"strconv"
 
-	"github.com/Gravitalia/gravitalia/database"
 	"github.com/Gravitalia/gravitalia/model"
+	"github.com/Gravitalia/gravitalia/problem"
+	"github.com/Gravitalia/gravitalia/validate"
 )
 
-// Suspend allows to suspend a user if have
-// a valid authorization
+// Suspend allows internal services and staff to suspend a user
 func Suspend(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	jsonEncoder := json.NewEncoder(w)
 
-	if req.Method != "POST" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorMethodNotAllowed,
-		})
+	vanity := req.URL.Query().Get("vanity")
+	if vanity == "" {
+		problem.Write(w, req, problem.New(problem.InvalidQuery).WithField("vanity", "required", "user to suspend is required"))
 		return
 	}
-
-	if req.Header.Get("authorization") != os.Getenv("GLOBAL_AUTH") {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
-		return
-	}
-
-	if !req.URL.Query().Has("vanity") {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidUser,
-		})
+	if !validate.Format("vanity", vanity) {
+		problem.Write(w, req, problem.New(problem.ValidationFailed).WithField("vanity", "format", "must be a vanity"))
 		return
 	}
 
@@ -47,26 +29,23 @@ func Suspend(w http.ResponseWriter, req *http.Request) {
 	if req.URL.Query().Has("suspend") {
 		d, err := strconv.ParseBool(req.URL.Query().Get("suspend"))
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInvalidQuery,
-			})
+			problem.Write(w, req, problem.New(problem.InvalidQuery).WithField("suspend", "invalid", "must be a boolean"))
 			return
 		}
 		is_suspend = d
 	}
 
-	_, err := database.MakeRequest("MATCH (u:User {name: $id}) SET u.suspended = $suspended;", map[string]interface{}{"id": req.URL.Query().Get("vanity"), "suspended": is_suspend})
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInternalServerError,
-		})
+	if err := store.SetSuspended(req.Context(), vanity, is_suspend); err != nil {
+		problem.Write(w, req, storeError(err, problem.UserNotFound))
 		return
 	}
 
+	if is_suspend {
+		audit(req, "suspended", vanity)
+	} else {
+		audit(req, "unsuspended", vanity)
+	}
+
 	jsonEncoder.Encode(model.RequestError{
 		Error:   false,
 		Message: Ok,
@@ -0,0 +1,156 @@
+package router
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Gravitalia/gravitalia/auth"
+	"github.com/Gravitalia/gravitalia/mux"
+	"github.com/Gravitalia/gravitalia/problem"
+	"github.com/Gravitalia/gravitalia/upload"
+	"github.com/Gravitalia/gravitalia/validate"
+)
+
+// TusVersion is the version of the tus protocol (https://tus.io)
+// followed by resumable uploads
+const TusVersion = "1.0.0"
+
+// tusExtensions are the supported extensions of the protocol
+const tusExtensions = "creation,expiration,termination"
+
+// uploads keeps resumable uploads until they are used by a post
+var uploads *upload.Store
+
+// InitUploads sets the store of resumable uploads
+func InitUploads(u *upload.Store) {
+	uploads = u
+}
+
+// tus checks that the client follows the same version of the
+// protocol, and sets it on every response
+func tus(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Tus-Resumable", TusVersion)
+
+		if req.Method != http.MethodOptions && req.Header.Get("Tus-Resumable") != TusVersion {
+			w.Header().Set("Tus-Version", TusVersion)
+			problem.Write(w, req, problem.New(problem.UnsupportedTus).WithDetail("Tus-Resumable must be %v", TusVersion))
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// uploadOptions describes the supported protocol
+func uploadOptions(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Tus-Version", TusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(uploads.MaxSize(), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createUpload starts an upload of Upload-Length bytes,
+// whose chunks are then sent to its Location
+func createUpload(w http.ResponseWriter, req *http.Request) {
+	length, err := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		problem.Write(w, req, problem.New(problem.InvalidHeader).WithField("Upload-Length", "invalid", "must be the size of the upload in bytes"))
+		return
+	}
+
+	info, err := uploads.Create(auth.Vanity(req.Context()), length, req.Header.Get("Upload-Metadata"))
+	if err != nil {
+		problem.Write(w, req, uploadError(err))
+		return
+	}
+
+	w.Header().Set("Location", "/uploads/"+info.ID)
+	w.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// headUpload returns the number of bytes received,
+// from which the client resumes the upload
+func headUpload(w http.ResponseWriter, req *http.Request) {
+	info, err := uploads.Get(mux.Param(req, "uploadID"), auth.Vanity(req.Context()))
+	if err != nil {
+		problem.Write(w, req, uploadError(err))
+		return
+	}
+
+	setUploadHeaders(w, info)
+	if info.Metadata != "" {
+		w.Header().Set("Upload-Metadata", info.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// appendUpload writes the chunk at Upload-Offset, which must be
+// the number of bytes already received
+func appendUpload(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		problem.Write(w, req, problem.New(problem.UnsupportedMedia).WithDetail("chunks must be sent as application/offset+octet-stream"))
+		return
+	}
+
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		problem.Write(w, req, problem.New(problem.InvalidHeader).WithField("Upload-Offset", "invalid", "must be the number of bytes already sent"))
+		return
+	}
+
+	info, err := uploads.Append(req.Context(), mux.Param(req, "uploadID"), auth.Vanity(req.Context()), offset, req.Body)
+	if err != nil {
+		if info != nil {
+			w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+		}
+		problem.Write(w, req, uploadError(err))
+		return
+	}
+
+	setUploadHeaders(w, info)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteUpload removes an upload which won't be used
+func deleteUpload(w http.ResponseWriter, req *http.Request) {
+	if err := uploads.Delete(req.Context(), mux.Param(req, "uploadID"), auth.Vanity(req.Context())); err != nil {
+		problem.Write(w, req, uploadError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setUploadHeaders describes the progress of the upload
+func setUploadHeaders(w http.ResponseWriter, info *upload.Info) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	w.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// uploadError converts an error of the upload store
+func uploadError(err error) error {
+	switch {
+	case errors.Is(err, upload.ErrNotFound):
+		return problem.Wrap(problem.UploadNotFound, err)
+	case errors.Is(err, upload.ErrOffset):
+		return problem.Wrap(problem.UploadOffset, err).WithDetail("resume from Upload-Offset")
+	case errors.Is(err, upload.ErrLocked):
+		return problem.Wrap(problem.UploadLocked, err).WithDetail("another chunk is being written, retry later")
+	case errors.Is(err, upload.ErrTooLarge):
+		return problem.Wrap(problem.BodyTooLarge, err).WithDetail("uploads must not exceed Upload-Length, nor %d bytes", uploads.MaxSize())
+	}
+
+	// The connection is lost, or the chunk exceeds the body limit
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return validate.BodyError(err, "chunk is incomplete, resume from Upload-Offset")
+	}
+
+	return problem.Wrap(problem.Internal, err)
+}
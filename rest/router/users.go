@@ -3,133 +3,78 @@ package router
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/json"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"strings"
 
+	"github.com/Gravitalia/gravitalia/auth"
 	"github.com/Gravitalia/gravitalia/database"
+	"github.com/Gravitalia/gravitalia/grpc"
 	"github.com/Gravitalia/gravitalia/helpers"
 	"github.com/Gravitalia/gravitalia/model"
+	"github.com/Gravitalia/gravitalia/mux"
+	"github.com/Gravitalia/gravitalia/problem"
+	"github.com/Gravitalia/gravitalia/validate"
 	zipkinhttp "github.com/openzipkin/zipkin-go/middleware/http"
 )
 
-// isAccountBlocked returns a boolean. If one of the both
-// account blocked the other, returns true.
-func isAccountBlocked(id string, to string) (bool, error) {
-	if id == "" || to == "" {
-		return false, nil
-	}
-
-	res, err := database.MakeRequest("MATCH (a:User {name: $id}) MATCH (b:User {name: $to}) OPTIONAL MATCH (a)-[r:BLOCK]-(b) RETURN NOT(r IS NULL);",
-		map[string]any{"id": id, "to": to})
-	if err != nil {
-		log.Printf("(isAccountBlocked) %v", err)
-		return false, err
-	} else if res == nil {
-		return false, nil
-	}
-
-	return res.(bool), nil
-}
-
-// UserHandler routes to the right function
-func UserHandler(w http.ResponseWriter, req *http.Request) {
-	id := strings.TrimPrefix(req.URL.Path, "/users/")
-	if req.Method == http.MethodOptions {
-		Index(w, req)
-	} else if id != "" && req.Method == http.MethodGet {
-		getUser(w, req)
-	} else if id != "" && id == ME && req.Method == http.MethodPatch {
-		update(w, req)
-	}
-}
-
 // GetUser allows getting user data such as posts
 func getUser(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	jsonEncoder := json.NewEncoder(w)
 
-	var me string
-	id := strings.TrimPrefix(req.URL.Path, "/users/")
+	id := mux.Param(req, "vanity")
 	username := id
 
-	authHeader := req.Header.Get("Authorization")
-
 	// Check actual user
-	if authHeader != "" {
-		vanity, err := helpers.CheckToken(authHeader)
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInvalidToken,
-			})
+	me := auth.Vanity(req.Context())
+	if username == ME {
+		if me == "" {
+			problem.Write(w, req, problem.New(problem.MissingToken))
 			return
 		}
-		if username == ME {
-			username = vanity
-		}
-		me = vanity
+		username = me
 	}
 
 	// Get user profile
-	stats, err := database.GetProfile(username)
-	if err != nil || stats.Suspended {
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidUser,
-		})
+	stats, err := store.GetProfile(req.Context(), username)
+	if err != nil {
+		problem.Write(w, req, storeError(err, problem.UserNotFound))
+		return
+	}
+	if stats.Suspended {
+		problem.Write(w, req, problem.New(problem.UserSuspended))
 		return
 	}
 
 	// Check if viewer is following user
 	var viewerFollows bool
-	if authHeader != "" {
-		res, err := database.MakeRequest("MATCH (:User {name: $id})-[r:SUBSCRIBER]->(:User {name: $to}) RETURN NOT(r IS NULL);",
-			map[string]any{"id": me, "to": username})
+	if me != "" {
+		viewerFollows, err = store.IsUserSubscrirerTo(req.Context(), me, username)
 		if err != nil {
-			log.Printf("(getUser) cannot know if user follows: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInvalidRelation,
-			})
+			problem.Write(w, req, storeError(err, problem.UserNotFound))
 			return
 		}
-
-		if res == nil {
-			viewerFollows = false
-		} else {
-			viewerFollows = res.(bool)
-		}
 	}
 
 	// Check if account is blocked
-	isBlocked, err := isAccountBlocked(me, username)
+	isBlocked, err := store.IsBlocked(req.Context(), me, username)
 	if err != nil {
 		isBlocked = false
 	}
 
 	// Check if viewer have access to the user's post
-	allowPostAccess := stats.Public || viewerFollows || (authHeader != "" && id == me)
+	allowPostAccess := stats.Public || viewerFollows || (me != "" && username == me)
 	if isBlocked {
 		allowPostAccess = false
 	}
 
 	posts := make([]model.Post, 0)
 	if allowPostAccess {
-		posts, err = database.GetUserPost(username, 0)
+		posts, err = store.GetUserPost(req.Context(), username, 0)
 		if err != nil {
-			log.Printf("(getUser) cannot get posts: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInvalidUser,
-			})
+			problem.Write(w, req, storeError(err, problem.UserNotFound))
 			return
 		}
 	}
@@ -158,50 +103,28 @@ func getUser(w http.ResponseWriter, req *http.Request) {
 // DeleteUser allows users to delete their account
 func DeleteUser(zipkinClient *zipkinhttp.Client) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		// If method is OPTIONS send OK
-		if req.Method == http.MethodOptions {
-			Index(w, req)
-			return
-		}
-
 		w.Header().Set("Content-Type", "application/json")
 		jsonEncoder := json.NewEncoder(w)
 
-		vanity := ""
-		var err error
-
-		if req.Header.Get("Authorization") == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInvalidToken,
-			})
+		vanity, err := onBehalfOf(req, "user", auth.ScopeDeleteUsers)
+		if err != nil {
+			problem.Write(w, req, err)
 			return
-		} else if req.Header.Get("Authorization") == os.Getenv("GLOBAL_AUTH") {
-			vanity = req.URL.Query().Get("user")
-		} else {
-			vanity, err = helpers.CheckToken(req.Header.Get("Authorization"))
-			if err != nil {
-				w.WriteHeader(http.StatusUnauthorized)
-				jsonEncoder.Encode(model.RequestError{
-					Error:   true,
-					Message: ErrorInvalidToken,
-				})
-				return
-			}
 		}
 
-		_, err = database.MakeRequest("MATCH (u:User {name: $id}) OPTIONAL MATCH (u)-[:WROTE]->(p:Post) OPTIONAL MATCH (u)-[:WROTE]->(c:Comment) OPTIONAL MATCH (u)-[r]-() DETACH DELETE p, c, r, u;",
-			map[string]interface{}{"id": vanity})
+		hashes, err := store.DeleteUser(req.Context(), vanity)
 		if err != nil {
-			log.Printf("(DeleteUser) cannot delete user: %v", err)
-			w.WriteHeader(http.StatusUnauthorized)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInternalServerError,
-			})
+			problem.Write(w, req, storeError(err, problem.UserNotFound))
 			return
 		}
+		audit(req, "deleted account", vanity)
+
+		// Remove medias of the posts no longer used by any post
+		for _, hash := range hashes {
+			if _, err := grpc.DeleteImage(req.Context(), hash); err != nil {
+				log.Printf("(DeleteUser) cannot delete image %v: %v", hash, err)
+			}
+		}
 
 		database.Set(vanity+"-gd", "ok", 3600)
 
@@ -215,7 +138,9 @@ func DeleteUser(zipkinClient *zipkinhttp.Client) http.HandlerFunc {
 			Username: "",
 			Flags:    0,
 		})
-		go makeRequest(zipkinClient, os.Getenv("SEARCH_API")+"/search/delete", "DELETE", bytes.NewBuffer(documentUser), os.Getenv("GLOBAL_AUTH"))
+		background(func(ctx context.Context) {
+			searchRequest(ctx, zipkinClient, http.MethodDelete, "/search/delete", documentUser)
+		})
 
 		jsonEncoder.Encode(model.RequestError{
 			Error:   false,
@@ -229,38 +154,17 @@ func update(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	jsonEncoder := json.NewEncoder(w)
 
-	vanity, err := helpers.CheckToken(req.Header.Get("Authorization"))
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
-		return
-	}
-
-	defer req.Body.Close()
-	body, err := io.ReadAll(req.Body)
+	vanity := auth.Vanity(req.Context())
 
+	getbody, err := validate.Decode[model.UpdateBody](req)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorUnableReadBody,
-		})
+		problem.Write(w, req, err)
 		return
 	}
 
-	var getbody model.UpdateBody
-	json.Unmarshal(body, &getbody)
-
 	if getbody.Public != nil {
-		_, err := database.MakeRequest("MATCH (u:User {name: $id}) SET u.public = $public;", map[string]any{"id": vanity, "public": *getbody.Public})
-		if err != nil {
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: Ok,
-			})
+		if err := store.SetPublic(req.Context(), vanity, *getbody.Public); err != nil {
+			problem.Write(w, req, storeError(err, problem.UserNotFound))
 			return
 		}
 	}
@@ -273,76 +177,35 @@ func update(w http.ResponseWriter, req *http.Request) {
 
 // AcceptOrDecline permits to accept or decline the following request
 func AcceptOrDecline(w http.ResponseWriter, req *http.Request) {
-	// If method is OPTIONS send OK
-	if req.Method == http.MethodOptions {
-		Index(w, req)
-		return
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	jsonEncoder := json.NewEncoder(w)
 
-	choice := strings.TrimPrefix(req.URL.Path, "/request/")
-	if choice != "accept" && choice != "decline" {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidRelation,
-		})
-		return
-	}
+	// Route only accepts "accept" or "decline"
+	choice := mux.Param(req, "choice")
 
-	vanity, err := helpers.CheckToken(req.Header.Get("Authorization"))
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
-		return
-	}
+	vanity := auth.Vanity(req.Context())
 
-	if !req.URL.Query().Has("target") {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidUser,
-		})
+	if req.URL.Query().Get("target") == "" {
+		problem.Write(w, req, problem.New(problem.InvalidQuery).WithField("target", "required", "requester is required"))
 		return
 	}
 
 	// Check if relation exists
-	res, err := database.MakeRequest("MATCH (:User {name: $id})-[r:REQUEST]->(:User {name: $to}) RETURN r;",
-		map[string]any{"id": req.URL.Query().Get("target"), "to": vanity})
+	exists, err := store.RelationExists(req.Context(), req.URL.Query().Get("target"), vanity, "REQUEST")
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorWithDatabase,
-		})
+		problem.Write(w, req, storeError(err, problem.UserNotFound))
 		return
 	}
 
-	if res == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidRelation,
-		})
+	if !exists {
+		problem.Write(w, req, problem.New(problem.RelationNotFound).WithDetail("no subscription request from %v", req.URL.Query().Get("target")))
 		return
 	}
 
 	if choice == "accept" {
 		// Delete old relation, and create new one
-		_, err = database.MakeRequest("MATCH (a:User {name: $id})-[r:REQUEST]->(b:User {name: $to}) DELETE r CREATE (a)-[:SUBSCRIBER]->(b);",
-			map[string]any{"id": req.URL.Query().Get("target"), "to": vanity})
-
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorWithDatabase,
-			})
+		if err := store.AcceptRequest(req.Context(), req.URL.Query().Get("target"), vanity); err != nil {
+			problem.Write(w, req, storeError(err, problem.UserNotFound))
 			return
 		}
 
@@ -355,132 +218,64 @@ func AcceptOrDecline(w http.ResponseWriter, req *http.Request) {
 				Important: false,
 			},
 		)
-		helpers.Publish(req.URL.Query().Get("target"), msg)
+		helpers.Publish(req.Context(), req.URL.Query().Get("target"), msg)
 	} else {
 		// Delete old relation
-		_, err = database.MakeRequest("MATCH (a:User {name: $id})-[r:REQUEST]->(b:User {name: $to}) DELETE r;",
-			map[string]any{"id": req.URL.Query().Get("target"), "to": vanity})
-
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorWithDatabase,
-			})
+		if _, err := store.DeleteRelation(req.Context(), req.URL.Query().Get("target"), vanity, "REQUEST"); err != nil {
+			problem.Write(w, req, storeError(err, problem.UserNotFound))
 			return
 		}
 	}
+
+	jsonEncoder.Encode(model.RequestError{
+		Error:   false,
+		Message: Ok,
+	})
 }
 
 // GetData returns a ZIP folder with two CSV files
 // containing user and liked/created posts data
 func GetData(w http.ResponseWriter, req *http.Request) {
-	// If method is OPTIONS send OK
-	if req.Method == http.MethodOptions {
-		Index(w, req)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	jsonEncoder := json.NewEncoder(w)
-
-	// Check authorization header
-	authToken := req.Header.Get("authorization")
-
-	var vanity string
-	if authToken == os.Getenv("GLOBAL_AUTH") && req.URL.Query().Has("vanity") {
-		vanity = req.URL.Query().Get("vanity")
-	} else if authToken != "" {
-		user, err := helpers.CheckToken(authToken)
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			jsonEncoder.Encode(model.RequestError{
-				Error:   true,
-				Message: ErrorInvalidToken,
-			})
-			return
-		}
-		vanity = user
-	}
-
-	if vanity == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInvalidToken,
-		})
+	vanity, err := onBehalfOf(req, "vanity", auth.ScopeExportUsers)
+	if err != nil {
+		problem.Write(w, req, err)
 		return
 	}
 
 	// Check if data has been recuperated 48 hours ago
 	/*if val, _ := database.Mem.Get(vanity + "-data"); val != nil && string(val.Value) == "ok" {
-		w.WriteHeader(http.StatusBadRequest)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorDataRequested,
-		})
+		problem.Write(w, req, problem.New(problem.DataRequestedSoon))
 		return
 	}*/
 
-	// Create CSV with user data
-	userFilePath, err := database.MakeRequest("WITH \"MATCH (u:User {name: '"+vanity+"'}) RETURN u.name as vanity, u.community as community_id, u.rank as rank, u.public as is_public, u.suspended as is_suspended;\" as query CALL export_util.csv_query(query, \"/var/lib/memgraph/user.csv\", True) YIELD file_path RETURN file_path;",
-		map[string]interface{}{"id": vanity})
+	// Create CSV files with user and posts data
+	userFile, postFile, err := store.ExportData(req.Context(), vanity)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorWithDatabase,
-		})
-		return
-	}
-
-	// Create CSV with posts data
-	postFilePath, err := database.MakeRequest("WITH \"MATCH (u:User {name: '"+vanity+"'})-[r]-(p:Post)-[:CONTAINS]->(m:Media) MATCH (p)-[:SHOW]->(t:Tag) WHERE type(r) = 'CREATE' OR type(r) = 'LIKE' OR type(r) = 'VIEW' OPTIONAL MATCH (p)-[:COMMENT]-(c:Comment)-[:WROTE]-(u) OPTIONAL MATCH (u)-[l:LIKE]->(p) WITH DISTINCT p, m, r, t, count(DISTINCT l) as likes, collect({id: c.id, text: c.text, timestamp: c.timestamp }) as my_comment RETURN p.id as id, p.text as description, [] as images, p.description as automatic_legend, t.name as automatic_tag, likes, type(r) as relation, my_comment\" as query CALL export_util.csv_query(query, \"/var/lib/memgraph/posts.csv\", True) YIELD file_path RETURN file_path;",
-		map[string]interface{}{"id": vanity})
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorWithDatabase,
-		})
+		problem.Write(w, req, storeError(err, problem.UserNotFound))
 		return
 	}
+	audit(req, "exported data of", vanity)
 
 	// Create a buffer to write the ZIP file
 	zipBuffer := new(bytes.Buffer)
 	zipWriter := zip.NewWriter(zipBuffer)
 
 	// Add user CSV file to the ZIP
-	if err := addFileToZip(zipWriter, userFilePath.(string), "user.csv"); err != nil {
-		log.Println("(getData)", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInternalServerError,
-		})
+	if err := addFileToZip(zipWriter, userFile, "user.csv"); err != nil {
+		problem.Write(w, req, err)
 		return
 	}
 
 	// Add post CSV file to the ZIP
-	if err := addFileToZip(zipWriter, postFilePath.(string), "posts.csv"); err != nil {
-		log.Println("(getData)", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInternalServerError,
-		})
+	if err := addFileToZip(zipWriter, postFile, "posts.csv"); err != nil {
+		problem.Write(w, req, err)
 		return
 	}
 
 	// Close the ZIP writer
 	err = zipWriter.Close()
 	if err != nil {
-		log.Println("(getData)", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInternalServerError,
-		})
+		problem.Write(w, req, err)
 		return
 	}
 
@@ -495,33 +290,18 @@ func GetData(w http.ResponseWriter, req *http.Request) {
 	_, err = zipBuffer.WriteTo(w)
 	if err != nil {
 		log.Println("(getData)", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		jsonEncoder.Encode(model.RequestError{
-			Error:   true,
-			Message: ErrorInternalServerError,
-		})
 		return
 	}
 }
 
-func addFileToZip(zipWriter *zip.Writer, filePath, fileName string) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	_, err = file.Stat()
-	if err != nil {
-		return err
-	}
-
+// addFileToZip adds a file with the content to the ZIP
+func addFileToZip(zipWriter *zip.Writer, content []byte, fileName string) error {
 	zipFile, err := zipWriter.Create(fileName)
 	if err != nil {
 		return err
 	}
 
-	_, err = io.Copy(zipFile, file)
+	_, err = zipFile.Write(content)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,69 @@
+// Package saga runs operations made of several steps, such as the
+// creation of a post. When a step fails, the steps already done are
+// compensated, so a failed operation leaves nothing behind
+package saga
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// undoTimeout is the maximum duration of the compensation
+const undoTimeout = 30 * time.Second
+
+// Step is a step of a saga
+type Step struct {
+	Name string
+	// Do runs the step
+	Do func(ctx context.Context) error
+	// Undo compensates Do, nil if there is nothing to undo. It is also
+	// called when Do fails, to undo what Do did before failing
+	Undo func(ctx context.Context) error
+}
+
+// Run runs the steps in order, until one fails. Its error is returned
+// once every started step is undone, in reverse order. Steps are undone
+// even if ctx is canceled, such as when the client leaves
+func Run(ctx context.Context, steps ...Step) error {
+	for i, step := range steps {
+		if err := ctx.Err(); err != nil {
+			undo(ctx, steps[:i])
+			return err
+		}
+
+		if err := step.Do(ctx); err != nil {
+			undo(ctx, steps[:i+1])
+			return err
+		}
+	}
+
+	return nil
+}
+
+// undo compensates the steps in reverse order. Failures are logged,
+// the next steps are still undone
+func undo(parent context.Context, steps []Step) {
+	ctx, cancel := context.WithTimeout(detached{parent}, undoTimeout)
+	defer cancel()
+
+	for i := len(steps) - 1; i >= 0; i-- {
+		if steps[i].Undo == nil {
+			continue
+		}
+
+		if err := steps[i].Undo(ctx); err != nil {
+			log.Printf("(saga) Cannot undo %v: %v", steps[i].Name, err)
+		}
+	}
+}
+
+// detached keeps the values of its parent, such as
+// the trace, but is never canceled with it
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
@@ -0,0 +1,53 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var calls []string
+	step := func(name string, err error) Step {
+		return Step{
+			Name: name,
+			Do: func(context.Context) error {
+				calls = append(calls, name)
+				return err
+			},
+			Undo: func(ctx context.Context) error {
+				if ctx.Err() != nil {
+					t.Errorf("%v is undone with a canceled context", name)
+				}
+				calls = append(calls, "undo "+name)
+				return nil
+			},
+		}
+	}
+
+	if err := Run(context.Background(), step("upload", nil), step("persist", nil)); err != nil || len(calls) != 2 {
+		t.Fatalf("successful saga got %v and %v", calls, err)
+	}
+
+	calls = nil
+	failure := errors.New("database is down")
+	validate := Step{Name: "validate", Do: func(context.Context) error { return nil }}
+	if err := Run(context.Background(), validate, step("upload", nil), step("persist", failure), step("notify", nil)); !errors.Is(err, failure) {
+		t.Fatalf("failed saga got %v, want %v", err, failure)
+	}
+	if want := []string{"upload", "persist", "undo persist", "undo upload"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("got %v, want %v", calls, want)
+	}
+
+	// Steps are undone even if the caller left
+	calls = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	leave := Step{Name: "moderate", Do: func(context.Context) error { cancel(); return nil }}
+	if err := Run(ctx, step("upload", nil), leave, step("persist", nil)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled saga got %v", err)
+	}
+	if want := []string{"upload", "undo upload"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("got %v, want %v", calls, want)
+	}
+}
@@ -0,0 +1,283 @@
+// Package upload keeps resumable uploads of images, received in
+// chunks, until they are used by a post or expire. Each upload is
+// a data file, growing with each chunk, and a JSON info file
+package upload
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/Gravitalia/gravitalia/filestore"
+)
+
+var (
+	ErrNotFound   = errors.New("upload not found")
+	ErrLocked     = errors.New("upload is being written by another request")
+	ErrOffset     = errors.New("offset doesn't match the bytes already received")
+	ErrTooLarge   = errors.New("upload is larger than allowed")
+	ErrIncomplete = errors.New("upload is not complete")
+)
+
+// lockTTL is the longest time a chunk can be written. Locks
+// of instances stopped while writing are released after it
+const lockTTL = 10 * time.Minute
+
+// Info describes an upload
+type Info struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	// Length is the size of the complete upload
+	Length int64 `json:"length"`
+	// Metadata is sent back as is to the client
+	Metadata  string    `json:"metadata,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Offset is the number of bytes received
+	Offset int64 `json:"-"`
+}
+
+// Complete checks if every byte is received
+func (info *Info) Complete() bool {
+	return info.Offset == info.Length
+}
+
+// Store keeps uploads in a directory
+type Store struct {
+	dir        filestore.Dir
+	maxSize    int64
+	expiration time.Duration
+	// locker prevents two requests, maybe of different
+	// instances, from writing the same upload at once
+	locker filestore.Locker
+}
+
+// NewStore creates the directory of uploads if needed. Uploads can't
+// be larger than maxSize, and expire after the configured duration
+func NewStore(cfg config.Uploads, maxSize int64, locker filestore.Locker) (*Store, error) {
+	path := cfg.Dir
+	if path == "" {
+		path = filepath.Join(os.TempDir(), "gravitalia-uploads")
+	}
+
+	dir, err := filestore.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		dir:        dir,
+		maxSize:    maxSize,
+		expiration: cfg.Expiration,
+		locker:     locker,
+	}, nil
+}
+
+// MaxSize returns the maximum size of uploads
+func (s *Store) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Create starts an empty upload of length bytes for the owner
+func (s *Store) Create(owner string, length int64, metadata string) (*Info, error) {
+	if length > s.maxSize {
+		return nil, ErrTooLarge
+	}
+
+	id, err := filestore.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{
+		ID:        id,
+		Owner:     owner,
+		Length:    length,
+		Metadata:  metadata,
+		ExpiresAt: time.Now().Add(s.expiration),
+	}
+
+	data, err := os.OpenFile(s.dir.Path(info.ID, ".bin"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	data.Close()
+
+	return info, s.save(info)
+}
+
+// Get returns the upload of the owner. Uploads of other users,
+// and expired ones, are not found
+func (s *Store) Get(id string, owner string) (*Info, error) {
+	if !filestore.ValidID(id) {
+		return nil, ErrNotFound
+	}
+
+	info, err := s.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if info.Owner != owner || time.Now().After(info.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+
+	return info, nil
+}
+
+// Append writes the chunk at offset, which must be the number of bytes
+// already received. Bytes received before an error, such as a lost
+// connection, are kept so the client resumes from the new offset.
+// The expiration is pushed back after each chunk
+func (s *Store) Append(ctx context.Context, id string, owner string, offset int64, chunk io.Reader) (*Info, error) {
+	unlock, err := s.lock(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	info, err := s.Get(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	if offset != info.Offset {
+		return info, ErrOffset
+	}
+
+	data, err := os.OpenFile(s.dir.Path(id, ".bin"), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// One more byte than expected tells if the chunk is too large
+	n, err := io.Copy(data, io.LimitReader(chunk, info.Length-info.Offset+1))
+	if closeErr := data.Close(); err == nil {
+		err = closeErr
+	}
+
+	if info.Offset+n > info.Length {
+		// The whole chunk is refused
+		if truncateErr := os.Truncate(s.dir.Path(id, ".bin"), offset); truncateErr != nil {
+			return nil, truncateErr
+		}
+		return info, ErrTooLarge
+	}
+
+	info.Offset += n
+	info.ExpiresAt = time.Now().Add(s.expiration)
+	if saveErr := s.save(info); err == nil {
+		err = saveErr
+	}
+
+	return info, err
+}
+
+// Read returns the content of a complete upload
+func (s *Store) Read(id string, owner string) ([]byte, error) {
+	info, err := s.Get(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Complete() {
+		return nil, ErrIncomplete
+	}
+
+	return os.ReadFile(s.dir.Path(id, ".bin"))
+}
+
+// Delete removes the upload of the owner
+func (s *Store) Delete(ctx context.Context, id string, owner string) error {
+	unlock, err := s.lock(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := s.Get(id, owner); err != nil {
+		return err
+	}
+
+	return s.dir.Remove(id)
+}
+
+// Sweep removes expired uploads, except the ones being written,
+// and returns how many were removed
+func (s *Store) Sweep(ctx context.Context) (int, error) {
+	var removed int
+	err := s.dir.Scan(s.expiration, func(id string) {
+		info, err := s.load(id)
+		if err != nil || time.Now().Before(info.ExpiresAt) {
+			return
+		}
+
+		unlock, err := s.lock(ctx, id)
+		if err != nil {
+			return
+		}
+		defer unlock()
+
+		if err := s.dir.Remove(id); err != nil {
+			log.Printf("(Sweep) Cannot remove upload %v: %v", id, err)
+		} else {
+			removed++
+		}
+	})
+
+	return removed, err
+}
+
+// SweepEvery removes expired uploads at each interval, until ctx is done
+func (s *Store) SweepEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if removed, err := s.Sweep(ctx); err != nil {
+				log.Printf("Cannot remove expired uploads: %v", err)
+			} else if removed != 0 {
+				log.Printf("Removed %d expired uploads", removed)
+			}
+		}
+	}
+}
+
+// lock takes the lock of the upload, and returns the function releasing it
+func (s *Store) lock(ctx context.Context, id string) (func(), error) {
+	return filestore.Lock(ctx, s.locker, "upload-"+id, lockTTL, ErrLocked)
+}
+
+// load reads the info of the upload, and its offset from the size of its data
+func (s *Store) load(id string) (*Info, error) {
+	info := &Info{}
+	err := s.dir.Load(id, info)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(s.dir.Path(id, ".bin"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Offset = stat.Size()
+
+	return info, nil
+}
+
+// save writes the info of the upload
+func (s *Store) save(info *Info) error {
+	return s.dir.Save(info.ID, info)
+}
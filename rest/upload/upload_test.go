@@ -0,0 +1,103 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/Gravitalia/gravitalia/config"
+	"github.com/Gravitalia/gravitalia/filestore"
+)
+
+var ctx = context.Background()
+
+func newStore(t *testing.T, expiration time.Duration) *Store {
+	t.Helper()
+
+	s, err := NewStore(config.Uploads{Dir: t.TempDir(), Expiration: expiration}, 10, filestore.NewMemoryLocks())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestAppend(t *testing.T) {
+	s := newStore(t, time.Hour)
+
+	if _, err := s.Create("author", 11, ""); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("upload larger than the maximum got %v, want %v", err, ErrTooLarge)
+	}
+
+	info, err := s.Create("author", 8, "filename Y2F0LnBuZw==")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection is lost after 3 bytes, which are kept
+	lost := io.MultiReader(bytes.NewReader([]byte("abc")), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if info, err = s.Append(ctx, info.ID, "author", 0, lost); !errors.Is(err, io.ErrUnexpectedEOF) || info.Offset != 3 {
+		t.Fatalf("lost chunk got offset %v and %v, want 3", info.Offset, err)
+	}
+
+	if _, err := s.Read(info.ID, "author"); !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("incomplete upload read with %v, want %v", err, ErrIncomplete)
+	}
+	if _, err := s.Append(ctx, info.ID, "author", 0, bytes.NewReader([]byte("abc"))); !errors.Is(err, ErrOffset) {
+		t.Fatalf("chunk at a previous offset got %v, want %v", err, ErrOffset)
+	}
+	if _, err := s.Append(ctx, info.ID, "stranger", 3, bytes.NewReader([]byte("def"))); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("chunk of another user got %v, want %v", err, ErrNotFound)
+	}
+	if _, err := s.Append(ctx, info.ID, "author", 3, bytes.NewReader([]byte("defghi"))); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("chunk larger than the upload got %v, want %v", err, ErrTooLarge)
+	}
+
+	if info, err = s.Append(ctx, info.ID, "author", 3, bytes.NewReader([]byte("defgh"))); err != nil || !info.Complete() {
+		t.Fatalf("last chunk got %+v and %v", info, err)
+	}
+
+	content, err := s.Read(info.ID, "author")
+	if err != nil || string(content) != "abcdefgh" {
+		t.Fatalf("read %q and %v, want %q", content, err, "abcdefgh")
+	}
+
+	if err := s.Delete(ctx, info.ID, "author"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(info.ID, "author"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleted upload got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestLocked(t *testing.T) {
+	s := newStore(t, time.Hour)
+	info, _ := s.Create("author", 4, "")
+
+	s.locker.Lock(ctx, "upload-"+info.ID, time.Minute)
+	if _, err := s.Append(ctx, info.ID, "author", 0, bytes.NewReader([]byte("abcd"))); !errors.Is(err, ErrLocked) {
+		t.Fatalf("concurrent chunk got %v, want %v", err, ErrLocked)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	s := newStore(t, time.Millisecond)
+	info, _ := s.Create("author", 4, "")
+
+	time.Sleep(5 * time.Millisecond)
+	if _, err := s.Get(info.ID, "author"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expired upload got %v, want %v", err, ErrNotFound)
+	}
+
+	if removed, err := s.Sweep(ctx); err != nil || removed != 1 {
+		t.Fatalf("removed %d uploads and %v, want 1", removed, err)
+	}
+	if entries, _ := os.ReadDir(string(s.dir)); len(entries) != 0 {
+		t.Fatalf("%d files are left", len(entries))
+	}
+}
@@ -0,0 +1,287 @@
+// Package validate checks request bodies against the rules
+// declared in the validate tag of their fields:
+//
+//	Content string `json:"content" validate:"required,max=500"`
+//
+// Rules are separated by commas:
+//   - required: the field can't be empty, nor only spaces
+//   - omitempty: other rules are skipped if the field is empty,
+//     they are checked otherwise, so min=1 rejects empty lists
+//   - min=n, max=n: length of strings, in graphemes, or of lists
+//   - itembytes=n: maximum size in bytes of each item of a list
+//   - format=a|b: strings must match one of the formats, such as vanity or id
+//
+// Fields are named as in JSON. Nested structures are checked too
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Gravitalia/gravitalia/problem"
+	"github.com/rivo/uniseg"
+)
+
+// formats of strings checked by the format rule
+var formats = map[string]*regexp.Regexp{
+	// vanity is the unique name of a user
+	"vanity": regexp.MustCompile(`^[a-zA-Z0-9_.-]{2,32}$`),
+	// id is a snowflake, such as the ID of a post or a comment
+	"id": regexp.MustCompile(`^[0-9]{1,20}$`),
+}
+
+// rule is a parsed rule of a field
+type rule struct {
+	name  string
+	param string
+	n     int
+}
+
+// field is a field of a structure with rules
+type field struct {
+	index     int
+	name      string
+	rules     []rule
+	omitempty bool
+}
+
+// fields caches the fields of each checked type
+var fields sync.Map
+
+// Decode reads the JSON body of the request into a T, then checks
+// its rules. Bodies larger than the limit of the route, problems
+// in the JSON and broken rules are returned as problems
+func Decode[T any](req *http.Request) (T, error) {
+	var body T
+
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		if errors.Is(err, io.EOF) {
+			return body, problem.New(problem.InvalidBody).WithDetail("body is required")
+		}
+
+		return body, BodyError(err, "body is not valid JSON")
+	}
+
+	return body, Check(body)
+}
+
+// BodyError converts an error reading the body to a problem:
+// bodies larger than the limit of the route, or invalid ones
+func BodyError(err error, detail string) *problem.Problem {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return problem.Wrap(problem.BodyTooLarge, err).WithDetail("body must not exceed %d bytes", tooLarge.Limit)
+	}
+
+	return problem.Wrap(problem.InvalidBody, err).WithDetail("%v", detail)
+}
+
+// Check checks the rules of v, and returns a problem
+// listing the invalid fields if any
+func Check(v any) error {
+	errs := Struct(v)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	p := problem.New(problem.ValidationFailed)
+	p.Fields = errs
+	return p
+}
+
+// Format checks that the string has the format, such as vanity or
+// id. Unknown formats never match
+func Format(format string, value string) bool {
+	re, ok := formats[format]
+	return ok && re.MatchString(value)
+}
+
+// Struct checks the rules of v, a structure or a pointer to
+// a structure, and returns the invalid fields
+func Struct(v any) []problem.FieldError {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	return check(value, "")
+}
+
+// check returns the first broken rule of each field of the structure
+func check(value reflect.Value, prefix string) []problem.FieldError {
+	var errs []problem.FieldError
+
+	for _, f := range fieldsOf(value.Type()) {
+		v := value.Field(f.index)
+		name := prefix + f.name
+
+		if empty(v) {
+			if f.has("required") {
+				errs = append(errs, problem.FieldError{Field: name, Code: "required", Detail: "is required"})
+				continue
+			}
+			if f.omitempty || (v.Kind() == reflect.Pointer && v.IsNil()) {
+				continue
+			}
+		}
+
+		v = reflect.Indirect(v)
+		if v.Kind() == reflect.Struct {
+			errs = append(errs, check(v, name+".")...)
+		}
+
+		for _, r := range f.rules {
+			if err := r.check(v, name); err != nil {
+				errs = append(errs, *err)
+				break
+			}
+		}
+	}
+
+	return errs
+}
+
+// check returns an error if the value breaks the rule
+func (r rule) check(v reflect.Value, name string) *problem.FieldError {
+	switch r.name {
+	case "min":
+		if length(v) < r.n {
+			return &problem.FieldError{Field: name, Code: "min", Detail: fmt.Sprintf("must have at least %d %v", r.n, unit(v))}
+		}
+	case "max":
+		if length(v) > r.n {
+			return &problem.FieldError{Field: name, Code: "max", Detail: fmt.Sprintf("must have at most %d %v", r.n, unit(v))}
+		}
+	case "itembytes":
+		for i := 0; i < v.Len(); i++ {
+			if v.Index(i).Len() > r.n {
+				return &problem.FieldError{Field: fmt.Sprintf("%v[%d]", name, i), Code: "too_large", Detail: fmt.Sprintf("must not exceed %d bytes", r.n)}
+			}
+		}
+	case "format":
+		for _, format := range strings.Split(r.param, "|") {
+			if formats[format].MatchString(v.String()) {
+				return nil
+			}
+		}
+		return &problem.FieldError{Field: name, Code: "format", Detail: "must be a valid " + strings.ReplaceAll(r.param, "|", " or ")}
+	}
+
+	return nil
+}
+
+// has checks if the field has the rule
+func (f field) has(name string) bool {
+	for _, r := range f.rules {
+		if r.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fieldsOf parses the rules of the fields of the type,
+// and panics if a rule is not valid
+func fieldsOf(t reflect.Type) []field {
+	if cached, ok := fields.Load(t); ok {
+		return cached.([]field)
+	}
+
+	parsed := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		info := t.Field(i)
+		if !info.IsExported() {
+			continue
+		}
+
+		f := field{index: i, name: info.Name}
+		if name, _, _ := strings.Cut(info.Tag.Get("json"), ","); name == "-" {
+			continue
+		} else if name != "" {
+			f.name = name
+		}
+
+		for _, tag := range strings.Split(info.Tag.Get("validate"), ",") {
+			name, param, _ := strings.Cut(strings.TrimSpace(tag), "=")
+			r := rule{name: name, param: param}
+
+			switch name {
+			case "":
+				continue
+			case "omitempty":
+				f.omitempty = true
+				continue
+			case "required":
+			case "min", "max", "itembytes":
+				n, err := strconv.Atoi(param)
+				if err != nil || n < 0 {
+					panic(fmt.Sprintf("validate: %v.%v: invalid %v %q", t.Name(), info.Name, name, param))
+				}
+				r.n = n
+			case "format":
+				for _, format := range strings.Split(param, "|") {
+					if _, ok := formats[format]; !ok {
+						panic(fmt.Sprintf("validate: %v.%v: unknown format %q", t.Name(), info.Name, format))
+					}
+				}
+			default:
+				panic(fmt.Sprintf("validate: %v.%v: unknown rule %q", t.Name(), info.Name, name))
+			}
+
+			f.rules = append(f.rules, r)
+		}
+
+		parsed = append(parsed, f)
+	}
+
+	fields.Store(t, parsed)
+	return parsed
+}
+
+// empty checks if the value is empty. Strings with only spaces
+// are empty, structures never are so their fields get checked
+func empty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Struct:
+		return false
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	}
+
+	return v.IsZero()
+}
+
+// length returns the number of graphemes of strings, so an emoji
+// counts once, or the number of items of lists
+func length(v reflect.Value) int {
+	if v.Kind() == reflect.String {
+		return uniseg.GraphemeClusterCount(v.String())
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len()
+	}
+
+	return 0
+}
+
+// unit names what is counted by length
+func unit(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return "characters"
+	}
+
+	return "items"
+}
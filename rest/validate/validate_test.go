@@ -0,0 +1,106 @@
+package validate
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Gravitalia/gravitalia/problem"
+)
+
+type body struct {
+	Content string   `json:"content" validate:"required,max=5"`
+	Reply   string   `json:"reply" validate:"omitempty,format=id"`
+	Target  string   `json:"target" validate:"format=vanity|id"`
+	Images  [][]byte `json:"images" validate:"min=1,max=2,itembytes=4"`
+	Author  author   `json:"author"`
+	Ignored string   `json:"-" validate:"required"`
+}
+
+type author struct {
+	Vanity string `json:"vanity" validate:"required,format=vanity"`
+}
+
+func TestStruct(t *testing.T) {
+	valid := body{Content: "héllo", Target: "realhinome", Images: [][]byte{[]byte("png")}, Author: author{Vanity: "realhinome"}}
+	if errs := Struct(valid); len(errs) != 0 {
+		t.Fatalf("valid body got %v", errs)
+	}
+
+	for _, test := range []struct {
+		body  body
+		field string
+		code  string
+	}{
+		{body{Content: "   "}, "content", "required"},
+		// Emojis made of several code points are a single grapheme
+		{body{Content: "👩‍👩‍👧‍👦🇫🇷a"}, "", ""},
+		{body{Content: "abcdef"}, "content", "max"},
+		{body{Reply: "not-an-id"}, "reply", "format"},
+		{body{Target: "<script>"}, "target", "format"},
+		{body{Images: [][]byte{{}, {}, {}}}, "images", "max"},
+		{body{Images: [][]byte{[]byte("png"), []byte("large")}}, "images[1]", "too_large"},
+		{body{Author: author{Vanity: "a b"}}, "author.vanity", "format"},
+	} {
+		if test.body.Content == "" {
+			test.body.Content = "ok"
+		}
+		if test.body.Target == "" {
+			test.body.Target = "1234"
+		}
+		if test.body.Author.Vanity == "" {
+			test.body.Author.Vanity = "realhinome"
+		}
+		if test.body.Images == nil {
+			test.body.Images = [][]byte{{}}
+		}
+
+		errs := Struct(&test.body)
+		if test.field == "" {
+			if len(errs) != 0 {
+				t.Errorf("%+v: got %v, want no error", test.body, errs)
+			}
+			continue
+		}
+
+		if len(errs) != 1 || errs[0].Field != test.field || errs[0].Code != test.code {
+			t.Errorf("%+v: got %v, want %v %v", test.body, errs, test.field, test.code)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, test := range []struct {
+		body string
+		code problem.Code
+	}{
+		{`{"content":"hello","target":"1","images":["cG5n"],"author":{"vanity":"realhinome"}}`, ""},
+		{``, problem.InvalidBody},
+		{`{"content":`, problem.InvalidBody},
+		{`{"content":"hello","target":"1","images":[],"author":{"vanity":"realhinome"}}`, problem.ValidationFailed},
+		{`{"content":"` + strings.Repeat("a", 200) + `"}`, problem.BodyTooLarge},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		req.Body = http.MaxBytesReader(httptest.NewRecorder(), req.Body, 100)
+
+		_, err := Decode[body](req)
+		var p *problem.Problem
+		if test.code == "" && err != nil || test.code != "" && (!errors.As(err, &p) || p.Code != test.code) {
+			t.Errorf("%q: got %v, want %q", test.body, err, test.code)
+		}
+	}
+}
+
+func TestInvalidRule(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("unknown rule should panic")
+		}
+	}()
+
+	Struct(struct {
+		Name string `validate:"maximum=3"`
+	}{})
+}
@@ -5,6 +5,7 @@ use std::sync::Arc;
 pub mod model;
 pub mod router;
 pub mod database;
+pub mod signature;
 
 #[derive(Debug)]
 struct UnknownError;
@@ -53,10 +54,10 @@ async fn main() {
     let routes = warp::path("search")
                     .and(warp::path("add"))
                     .and(warp::post())
-                    .and(warp::body::json())
+                    .and(warp::body::bytes())
                     .and(warp::header("authorization"))
                     .and(warp::any().map(move || Arc::clone(&meili)))
-                    .and_then(|body: model::User, token: String, conn: Arc<meilisearch_sdk::indexes::Index>| async {
+                    .and_then(|body: warp::hyper::body::Bytes, token: String, conn: Arc<meilisearch_sdk::indexes::Index>| async {
                         match router::add::add(body, token, conn).await {
                             Ok(r) => {
                                 Ok(r)
@@ -70,10 +71,10 @@ async fn main() {
                     warp::path("search")
                     .and(warp::path("delete"))
                     .and(warp::delete())
-                    .and(warp::body::json())
+                    .and(warp::body::bytes())
                     .and(warp::header("authorization"))
                     .and(warp::any().map(move || Arc::clone(&meili1)))
-                    .and_then(|body: model::User, token: String, conn: Arc<meilisearch_sdk::indexes::Index>| async {
+                    .and_then(|body: warp::hyper::body::Bytes, token: String, conn: Arc<meilisearch_sdk::indexes::Index>| async {
                         match router::del::delete(body, token, conn).await {
                             Ok(r) => {
                                 Ok(r)
@@ -1,20 +1,33 @@
-use warp::{reply::{WithStatus, Json}, http::StatusCode};
+use warp::{reply::{WithStatus, Json}, http::StatusCode, hyper::body::Bytes};
 use anyhow::Result;
 use crate::model;
 
 /// This route allows to create a new document
-pub async fn add(body: model::User, authorization: String, meili: std::sync::Arc<meilisearch_sdk::indexes::Index>) -> Result<WithStatus<Json>> {
-    // Check if token is valid
-    if authorization != dotenv::var("GLOBAL_AUTH")? {
+pub async fn add(body: Bytes, authorization: String, meili: std::sync::Arc<meilisearch_sdk::indexes::Index>) -> Result<WithStatus<Json>> {
+    // Check if the request is signed by a service
+    if !crate::signature::verify("POST", "/search/add", &authorization, &body) {
         return Ok(warp::reply::with_status(warp::reply::json(
             &model::Error{
                 error: true,
-                message: "Invalid token".to_string(),
+                message: "Invalid signature".to_string(),
             }
         ),
         StatusCode::UNAUTHORIZED))
     }
 
+    let body: model::User = match serde_json::from_slice(&body) {
+        Ok(user) => user,
+        Err(e) => {
+            return Ok(warp::reply::with_status(warp::reply::json(
+                &model::Error{
+                    error: true,
+                    message: e.to_string(),
+                }
+            ),
+            StatusCode::BAD_REQUEST))
+        }
+    };
+
     match crate::database::add_document(body, meili).await {
         Ok(_) => {},
         Err(e) => {
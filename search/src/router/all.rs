@@ -4,12 +4,12 @@ use anyhow::Result;
 
 /// This route allows to create a new document
 pub async fn users(authorization: String, meili: std::sync::Arc<meilisearch_sdk::indexes::Index>) -> Result<WithStatus<Json>> {
-    // Check if token is valid
-    if authorization != dotenv::var("GLOBAL_AUTH")? {
+    // Check if the request is signed by a service
+    if !crate::signature::verify("GET", "/search/all_users", &authorization, &[]) {
         return Ok(warp::reply::with_status(warp::reply::json(
             &crate::model::Error{
                 error: true,
-                message: "Invalid token".to_string(),
+                message: "Invalid signature".to_string(),
             }
         ),
         StatusCode::UNAUTHORIZED))
@@ -0,0 +1,93 @@
+use base64::{engine::general_purpose::URL_SAFE_NO_PAD, Engine};
+use hmac::{Hmac, Mac};
+use sha2::{Digest, Sha256};
+use std::collections::HashMap;
+use std::sync::Mutex;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+/// Scheme of the Authorization header of signed requests:
+/// GRAVITALIA-HMAC-SHA256 service=gravitalia,timestamp=1700000000,nonce=...,signature=...
+const SCHEME: &str = "GRAVITALIA-HMAC-SHA256 ";
+
+/// Maximum difference, in seconds, between the time
+/// of a signature and the time of this service
+const MAX_SKEW: u64 = 300;
+
+/// Nonces already received, with the time they can be forgotten
+static NONCES: Mutex<Option<HashMap<String, u64>>> = Mutex::new(None);
+
+/// This function checks that the request is signed by a service
+/// with its key of SERVICE_KEYS, such as "gravitalia=...", and was
+/// not already received. The signature is the base64url HMAC-SHA256
+/// of the method, the URI, the timestamp, the nonce and the hex
+/// SHA-256 of the body, separated by new lines
+pub fn verify(method: &str, uri: &str, authorization: &str, body: &[u8]) -> bool {
+    let params = match authorization.strip_prefix(SCHEME) {
+        Some(params) => params,
+        None => return false,
+    };
+
+    let (mut service, mut timestamp, mut nonce, mut signature) = ("", "", "", "");
+    for param in params.split(',') {
+        match param.trim().split_once('=') {
+            Some(("service", value)) => service = value,
+            Some(("timestamp", value)) => timestamp = value,
+            Some(("nonce", value)) => nonce = value,
+            Some(("signature", value)) => signature = value,
+            _ => {}
+        }
+    }
+    if nonce.len() < 16 || nonce.len() > 64 || !nonce.chars().all(|c| c.is_ascii_hexdigit()) {
+        return false;
+    }
+
+    let keys = dotenv::var("SERVICE_KEYS").unwrap_or_default();
+    let key = match keys
+        .split(',')
+        .filter_map(|entry| entry.trim().split_once('='))
+        .find(|(name, _)| !service.is_empty() && *name == service)
+    {
+        Some((_, key)) => key,
+        None => return false,
+    };
+
+    let now = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|d| d.as_secs())
+        .unwrap_or_default();
+    match timestamp.parse::<u64>() {
+        Ok(time) if now.abs_diff(time) <= MAX_SKEW => {}
+        _ => return false,
+    }
+
+    let expected = match URL_SAFE_NO_PAD.decode(signature) {
+        Ok(expected) => expected,
+        Err(_) => return false,
+    };
+    let mut mac = match Hmac::<Sha256>::new_from_slice(key.as_bytes()) {
+        Ok(mac) => mac,
+        Err(_) => return false,
+    };
+    mac.update(
+        format!(
+            "{}\n{}\n{}\n{}\n{}",
+            method,
+            uri,
+            timestamp,
+            nonce,
+            hex::encode(Sha256::digest(body))
+        )
+        .as_bytes(),
+    );
+    if mac.verify_slice(&expected).is_err() {
+        return false;
+    }
+
+    // A nonce outlives the validity of its signature
+    let mut nonces = NONCES.lock().unwrap_or_else(|e| e.into_inner());
+    let nonces = nonces.get_or_insert_with(HashMap::new);
+    nonces.retain(|_, forget| *forget > now);
+    nonces
+        .insert(format!("{}:{}", service, nonce), now + 2 * MAX_SKEW)
+        .is_none()
+}